@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+// handshakeAt builds the handshake a client would send at issued
+func handshakeAt(secret string, issued time.Time, nonce string) url.Values {
+	timestamp := issued.Unix()
+	return url.Values{
+		ParamTimestamp: {strconv.FormatInt(timestamp, 10)},
+		ParamNonce:     {nonce},
+		ParamSignature: {Sign(secret, timestamp, nonce)},
+	}
+}
+
+func TestVerifyAcceptsValidHandshake(t *testing.T) {
+	verifier := NewVerifier(testSecret, time.Minute)
+	handshake, err := NewHandshake(testSecret)
+	if err != nil {
+		t.Fatalf("NewHandshake: %v", err)
+	}
+	if err := verifier.Verify(handshake); err != nil {
+		t.Fatalf("valid handshake rejected: %v", err)
+	}
+}
+
+func TestVerifySignedURL(t *testing.T) {
+	verifier := NewVerifier(testSecret, time.Minute)
+	signed, err := SignURL("ws://localhost:8080/audio?keep=1", testSecret)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("parse %q: %v", signed, err)
+	}
+	if u.Query().Get("keep") != "1" {
+		t.Errorf("SignURL dropped the existing query: %q", signed)
+	}
+	if err := verifier.Verify(u.Query()); err != nil {
+		t.Fatalf("signed URL rejected: %v", err)
+	}
+}
+
+func TestVerifyRejections(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		handshake url.Values
+		want      error
+	}{
+		{"missing", url.Values{}, ErrMissing},
+		{"malformed timestamp", url.Values{ParamTimestamp: {"soon"}, ParamNonce: {"n"}, ParamSignature: {"s"}}, ErrMalformed},
+		{"stale", handshakeAt(testSecret, now.Add(-2*time.Minute), "stale-nonce"), ErrStale},
+		{"from the future", handshakeAt(testSecret, now.Add(2*time.Minute), "future-nonce"), ErrStale},
+		{"forged", handshakeAt("other-secret", now, "forged-nonce"), ErrForged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewVerifier(testSecret, time.Minute)
+			if err := verifier.Verify(tt.handshake); !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedNonce(t *testing.T) {
+	verifier := NewVerifier(testSecret, time.Minute)
+	handshake := handshakeAt(testSecret, time.Now(), "signed-nonce")
+	handshake.Set(ParamNonce, "other-nonce")
+	if err := verifier.Verify(handshake); !errors.Is(err, ErrForged) {
+		t.Fatalf("Verify = %v, want %v", err, ErrForged)
+	}
+}
+
+func TestVerifyRejectsReplay(t *testing.T) {
+	verifier := NewVerifier(testSecret, time.Minute)
+	handshake := handshakeAt(testSecret, time.Now(), "replayed-nonce")
+	if err := verifier.Verify(handshake); err != nil {
+		t.Fatalf("first use rejected: %v", err)
+	}
+	if err := verifier.Verify(handshake); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("replay: Verify = %v, want %v", err, ErrReplayed)
+	}
+}
+
+func TestNewVerifierDefaultWindow(t *testing.T) {
+	verifier := NewVerifier(testSecret, 0)
+	if verifier.window != DefaultWindow {
+		t.Fatalf("window = %v, want %v", verifier.window, DefaultWindow)
+	}
+	handshake := handshakeAt(testSecret, time.Now().Add(-DefaultWindow/2), "default-window-nonce")
+	if err := verifier.Verify(handshake); err != nil {
+		t.Fatalf("handshake inside the default window rejected: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Package auth implements the optional HMAC handshake on WebSocket
+// connections. The client adds a timestamp, a random nonce and
+// HMAC-SHA256(secret, "<ts>.<nonce>") to the connection URL; the server
+// accepts each signature once and only within a window around its own clock,
+// so a captured URL cannot be replayed.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Query parameters carrying the handshake
+const (
+	ParamTimestamp = "ts"    // Unix seconds when the client connected
+	ParamNonce     = "nonce" // Random hex, unique per connection
+	ParamSignature = "sig"   // Hex HMAC-SHA256 of "<ts>.<nonce>"
+)
+
+// DefaultWindow is how far a timestamp may be from the server's clock
+const DefaultWindow = 30 * time.Second
+
+// Handshake rejections
+var (
+	ErrMissing   = errors.New("missing handshake parameters")
+	ErrMalformed = errors.New("malformed handshake timestamp")
+	ErrStale     = errors.New("handshake timestamp outside the allowed window")
+	ErrForged    = errors.New("handshake signature does not match")
+	ErrReplayed  = errors.New("handshake nonce already used")
+)
+
+// Sign returns the signature of timestamp and nonce under secret
+func Sign(secret string, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", timestamp, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewHandshake returns a fresh handshake for secret, for transports that
+// carry it elsewhere than in a URL (e.g. gRPC metadata)
+func NewHandshake(secret string) (url.Values, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Unix()
+	handshake := url.Values{}
+	handshake.Set(ParamTimestamp, strconv.FormatInt(timestamp, 10))
+	handshake.Set(ParamNonce, hex.EncodeToString(nonce))
+	handshake.Set(ParamSignature, Sign(secret, timestamp, handshake.Get(ParamNonce)))
+	return handshake, nil
+}
+
+// SignURL adds a fresh handshake for secret to the query of uri
+func SignURL(uri string, secret string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	handshake, err := NewHandshake(secret)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for key := range handshake {
+		query.Set(key, handshake.Get(key))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verifier checks handshakes against a shared secret and remembers the
+// nonces seen within the window
+type Verifier struct {
+	secret string
+	window time.Duration
+
+	mutex sync.Mutex
+	seen  map[string]time.Time // Nonce to when it stops being acceptable anyway
+}
+
+// NewVerifier creates a verifier accepting timestamps within window of the
+// server's clock; a window of 0 or less uses DefaultWindow
+func NewVerifier(secret string, window time.Duration) *Verifier {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Verifier{
+		secret: secret,
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Verify checks the handshake in query, consuming its nonce on success
+func (v *Verifier) Verify(query url.Values) error {
+	tsParam, nonce, signature := query.Get(ParamTimestamp), query.Get(ParamNonce), query.Get(ParamSignature)
+	if tsParam == "" || nonce == "" || signature == "" {
+		return ErrMissing
+	}
+	timestamp, err := strconv.ParseInt(tsParam, 10, 64)
+	if err != nil {
+		return ErrMalformed
+	}
+
+	expected := Sign(v.secret, timestamp, nonce)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrForged
+	}
+
+	now := time.Now()
+	issued := time.Unix(timestamp, 0)
+	if issued.Before(now.Add(-v.window)) || issued.After(now.Add(v.window)) {
+		return ErrStale
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for seen, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, seen)
+		}
+	}
+	if _, ok := v.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	// Past this point the timestamp itself is stale, so the nonce can go
+	v.seen[nonce] = issued.Add(v.window)
+	return nil
+}
@@ -3,23 +3,100 @@ package cli
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// Commands selected on the command line
+const (
+	CommandRun      = ""         // Upload, download and verify --input (default)
+	CommandDownload = "download" // Download an existing stream by ID
+	CommandProbe    = "probe"    // Benchmark with generated data, no files
+)
+
 type Config struct {
-	Input   string
-	Server  string
-	Output  string
-	Verbose bool
+	Command          string
+	StreamID         string // download: stream to fetch
+	Size             int64  // download: stream size in bytes; 0 asks the server
+	ProbeSize        int64  // probe: bytes of generated data to upload
+	ProbeDownload    bool   // probe: also download and discard the stream
+	Input            string
+	Server           string
+	Servers          []string // Server split on commas, for fan-out uploads
+	Output           string
+	Verbose          bool
+	Resume           bool
+	DownloadBuffer   int
+	TraceFile        string
+	FanOutConcurrent bool
+	NoDelay          bool
+	CleanupOnFailure bool
+	JitterMs         int
+	Follow           bool
+	UploadChunkSize  int
+	MaxOutputBytes   int64
+	Units            string
+	ChunkChecksum    bool
+	ReadBlockSize    int
+	GetRetries       int
+	GetRetryDelay    time.Duration
+	VerifyDigest     bool
+	CompressionLevel int
+	ContentID        bool
+	VerifyBlocks     bool
+	BlockRetries     int
+	LatencyStats     bool
+	Tail             bool
+	TailIdle         time.Duration
+	OtelEndpoint     string
+	AuthSecret       string
+	SequenceFrames   bool
+	NoColor          bool
+	TranscodeTo      string
+	Variant          string
 }
 
 var (
-	input   string
-	server  string
-	output  string
-	verbose bool
+	command          string
+	streamID         string
+	size             int64
+	probeSize        int64
+	probeDownload    bool
+	input            string
+	server           string
+	output           string
+	verbose          bool
+	resume           bool
+	downloadBuffer   int
+	traceFile        string
+	fanOutConcurrent bool
+	noDelay          bool
+	cleanupOnFailure bool
+	jitterMs         int
+	follow           bool
+	uploadChunkSize  int
+	maxOutputBytes   int64
+	units            string
+	chunkChecksum    bool
+	readBlockSize    int
+	getRetries       int
+	getRetryDelay    time.Duration
+	verifyDigest     bool
+	compressionLevel int
+	contentID        bool
+	verifyBlocks     bool
+	blockRetries     int
+	latencyStats     bool
+	tail             bool
+	tailIdle         time.Duration
+	otelEndpoint     string
+	authSecret       string
+	sequenceFrames   bool
+	noColor          bool
+	transcodeTo      string
+	variant          string
 )
 
 func ParseArgs() (*Config, error) {
@@ -32,25 +109,141 @@ func ParseArgs() (*Config, error) {
 	}
 
 	rootCmd.Flags().StringVar(&input, "input", "", "Input audio file path (required)")
-	rootCmd.Flags().StringVar(&server, "server", "ws://localhost:8080/audio", "WebSocket server URI")
-	rootCmd.Flags().StringVar(&output, "output", "", "Output file path")
-	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
+	rootCmd.PersistentFlags().StringVar(&server, "server", "ws://localhost:8080/audio", "WebSocket server URI (comma-separated to upload to several servers)")
+	rootCmd.PersistentFlags().StringVar(&output, "output", "", "Output file path")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
+	rootCmd.PersistentFlags().BoolVar(&resume, "resume", false, "Resume download from an existing partial output file")
+	rootCmd.PersistentFlags().IntVar(&downloadBuffer, "download-buffer", 1, "Number of downloaded chunks to buffer before writing to disk")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "Write per-chunk timing traces to a CSV file")
+	rootCmd.PersistentFlags().BoolVar(&fanOutConcurrent, "fanout-concurrent", false, "Upload to multiple servers concurrently")
+	rootCmd.PersistentFlags().BoolVar(&noDelay, "nodelay", true, "Set TCP_NODELAY on the connection (false enables Nagle batching)")
+	rootCmd.PersistentFlags().BoolVar(&cleanupOnFailure, "cleanup-on-failure", true, "Remove the partial output file when a download fails")
+	rootCmd.PersistentFlags().IntVar(&jitterMs, "jitter-ms", 0, "Jitter buffer size in milliseconds of audio (WAV only, 0 disables)")
+	rootCmd.PersistentFlags().BoolVar(&follow, "follow", false, "After uploading, stay connected and read download/status/quit commands from stdin")
+	rootCmd.PersistentFlags().IntVar(&uploadChunkSize, "upload-chunk-size", 8192, "Bytes sent per binary frame during upload")
+	rootCmd.PersistentFlags().Int64Var(&maxOutputBytes, "max-output-bytes", 0, "Abort a download that receives more than this many bytes (0 = twice the expected size)")
+	rootCmd.PersistentFlags().StringVar(&units, "units", "mbps", "Throughput units in reports: mbps, mbs (MB/s), gbps or auto")
+	rootCmd.PersistentFlags().BoolVar(&chunkChecksum, "chunk-checksum", false, "Prefix each uploaded chunk with its CRC32 for server-side verification")
+	rootCmd.PersistentFlags().IntVar(&readBlockSize, "read-block-size", 1048576, "Bytes read from the input file at a time, sent as --upload-chunk-size frames")
+	rootCmd.PersistentFlags().IntVar(&getRetries, "get-retries", 3, "Times to retry a GET the server cannot serve yet (NOT_READY, BUSY or empty) before failing")
+	rootCmd.PersistentFlags().DurationVar(&getRetryDelay, "get-retry-delay", 200*time.Millisecond, "Delay before the first GET retry, doubled on each further retry")
+	rootCmd.PersistentFlags().BoolVar(&verifyDigest, "verify-digest", false, "After upload, compare the digest the server computed on finalize with the input file")
+	rootCmd.PersistentFlags().IntVar(&compressionLevel, "compression-level", 0, "Offer per-message deflate and ask the server to compress downloads at this level, 1 (fastest) to 9 (smallest); 0 disables")
+	rootCmd.PersistentFlags().BoolVar(&contentID, "content-id", false, "Derive the stream ID from the SHA-256 of the input (reads the whole input before uploading)")
+	rootCmd.PersistentFlags().BoolVar(&verifyBlocks, "verify-blocks", false, "Verify each downloaded block against the server's block hashes as it arrives, re-fetching corrupted blocks")
+	rootCmd.PersistentFlags().IntVar(&blockRetries, "block-retries", 2, "Times to re-fetch a downloaded block that does not match its server hash before failing")
+	rootCmd.PersistentFlags().BoolVar(&latencyStats, "latency-stats", false, "Report min/median/p95/max GET round-trip time of the download in the performance report")
+	rootCmd.PersistentFlags().BoolVar(&tail, "tail", false, "Upload --input as it grows (tail -f) as one live stream, until it stops growing for --tail-idle or on Ctrl-C")
+	rootCmd.PersistentFlags().DurationVar(&tailIdle, "tail-idle", 5*time.Second, "With --tail, end the stream once the input has not grown for this long (0 waits for Ctrl-C)")
+	rootCmd.PersistentFlags().StringVar(&otelEndpoint, "otel-endpoint", "", "OTLP/HTTP collector URL (e.g. http://localhost:4318) to export transfer spans to; empty disables tracing")
+	rootCmd.PersistentFlags().StringVar(&authSecret, "auth-secret", "", "Shared secret for the server's HMAC connection handshake (server --auth-secret)")
+	rootCmd.PersistentFlags().BoolVar(&sequenceFrames, "sequence-frames", false, "Number every uploaded frame so the server detects out-of-order arrival (server must support it)")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored log levels (also off when output is redirected or NO_COLOR is set)")
+	rootCmd.PersistentFlags().StringVar(&transcodeTo, "transcode-to", "", "Ask the server to transcode the uploaded stream to this format (wav, mp3, ogg, flac) on finalize")
+	rootCmd.PersistentFlags().StringVar(&variant, "variant", "", "download: fetch the stream's transcoded variant in this format instead of the original")
 	rootCmd.MarkFlagRequired("input")
 
+	downloadCmd := &cobra.Command{
+		Use:   "download",
+		Short: "Download an existing stream by ID without uploading",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			command = CommandDownload
+			return nil
+		},
+	}
+	downloadCmd.Flags().StringVar(&streamID, "stream-id", "", "ID of the stream to download (required)")
+	downloadCmd.Flags().Int64Var(&size, "size", 0, "Stream size in bytes (0 queries the server with STATUS)")
+	downloadCmd.MarkFlagRequired("stream-id")
+	rootCmd.AddCommand(downloadCmd)
+
+	probeCmd := &cobra.Command{
+		Use:   "probe",
+		Short: "Measure upload and download throughput with generated in-memory data",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			command = CommandProbe
+			return nil
+		},
+	}
+	probeCmd.Flags().Int64Var(&probeSize, "probe-size", 100*1024*1024, "Bytes of generated data to upload")
+	probeCmd.Flags().BoolVar(&probeDownload, "probe-download", true, "Also download the probe stream, discarding the data and checking only its length")
+	rootCmd.AddCommand(probeCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		return nil, err
 	}
 
+	switch units = strings.ToLower(units); units {
+	case "mbps", "mbs", "gbps", "auto":
+	default:
+		return nil, fmt.Errorf("invalid --units %q (expected mbps, mbs, gbps or auto)", units)
+	}
+
+	if compressionLevel < 0 || compressionLevel > 9 {
+		return nil, fmt.Errorf("invalid --compression-level %d (expected 1-9, or 0 to disable)", compressionLevel)
+	}
+	if command == CommandProbe && probeSize <= 0 {
+		return nil, fmt.Errorf("invalid --probe-size %d", probeSize)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid --size %d", size)
+	}
+
 	// Generate default output path if not provided
 	if output == "" {
-		output = generateDefaultOutput(input)
+		if command == CommandDownload {
+			output = generateDefaultOutput(streamID)
+		} else {
+			output = generateDefaultOutput(input)
+		}
+	}
+
+	var servers []string
+	for _, uri := range strings.Split(server, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			servers = append(servers, uri)
+		}
 	}
 
 	return &Config{
-		Input:   input,
-		Server:  server,
-		Output:  output,
-		Verbose: verbose,
+		Command:          command,
+		StreamID:         streamID,
+		Size:             size,
+		ProbeSize:        probeSize,
+		ProbeDownload:    probeDownload,
+		Input:            input,
+		Server:           server,
+		Servers:          servers,
+		Output:           output,
+		Verbose:          verbose,
+		Resume:           resume,
+		DownloadBuffer:   downloadBuffer,
+		TraceFile:        traceFile,
+		FanOutConcurrent: fanOutConcurrent,
+		NoDelay:          noDelay,
+		CleanupOnFailure: cleanupOnFailure,
+		JitterMs:         jitterMs,
+		Follow:           follow,
+		UploadChunkSize:  uploadChunkSize,
+		MaxOutputBytes:   maxOutputBytes,
+		Units:            units,
+		ChunkChecksum:    chunkChecksum,
+		ReadBlockSize:    readBlockSize,
+		GetRetries:       getRetries,
+		GetRetryDelay:    getRetryDelay,
+		VerifyDigest:     verifyDigest,
+		CompressionLevel: compressionLevel,
+		ContentID:        contentID,
+		VerifyBlocks:     verifyBlocks,
+		BlockRetries:     blockRetries,
+		LatencyStats:     latencyStats,
+		Tail:             tail,
+		TailIdle:         tailIdle,
+		OtelEndpoint:     otelEndpoint,
+		AuthSecret:       authSecret,
+		SequenceFrames:   sequenceFrames,
+		NoColor:          noColor,
+		TranscodeTo:      transcodeTo,
+		Variant:          variant,
 	}, nil
 }
 
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -9,8 +11,82 @@ import (
 	"github.com/feuyeux/hello-mmap/hello-go/src/client/core"
 	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
 )
 
+// Process exit codes
+const (
+	ExitSuccess       = 0
+	ExitFailure       = 1
+	ExitProtocolError = 2 // The server answered with an ERROR message
+)
+
+// failRun logs a structured failure summary, including any performance
+// numbers gathered so far, and exits with the matching code
+func (r reporter) failRun(phase string, err error, perf *util.PerformanceMonitor) {
+	logger.Phase("Failure Summary")
+	logger.Error(fmt.Sprintf("Phase: %s", phase))
+	logger.Error(fmt.Sprintf("Reason: %v", err))
+
+	exitCode := ExitFailure
+	var serverErr *core.ServerError
+	if errors.As(err, &serverErr) {
+		logger.Error(fmt.Sprintf("Server error: %s", serverErr.Message))
+		exitCode = ExitProtocolError
+	}
+
+	// Phases cut short report what they moved before failing
+	if perf != nil {
+		r.logSnapshot(perf)
+	}
+
+	telemetry.Shutdown()
+	exit(exitCode)
+}
+
+// exitHooks release resources such as the chunk trace when the client exits
+// through exit, since os.Exit skips deferred calls
+var exitHooks []func()
+
+// onExit registers fn to run before the process exits through exit
+func onExit(fn func()) {
+	exitHooks = append(exitHooks, fn)
+}
+
+// exit runs the registered exit hooks, newest first, then exits with code
+func exit(code int) {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	exitHooks = nil
+	os.Exit(code)
+}
+
+// newDownloadOptions builds the download options selected on the command line,
+// enabling protocol features the server advertises
+func newDownloadOptions(config *cli.Config, tracer *util.ChunkTracer, capabilities *core.Capabilities) core.DownloadOptions {
+	var latency *util.LatencyRecorder
+	if config.LatencyStats {
+		latency = util.NewLatencyRecorder()
+	}
+	return core.DownloadOptions{
+		Resume:           config.Resume,
+		BufferChunks:     config.DownloadBuffer,
+		Tracer:           tracer,
+		Latency:          latency,
+		CleanupOnFailure: config.CleanupOnFailure,
+		JitterMs:         config.JitterMs,
+		MaxOutputBytes:   config.MaxOutputBytes,
+		Envelope:         capabilities != nil && capabilities.GetEnvelope,
+		VerifyResume:     capabilities.Supports("HASHES") && config.Variant == "",
+		VerifyBlocks:     config.VerifyBlocks && capabilities.Supports("HASHES") && config.Variant == "",
+		Variant:          config.Variant,
+		BlockRetries:     config.BlockRetries,
+		GetRetries:       config.GetRetries,
+		GetRetryDelay:    config.GetRetryDelay,
+	}
+}
+
 // Run executes the audio client application
 func Run() {
 	// Parse CLI arguments
@@ -22,6 +98,31 @@ func Run() {
 
 	// Initialize logger
 	logger.Init(config.Verbose)
+	if config.NoColor {
+		logger.DisableColor()
+	}
+	rep := reporter{units: config.Units}
+
+	// Export transfer spans when a collector is configured
+	if err := telemetry.Init(config.OtelEndpoint, "audio-stream-client"); err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize tracing: %v", err))
+		os.Exit(ExitFailure)
+	}
+	defer telemetry.Shutdown()
+	core.AuthSecret = config.AuthSecret
+
+	switch config.Command {
+	case cli.CommandDownload:
+		runDownload(config)
+		return
+	case cli.CommandProbe:
+		runProbe(config)
+		return
+	}
+	if config.Tail {
+		runTail(config)
+		return
+	}
 
 	// Log startup information
 	logger.Info("Audio Stream Cache Client - Go Implementation")
@@ -35,43 +136,132 @@ func Run() {
 		logger.Error(fmt.Sprintf("Failed to get file size: %v", err))
 		os.Exit(1)
 	}
-	logger.Info(fmt.Sprintf("Input file size: %d bytes", fileSize))
+	logger.Info(fmt.Sprintf("Input file size: %d bytes (%s)", fileSize, humanizeBytes(fileSize)))
 
-	// Initialize performance monitor
+	// Derive the stream ID from the content instead of at random; the
+	// checksum also lets servers skip uploads of content they already hold
+	var contentSHA256, contentStreamID string
+	if config.ContentID {
+		contentSHA256, err = util.ComputeSHA256(config.Input)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to compute content ID: %v", err))
+			os.Exit(ExitFailure)
+		}
+		contentStreamID = util.ContentStreamIDFor(contentSHA256)
+		logger.Info(fmt.Sprintf("Content stream ID: %s", contentStreamID))
+	}
+
+	// Initialize performance monitor; SIGUSR1 logs its numbers so far
 	perf := util.NewPerformanceMonitor(fileSize)
+	stopSnapshots := dumpSnapshotsOnSignal(rep, perf)
+	defer stopSnapshots()
+
+	// Open the optional per-chunk trace
+	var tracer *util.ChunkTracer
+	if config.TraceFile != "" {
+		tracer, err = util.NewChunkTracer(config.TraceFile)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to open trace file: %v", err))
+			os.Exit(ExitFailure)
+		}
+		defer tracer.Close()
+		onExit(func() { tracer.Close() })
+		logger.Info(fmt.Sprintf("Writing chunk trace to %s", config.TraceFile))
+	}
+
+	// Fan out to several servers when more than one is configured
+	if len(config.Servers) > 1 {
+		runFanOut(config, fileSize, tracer, contentSHA256, contentStreamID)
+		return
+	}
 
 	// Connect to WebSocket server
 	logger.Phase("Connecting to Server")
-	ws, err := core.Connect(config.Server)
+	ws, err := core.Connect(config.Server, config.CompressionLevel > 0)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to connect to server: %v", err))
-		os.Exit(1)
+		rep.failRun("connect", err, perf)
 	}
 	defer ws.Close()
+	if err := ws.SetNoDelay(config.NoDelay); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to set TCP_NODELAY=%v: %v", config.NoDelay, err))
+	}
 	logger.Info("Successfully connected to server")
 
+	// Discover server features; older servers simply don't answer with them
+	capabilities, err := core.QueryCapabilities(ws)
+	if err != nil {
+		rep.failRun("connect", err, perf)
+	}
+	if capabilities != nil {
+		logger.Debug(fmt.Sprintf("Server capabilities: protocol v%d, messages %v", capabilities.ProtocolVersion, capabilities.MessageTypes))
+	}
+	if config.ChunkChecksum && !capabilities.SupportsChecksum(core.ChunkChecksumCRC32) {
+		rep.failRun("connect", fmt.Errorf("server does not support %s chunk checksums", core.ChunkChecksumCRC32), perf)
+	}
+	if config.SequenceFrames && (capabilities == nil || !capabilities.SequencedFrames) {
+		rep.failRun("connect", fmt.Errorf("server does not support sequenced frames"), perf)
+	}
+	if config.TranscodeTo != "" && (capabilities == nil || capabilities.Transcoder == "") {
+		rep.failRun("connect", fmt.Errorf("server does not support transcoding"), perf)
+	}
+	if config.CompressionLevel > 0 && (capabilities == nil || !capabilities.Compression) {
+		logger.Warn("Server does not support compression; downloads will be uncompressed")
+	}
+
 	// Upload file
 	logger.Phase("Starting Upload")
 	perf.StartUpload()
-	streamID, err := core.Upload(ws, config.Input, fileSize)
+	uploadCtx, uploadSpan := telemetry.Start(context.Background(), "upload")
+	streamID, err := core.Upload(ws, config.Input, fileSize, core.UploadOptions{
+		Tracer:           tracer,
+		Perf:             perf,
+		ChunkSize:        config.UploadChunkSize,
+		ChunkChecksum:    config.ChunkChecksum,
+		SequenceFrames:   config.SequenceFrames,
+		TranscodeTo:      config.TranscodeTo,
+		ReadBlockSize:    config.ReadBlockSize,
+		VerifyDigest:     config.VerifyDigest,
+		CompressionLevel: config.CompressionLevel,
+		SHA256:           contentSHA256,
+		StreamID:         contentStreamID,
+		TraceContext:     uploadCtx,
+	})
+	uploadSpan.SetStreamID(streamID)
+	uploadSpan.SetBytes(fileSize)
+	uploadSpan.End(err)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Upload failed: %v", err))
-		os.Exit(1)
+		rep.failRun("upload", err, perf)
 	}
 	perf.EndUpload()
 	logger.Info(fmt.Sprintf("Upload completed successfully with stream ID: %s", streamID))
 
+	downloadOptions := newDownloadOptions(config, tracer, capabilities)
+
+	// Follow mode serves repeated downloads over this connection instead
+	if config.Follow {
+		runFollow(ws, config, streamID, fileSize, downloadOptions)
+		return
+	}
+
 	// Sleep 2 seconds after upload
 	logger.Info("Upload successful, sleeping for 2 seconds...")
 	time.Sleep(2 * time.Second)
 
 	// Download file
 	logger.Phase("Starting Download")
+	downloadOptions.Perf = perf
 	perf.StartDownload()
-	err = core.Download(ws, streamID, config.Output, fileSize)
+	var downloadSpan *telemetry.Span
+	downloadOptions.TraceContext, downloadSpan = telemetry.Start(context.Background(), "download")
+	downloadSpan.SetStreamID(streamID)
+	err = core.Download(ws, streamID, config.Output, fileSize, downloadOptions)
+	downloadSpan.SetBytes(fileSize)
+	downloadSpan.End(err)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Download failed: %v", err))
-		os.Exit(1)
+		rep.failRun("download", err, perf)
 	}
 	perf.EndDownload()
 	logger.Info("Download completed successfully")
@@ -85,7 +275,7 @@ func Run() {
 	result, err := util.Verify(config.Input, config.Output)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Verification error: %v", err))
-		os.Exit(1)
+		exit(ExitFailure)
 	}
 
 	if result.Passed {
@@ -99,23 +289,12 @@ func Run() {
 		if result.OriginalChecksum != result.DownloadedChecksum {
 			logger.Error("  Reason: Checksum mismatch")
 		}
-		os.Exit(1)
+		exit(ExitFailure)
 	}
 
 	// Generate performance report
-	logger.Phase("Performance Report")
-	report := perf.GetReport()
-	logger.Info(fmt.Sprintf("Upload Duration: %d ms", report.UploadDurationMs))
-	logger.Info(fmt.Sprintf("Upload Throughput: %.2f Mbps", report.UploadThroughputMbps))
-	logger.Info(fmt.Sprintf("Download Duration: %d ms", report.DownloadDurationMs))
-	logger.Info(fmt.Sprintf("Download Throughput: %.2f Mbps", report.DownloadThroughputMbps))
-	logger.Info(fmt.Sprintf("Total Duration: %d ms", report.TotalDurationMs))
-	logger.Info(fmt.Sprintf("Average Throughput: %.2f Mbps", report.AverageThroughputMbps))
-
-	// Check performance targets
-	if report.UploadThroughputMbps < 100.0 || report.DownloadThroughputMbps < 200.0 {
-		logger.Warn("⚠ Performance targets not met (Upload >100 Mbps, Download >200 Mbps)")
-	}
+	rep.logPerformanceReport(perf)
+	logLatencyStats(downloadOptions.Latency)
 
 	// Disconnect
 	logger.Info("Disconnected from server")
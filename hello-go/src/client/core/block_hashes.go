@@ -0,0 +1,140 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// BlockHashes is the server's HASHES response: SHA-256 hex digest per block
+type BlockHashes struct {
+	BlockSize int
+	Size      int64
+	Hashes    []string
+}
+
+// QueryBlockHashes fetches the block hashes of a stream.
+// A blockSize of 0 uses the server default.
+func QueryBlockHashes(ws *WebSocketClient, streamID string, blockSize int) (*BlockHashes, error) {
+	msg := ControlMessage{Type: "HASHES", StreamID: streamID}
+	if blockSize > 0 {
+		msg.BlockSize = &blockSize
+	}
+	if err := ws.SendControlMessage(msg); err != nil {
+		return nil, fmt.Errorf("failed to send HASHES message: %w", err)
+	}
+
+	response, err := ws.ReceiveControlMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive HASHES: %w", err)
+	}
+	if response.Type == "ERROR" {
+		return nil, fmt.Errorf("server rejected HASHES: %w", newServerError(response))
+	}
+	if response.Type != "HASHES" || response.BlockSize == nil || *response.BlockSize <= 0 {
+		return nil, fmt.Errorf("unexpected response to HASHES: %s", response.Type)
+	}
+
+	hashes := &BlockHashes{BlockSize: *response.BlockSize, Hashes: response.Hashes}
+	if response.Size != nil {
+		hashes.Size = *response.Size
+	}
+	return hashes, nil
+}
+
+// verifyPartialOutput checks an existing partial output block by block and
+// returns the length of its verified prefix. Only whole blocks can be
+// checked, so a trailing partial block is never counted as verified.
+func verifyPartialOutput(path string, length int64, hashes *BlockHashes) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	block := make([]byte, hashes.BlockSize)
+	var verified int64
+	for i, expected := range hashes.Hashes {
+		if verified+int64(hashes.BlockSize) > length {
+			break
+		}
+		if _, err := io.ReadFull(file, block); err != nil {
+			return verified, err
+		}
+		sum := sha256.Sum256(block)
+		if hex.EncodeToString(sum[:]) != expected {
+			logger.Warn(fmt.Sprintf("Partial output %s block %d (offset %d) does not match the server", path, i, verified))
+			break
+		}
+		verified += int64(hashes.BlockSize)
+	}
+	return verified, nil
+}
+
+// ErrBlockMismatch fails a download whose block still differs from the
+// server's hash after all re-fetches
+var ErrBlockMismatch = errors.New("downloaded block does not match server hash")
+
+// blockVerifier checks downloaded data against the server's block hashes as
+// it arrives, holding back each block until it matches and re-fetching a
+// block that arrived corrupted
+type blockVerifier struct {
+	hashes   *BlockHashes
+	start    int64  // Stream offset of the first buffered byte, block aligned
+	buffered []byte // Received bytes of the block not yet verified
+	retries  int    // Re-fetches allowed per block
+	fetch    func(offset int64, length int) ([]byte, error)
+}
+
+// add buffers data received at the verifier's position and returns the bytes
+// of every block it completes, verified. With final the short last block is
+// verified too.
+func (v *blockVerifier) add(data []byte, final bool) ([]byte, error) {
+	v.buffered = append(v.buffered, data...)
+
+	var verified []byte
+	for len(v.buffered) >= v.hashes.BlockSize || (final && len(v.buffered) > 0) {
+		n := min(v.hashes.BlockSize, len(v.buffered))
+		block, err := v.verify(v.buffered[:n])
+		if err != nil {
+			return nil, err
+		}
+		verified = append(verified, block...)
+		v.buffered = append(v.buffered[:0], v.buffered[n:]...)
+		v.start += int64(n)
+	}
+	return verified, nil
+}
+
+// verify compares the block at v.start with its server hash, re-fetching it
+// up to v.retries times, and returns the matching bytes
+func (v *blockVerifier) verify(block []byte) ([]byte, error) {
+	index := int(v.start / int64(v.hashes.BlockSize))
+	if index >= len(v.hashes.Hashes) {
+		return nil, fmt.Errorf("no server hash for block %d at offset %d", index, v.start)
+	}
+
+	for attempt := 0; ; attempt++ {
+		sum := sha256.Sum256(block)
+		if hex.EncodeToString(sum[:]) == v.hashes.Hashes[index] {
+			return block, nil
+		}
+		if attempt >= v.retries {
+			return nil, fmt.Errorf("%w: block %d at offset %d after %d re-fetches",
+				ErrBlockMismatch, index, v.start, attempt)
+		}
+
+		logger.Warn(fmt.Sprintf("Block %d at offset %d does not match the server, re-fetching (%d/%d)",
+			index, v.start, attempt+1, v.retries))
+		refetched, err := v.fetch(v.start, len(block))
+		if err != nil {
+			return nil, err
+		}
+		block = refetched
+	}
+}
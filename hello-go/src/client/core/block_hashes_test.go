@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownloadRefetchesCorruptedBlock(t *testing.T) {
+	const blockSize = 4096
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1250) // Four whole blocks and a short one
+	corrupted := false
+	var gets []int64
+	var mu sync.Mutex
+	uri := newFakeServer(t, getServer(data, blockSize, func(offset int64, chunk []byte) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		gets = append(gets, offset)
+		// Flip a byte of the third block the first time it is sent
+		if at := 2*blockSize + 100 - offset; !corrupted && at >= 0 && at < int64(len(chunk)) {
+			corrupted = true
+			chunk[at] ^= 0xff
+		}
+		return chunk
+	}))
+
+	output := filepath.Join(t.TempDir(), "out.bin")
+	opts := DownloadOptions{VerifyBlocks: true, BlockRetries: 2}
+	if err := Download(dialFake(t, uri), "blocks", output, int64(len(data)), opts); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded data differs from the stream")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int64{0, 2 * blockSize}; len(gets) != len(want) || gets[0] != want[0] || gets[1] != want[1] {
+		t.Errorf("GET offsets = %v, want %v (the stream, then the corrupted block)", gets, want)
+	}
+}
+
+func TestDownloadFailsOnPersistentlyCorruptedBlock(t *testing.T) {
+	const blockSize = 4096
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1250)
+	gets := 0
+	var mu sync.Mutex
+	uri := newFakeServer(t, getServer(data, blockSize, func(offset int64, chunk []byte) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		gets++
+		if at := blockSize - offset; at >= 0 && at < int64(len(chunk)) {
+			chunk[at] ^= 0xff
+		}
+		return chunk
+	}))
+
+	output := filepath.Join(t.TempDir(), "out.bin")
+	opts := DownloadOptions{VerifyBlocks: true, BlockRetries: 2}
+	err := Download(dialFake(t, uri), "blocks", output, int64(len(data)), opts)
+	if !errors.Is(err, ErrBlockMismatch) {
+		t.Fatalf("Download error = %v, want ErrBlockMismatch", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if gets != 1+opts.BlockRetries {
+		t.Errorf("%d GETs, want the stream and %d re-fetches", gets, opts.BlockRetries)
+	}
+	got, _ := os.ReadFile(output)
+	if len(got) > blockSize {
+		t.Errorf("%d bytes written, want only the verified first block", len(got))
+	}
+}
+
+func TestResumeTruncatesAtCorruptedMiddleBlock(t *testing.T) {
+	const blockSize = 4096
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1250)
+	var gets []int64
+	var mu sync.Mutex
+	uri := newFakeServer(t, getServer(data, blockSize, func(offset int64, chunk []byte) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		gets = append(gets, offset)
+		return chunk
+	}))
+
+	// A partial output of three and a half blocks whose second block is damaged
+	output := filepath.Join(t.TempDir(), "out.bin")
+	partial := append([]byte(nil), data[:3*blockSize+blockSize/2]...)
+	partial[blockSize+10] ^= 0xff
+	if err := os.WriteFile(output, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := QueryBlockHashes(dialFake(t, uri), "blocks", blockSize)
+	if err != nil {
+		t.Fatalf("QueryBlockHashes: %v", err)
+	}
+	verified, err := verifyPartialOutput(output, int64(len(partial)), hashes)
+	if err != nil {
+		t.Fatalf("verifyPartialOutput: %v", err)
+	}
+	if verified != blockSize {
+		t.Errorf("verified prefix = %d, want %d (the block before the damaged one)", verified, blockSize)
+	}
+
+	opts := DownloadOptions{Resume: true, VerifyResume: true}
+	if err := Download(dialFake(t, uri), "blocks", output, int64(len(data)), opts); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("resumed output differs from the stream")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(gets) != 1 || gets[0] != blockSize {
+		t.Errorf("GET offsets = %v, want [%d]: resume after the last good block", gets, blockSize)
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// Capabilities mirrors the server's CAPABILITIES response
+type Capabilities struct {
+	ProtocolVersion     int      `json:"protocolVersion"`
+	MinProtocolVersion  int      `json:"minProtocolVersion"`
+	MessageTypes        []string `json:"messageTypes"`
+	ChecksumAlgorithms  []string `json:"checksumAlgorithms"`
+	Compression         bool     `json:"compression"`
+	LiveReads           bool     `json:"liveReads"`
+	StrictFrames        bool     `json:"strictFrames"`
+	FairScheduling      bool     `json:"fairScheduling"`
+	DeclaredSizeLimit   bool     `json:"declaredSizeLimit"`
+	MaxMessageSize      int64    `json:"maxMessageSize"`
+	MaxUploadDurationMs int64    `json:"maxUploadDurationMs"`
+	MinChunkSize        int      `json:"minChunkSize"`
+	GetEnvelope         bool     `json:"getEnvelope"`
+	Dedup               bool     `json:"dedup"`
+	MaxInflightGets     int      `json:"maxInflightGets"`
+	SequencedFrames     bool     `json:"sequencedFrames"`
+	ReorderWindow       int      `json:"reorderWindow"`
+	Transcoder          string   `json:"transcoder"` // Empty when START transcodeTo is not supported
+}
+
+// Supports reports whether the server accepts the given message type
+func (c *Capabilities) Supports(messageType string) bool {
+	if c == nil {
+		return false
+	}
+	for _, t := range c.MessageTypes {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsChecksum reports whether the server verifies per-chunk checksums
+// with the given algorithm
+func (c *Capabilities) SupportsChecksum(algorithm string) bool {
+	if c == nil {
+		return false
+	}
+	for _, a := range c.ChecksumAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryCapabilities asks the server what it supports.
+// Servers without CAPABILITIES support answer with an ERROR, reported as a nil result.
+func QueryCapabilities(ws *WebSocketClient) (*Capabilities, error) {
+	if err := ws.SendControlMessage(ControlMessage{Type: "CAPABILITIES"}); err != nil {
+		return nil, fmt.Errorf("failed to send CAPABILITIES message: %w", err)
+	}
+
+	response, err := ws.ReceiveControlMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive CAPABILITIES: %w", err)
+	}
+	if response.Type == "ERROR" {
+		logger.Debug(fmt.Sprintf("Server does not support CAPABILITIES: %s", response.Message))
+		return nil, nil
+	}
+	if response.Type != "CAPABILITIES" || response.Capabilities == nil {
+		return nil, fmt.Errorf("unexpected response to CAPABILITIES: %s", response.Type)
+	}
+
+	if err := CheckServerVersion(response.Capabilities.ProtocolVersion); err != nil {
+		return nil, err
+	}
+	return response.Capabilities, nil
+}
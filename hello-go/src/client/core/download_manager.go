@@ -1,67 +1,288 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
 )
 
-func Download(ws *WebSocketClient, streamID string, outputPath string, fileSize int64) error {
+// DefaultMaxOutputFactor bounds a download to this multiple of the expected
+// size when DownloadOptions.MaxOutputBytes is not set
+const DefaultMaxOutputFactor = 2
+
+// DefaultGetRetryDelay is used when DownloadOptions.GetRetryDelay is not set
+const DefaultGetRetryDelay = 200 * time.Millisecond
+
+// errEmptyResponse is a GET answered with no data, retried like NOT_READY
+var errEmptyResponse = errors.New("no data received")
+
+// ErrOutputLimitExceeded aborts a download that received more data than allowed
+var ErrOutputLimitExceeded = errors.New("download exceeded maximum output size")
+
+// DownloadOptions controls optional download behavior
+type DownloadOptions struct {
+	Resume       bool // Continue from an existing partial output file
+	BufferChunks int  // Number of received chunks to buffer before writing (<= 1 writes every chunk)
+
+	Tracer  *util.ChunkTracer        // Optional per-chunk timing trace, including GET round-trip time
+	Latency *util.LatencyRecorder    // Optional GET round-trip times for a summary
+	Perf    *util.PerformanceMonitor // Optional; credited with each received chunk for live snapshots
+
+	CleanupOnFailure bool // Remove the partial output if the download fails (ignored with Resume)
+
+	// Hold this much audio before writing and then write at the audio's byte
+	// rate (WAV only); adds JitterMs of latency. Zero disables.
+	JitterMs int
+
+	// Abort once more than this many bytes are received, protecting the disk
+	// from a server that overshoots. Zero uses DefaultMaxOutputFactor × size.
+	MaxOutputBytes int64
+
+	// Ask for a DATA envelope ahead of each chunk stating its exact length and
+	// whether it is the last one. Only set when the server advertises GetEnvelope.
+	Envelope bool
+
+	// With Resume, check the existing partial output against the server's
+	// block hashes and resume after the last matching block. Only set when
+	// the server supports HASHES.
+	VerifyResume bool
+
+	// Check every block against the server's block hashes as it arrives and
+	// re-fetch a mismatching block up to BlockRetries times before failing.
+	// Only set when the server supports HASHES.
+	VerifyBlocks bool
+	BlockRetries int
+
+	// Write the stream here instead of to the output path, e.g. to discard it
+	// while benchmarking. Resume, jitter and cleanup do not apply.
+	Sink io.Writer
+
+	// Retry a GET the server cannot serve yet (NOT_READY, BUSY or an empty
+	// response) up to GetRetries times, waiting GetRetryDelay before the
+	// first retry and doubling it each time. OUT_OF_RANGE is never retried.
+	GetRetries    int
+	GetRetryDelay time.Duration
+
+	// Parent of a span per GET when OpenTelemetry tracing is enabled
+	TraceContext context.Context
+
+	// Download the stream's transcoded variant in this format instead of the
+	// stream as uploaded. Block hashes describe the original, so
+	// VerifyResume and VerifyBlocks must be off.
+	Variant string
+}
+
+// Download fetches a stream into outputPath, or into opts.Sink when set.
+// A fileSize of 0 or less asks the server for the size of the (finalized)
+// stream first.
+func Download(ws *WebSocketClient, streamID string, outputPath string, fileSize int64, opts DownloadOptions) (err error) {
+	if fileSize <= 0 {
+		if fileSize, err = queryDownloadSize(ws, streamID, opts.Variant); err != nil {
+			return err
+		}
+	}
+	if opts.Sink != nil {
+		opts.Resume = false
+		opts.JitterMs = 0
+	}
+
+	// Runs after the output file is closed; a runaway download is always removed
+	defer func() {
+		if opts.Sink != nil {
+			return
+		}
+		if errors.Is(err, ErrOutputLimitExceeded) || (err != nil && opts.CleanupOnFailure && !opts.Resume) {
+			removePartialOutput(outputPath)
+		}
+	}()
+
+	maxOutputBytes := opts.MaxOutputBytes
+	if maxOutputBytes <= 0 {
+		maxOutputBytes = DefaultMaxOutputFactor * fileSize
+	}
+
 	var offset int64 = 0
 	var bytesReceived int64 = 0
+	var bytesWritten int64 = 0
 	lastProgress := 0
-	isFirstChunk := true
+
+	// Received chunks are accumulated here and written in one call
+	var pending []byte
+	pendingChunks := 0
+
+	var hashes *BlockHashes
+	if opts.VerifyBlocks {
+		if hashes, err = QueryBlockHashes(ws, streamID, 0); err != nil {
+			return err
+		}
+		if hashes.Size != fileSize {
+			logger.Warn(fmt.Sprintf("Block verification disabled: server hashes cover %d bytes, downloading %d", hashes.Size, fileSize))
+			hashes = nil
+		}
+	}
+
+	if opts.Resume {
+		offset = resumeOffset(outputPath, fileSize)
+		// Block verification needs a block-aligned start, which the verified resume gives
+		if offset > 0 && (opts.VerifyResume || hashes != nil) {
+			if offset, err = verifiedResumeOffset(ws, streamID, outputPath, offset, hashes); err != nil {
+				return err
+			}
+		}
+		if offset > 0 {
+			logger.Info(fmt.Sprintf("Resuming download from offset %d (%d bytes already present)", offset, offset))
+			bytesReceived = offset
+			bytesWritten = offset
+			lastProgress = int(bytesReceived*100/fileSize) / 25 * 25
+		}
+	}
+
+	// Create the output directory and open the file once, up front;
+	// every chunk is written through the same handle
+	var file *os.File
+	var out io.Writer = opts.Sink
+	if opts.Sink == nil {
+		if err := EnsureParentDir(outputPath); err != nil {
+			return fmt.Errorf("cannot create output directory for %s: %w", outputPath, err)
+		}
+		writeMode := Truncate
+		if offset > 0 {
+			writeMode = WriteAt(offset)
+		}
+		logger.Debug(fmt.Sprintf("Opening output %s in %s mode", outputPath, writeMode))
+		file, err = OpenOutputFile(outputPath, writeMode)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	// Output goes through a jitter buffer once the audio byte rate is known
+	var jitter *JitterBuffer
+	jitterPending := opts.JitterMs > 0
+
+	// Received data is held back per block until it matches the server's hash
+	var verifier *blockVerifier
+	if hashes != nil {
+		verifier = &blockVerifier{
+			hashes:  hashes,
+			start:   offset,
+			retries: opts.BlockRetries,
+			fetch: func(at int64, length int) ([]byte, error) {
+				var block []byte
+				for len(block) < length {
+					data, _, err := fetchChunkWithRetry(ws, streamID, at+int64(len(block)), min(ChunkSize, length-len(block)), opts)
+					if err != nil {
+						return nil, err
+					}
+					bytesReceived += int64(len(data))
+					if bytesReceived > maxOutputBytes {
+						return nil, fmt.Errorf("%w: received %d bytes, limit %d (expected %d)",
+							ErrOutputLimitExceeded, bytesReceived, maxOutputBytes, fileSize)
+					}
+					block = append(block, data...)
+				}
+				return block[:length], nil
+			},
+		}
+		logger.Info(fmt.Sprintf("Verifying downloaded blocks of %d bytes against server hashes", hashes.BlockSize))
+	}
 
 	for offset < fileSize {
 		// Calculate how much data we still need
 		remainingBytes := fileSize - offset
 		chunkSize := int(Min(int64(ChunkSize), remainingBytes))
 
-		// Send GET message
-		offsetPtr := offset
-		lengthPtr := chunkSize
 		logger.Debug(fmt.Sprintf("Requesting chunk at offset %d, length %d (remaining: %d)", offset, chunkSize, remainingBytes))
-		err := ws.SendControlMessage(ControlMessage{
-			Type:     "GET",
-			StreamID: streamID,
-			Offset:   &offsetPtr,
-			Length:   &lengthPtr,
-		})
+		requestedAt := time.Now()
+		data, envelope, err := fetchChunkWithRetry(ws, streamID, offset, chunkSize, opts)
 		if err != nil {
-			return fmt.Errorf("failed to send GET message: %w", err)
+			return err
 		}
 
-		// Receive binary data - one GET request = one binary response
-		// The server may send less data than requested
-		logger.Debug(fmt.Sprintf("Waiting for binary data at offset %d", offset))
-		data, err := ws.ReceiveBinary()
-		if err != nil {
-			return fmt.Errorf("failed to receive data: %w", err)
+		logger.Debug(fmt.Sprintf("Received %d bytes of data", len(data)))
+		rtt := time.Since(requestedAt)
+		opts.Tracer.Record("download", offset, len(data), rtt)
+		opts.Latency.Record(rtt)
+		opts.Perf.AddDownloadBytes(len(data))
+
+		offset += int64(len(data))
+		bytesReceived += int64(len(data))
+		if bytesReceived > maxOutputBytes {
+			return fmt.Errorf("%w: received %d bytes, limit %d (expected %d)",
+				ErrOutputLimitExceeded, bytesReceived, maxOutputBytes, fileSize)
+		}
+		if envelope != nil && envelope.Final && offset != fileSize {
+			return fmt.Errorf("stream ended at %d bytes, expected %d", offset, fileSize)
 		}
+		if verifier != nil {
+			if data, err = verifier.add(data, offset >= fileSize); err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				continue
+			}
+		}
+		pending = append(pending, data...)
+		pendingChunks++
 
-		logger.Debug(fmt.Sprintf("Received %d bytes of data", len(data)))
+		if pendingChunks < opts.BufferChunks && offset < fileSize {
+			logger.Debug(fmt.Sprintf("Buffered %d chunks (%d bytes) pending write", pendingChunks, len(pending)))
+			continue
+		}
 
-		if len(data) == 0 {
-			return fmt.Errorf("no data received for offset %d", offset)
+		if jitterPending {
+			jitterPending = false
+			if byteRate, ok := util.ParseWavByteRate(pending); ok && bytesWritten == 0 {
+				jitter = NewJitterBuffer(file, byteRate, time.Duration(opts.JitterMs)*time.Millisecond)
+				out = jitter
+				logger.Info(fmt.Sprintf("Jitter buffer enabled: %d ms at %d bytes/s", opts.JitterMs, byteRate))
+			} else {
+				logger.Warn("Jitter buffer disabled: audio byte rate unknown (requires a WAV header at offset 0)")
+			}
 		}
 
 		// Write to file
-		if err := WriteChunk(outputPath, data, !isFirstChunk); err != nil {
+		if _, err := out.Write(pending); err != nil {
 			return fmt.Errorf("failed to write chunk: %w", err)
 		}
 
-		isFirstChunk = false
-		offset += int64(len(data))
-		bytesReceived += int64(len(data))
+		bytesWritten += int64(len(pending))
+		pending = pending[:0]
+		pendingChunks = 0
 
-		// Report progress
-		progress := int(bytesReceived * 100 / fileSize)
+		// Report progress based on bytes persisted to disk, not bytes still buffered
+		progress := int(bytesWritten * 100 / fileSize)
 		if progress >= lastProgress+25 && progress <= 100 {
-			logger.Info(fmt.Sprintf("Download progress: %d/%d bytes (%d%%)", bytesReceived, fileSize, progress))
+			logger.Info(fmt.Sprintf("Download progress: %d/%d bytes (%d%%)", bytesWritten, fileSize, progress))
 			lastProgress = progress
 		}
 	}
 
+	if jitter != nil {
+		if err := jitter.Flush(); err != nil {
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+	}
+
+	// Flush to disk once at the end rather than per chunk
+	if file != nil {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync output file: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to close output file: %w", err)
+		}
+	}
+
 	// Ensure 100% is reported
 	if lastProgress < 100 {
 		logger.Info(fmt.Sprintf("Download progress: %d/%d bytes (100%%)", fileSize, fileSize))
@@ -69,3 +290,159 @@ func Download(ws *WebSocketClient, streamID string, outputPath string, fileSize
 
 	return nil
 }
+
+// fetchChunkWithRetry requests one chunk, retrying while the server reports
+// the data is not available yet
+func fetchChunkWithRetry(ws *WebSocketClient, streamID string, offset int64, length int, opts DownloadOptions) (data []byte, envelope *ControlMessage, err error) {
+	_, span := telemetry.Start(opts.TraceContext, "download.get")
+	span.SetStreamID(streamID)
+	defer func() {
+		span.SetBytes(int64(len(data)))
+		span.End(err)
+	}()
+
+	delay := opts.GetRetryDelay
+	if delay <= 0 {
+		delay = DefaultGetRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		data, envelope, err = fetchChunk(ws, streamID, offset, length, opts.Envelope, opts.Variant)
+		if err == nil || !isRetryableGetError(err) || attempt >= opts.GetRetries {
+			if errors.Is(err, errEmptyResponse) {
+				err = fmt.Errorf("no data received for offset %d", offset)
+			}
+			return data, envelope, err
+		}
+
+		logger.Debug(fmt.Sprintf("GET at offset %d not served (%v), retry %d/%d in %v",
+			offset, err, attempt+1, opts.GetRetries, delay))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fetchChunk sends one GET and receives its response, including the envelope
+// when requested, from the transcoded variant when one is named. The server
+// may send less data than requested.
+func fetchChunk(ws *WebSocketClient, streamID string, offset int64, length int, withEnvelope bool, variant string) ([]byte, *ControlMessage, error) {
+	offsetPtr := offset
+	lengthPtr := length
+	err := ws.SendControlMessage(ControlMessage{
+		Type:     "GET",
+		StreamID: streamID,
+		Offset:   &offsetPtr,
+		Length:   &lengthPtr,
+		Envelope: withEnvelope,
+		Variant:  variant,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send GET message: %w", err)
+	}
+
+	// With envelopes, the server states the exact length and end of stream first
+	var envelope *ControlMessage
+	if withEnvelope {
+		if envelope, err = receiveEnvelope(ws, offset); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// One GET request = one binary response
+	logger.Debug(fmt.Sprintf("Waiting for binary data at offset %d", offset))
+	data, err := ws.ReceiveBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to receive data: %w", err)
+	}
+	if envelope != nil && len(data) != *envelope.Length {
+		return nil, nil, fmt.Errorf("truncated response at offset %d: envelope stated %d bytes, received %d",
+			offset, *envelope.Length, len(data))
+	}
+	if len(data) == 0 {
+		return nil, nil, errEmptyResponse
+	}
+	return data, envelope, nil
+}
+
+// isRetryableGetError reports whether a failed GET may succeed later.
+// NOT_READY and BUSY are transient; OUT_OF_RANGE and transport errors are fatal.
+func isRetryableGetError(err error) bool {
+	if errors.Is(err, errEmptyResponse) {
+		return true
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.Code == ErrCodeNotReady || serverErr.Code == ErrCodeBusy
+	}
+	return false
+}
+
+// receiveEnvelope reads the DATA envelope that precedes a GET's binary frame
+func receiveEnvelope(ws *WebSocketClient, offset int64) (*ControlMessage, error) {
+	envelope, err := ws.ReceiveControlMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive data envelope: %w", err)
+	}
+	if envelope.Type == "ERROR" {
+		return nil, fmt.Errorf("failed to receive data: %w",
+			newServerError(envelope))
+	}
+	if envelope.Type != "DATA" || envelope.Length == nil {
+		return nil, fmt.Errorf("unexpected response to GET: %s", envelope.Type)
+	}
+	if envelope.Offset != nil && *envelope.Offset != offset {
+		return nil, fmt.Errorf("data envelope for offset %d, expected %d", *envelope.Offset, offset)
+	}
+	return envelope, nil
+}
+
+// verifiedResumeOffset checks a partial output of length bytes against the
+// stream's block hashes, queried unless already known, and truncates it after
+// the last good block
+func verifiedResumeOffset(ws *WebSocketClient, streamID string, outputPath string, length int64, hashes *BlockHashes) (int64, error) {
+	if hashes == nil {
+		var err error
+		if hashes, err = QueryBlockHashes(ws, streamID, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	verified, err := verifyPartialOutput(outputPath, length, hashes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to verify partial output %s: %w", outputPath, err)
+	}
+	if verified < length {
+		logger.Info(fmt.Sprintf("Truncating partial output %s from %d to %d verified bytes", outputPath, length, verified))
+		if err := os.Truncate(outputPath, verified); err != nil {
+			return 0, fmt.Errorf("failed to truncate partial output %s: %w", outputPath, err)
+		}
+	}
+	return verified, nil
+}
+
+// resumeOffset returns the size of an existing partial output file, or 0 if
+// there is nothing usable to resume from
+func resumeOffset(outputPath string, fileSize int64) int64 {
+	info, err := os.Stat(outputPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	if info.Size() > fileSize {
+		logger.Warn(fmt.Sprintf("Existing output %s is larger than the stream (%d > %d bytes), starting over",
+			outputPath, info.Size(), fileSize))
+		return 0
+	}
+	return info.Size()
+}
+
+// removePartialOutput deletes an output file left behind by a failed download
+func removePartialOutput(outputPath string) {
+	info, statErr := os.Stat(outputPath)
+	if statErr != nil {
+		return
+	}
+	if err := os.Remove(outputPath); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to remove partial output %s: %v", outputPath, err))
+		return
+	}
+	logger.Info(fmt.Sprintf("Removed partial output %s (%d bytes)", outputPath, info.Size()))
+}
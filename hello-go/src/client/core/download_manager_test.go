@@ -0,0 +1,307 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+// flakyServer fails the first failures GETs with an ERROR of code, or with
+// an empty frame when code is empty, and then serves data
+func flakyServer(data []byte, failures int, code string, gets *atomic.Int32) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		for {
+			var msg ControlMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type != "GET" {
+				continue
+			}
+			switch n := int(gets.Add(1)); {
+			case n > failures:
+				end := min(*msg.Offset+int64(*msg.Length), int64(len(data)))
+				conn.WriteMessage(websocket.BinaryMessage, data[*msg.Offset:end])
+			case code == "":
+				conn.WriteMessage(websocket.BinaryMessage, []byte{})
+			default:
+				conn.WriteJSON(ControlMessage{Type: "ERROR", Code: code, Message: "injected " + code})
+			}
+		}
+	}
+}
+
+func TestDownloadRetriesTransientGetErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("retry"), 1000)
+	for _, code := range []string{ErrCodeNotReady, ErrCodeBusy, ""} {
+		name := code
+		if name == "" {
+			name = "empty response"
+		}
+		t.Run(name, func(t *testing.T) {
+			var gets atomic.Int32
+			uri := newFakeServer(t, flakyServer(data, 2, code, &gets))
+
+			output := filepath.Join(t.TempDir(), "out.bin")
+			opts := DownloadOptions{GetRetries: 3, GetRetryDelay: time.Millisecond}
+			if err := Download(dialFake(t, uri), "retry", output, int64(len(data)), opts); err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+				t.Error("downloaded data differs from the stream")
+			}
+			if n := gets.Load(); n != 3 {
+				t.Errorf("%d GETs, want 2 failures and a success", n)
+			}
+		})
+	}
+}
+
+func TestDownloadGivesUpAfterGetRetries(t *testing.T) {
+	data := bytes.Repeat([]byte("retry"), 1000)
+	var gets atomic.Int32
+	uri := newFakeServer(t, flakyServer(data, 10, ErrCodeNotReady, &gets))
+
+	opts := DownloadOptions{GetRetries: 2, GetRetryDelay: time.Millisecond}
+	err := Download(dialFake(t, uri), "retry", filepath.Join(t.TempDir(), "out.bin"), int64(len(data)), opts)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != ErrCodeNotReady {
+		t.Fatalf("Download error = %v, want NOT_READY", err)
+	}
+	if n := gets.Load(); n != 3 {
+		t.Errorf("%d GETs, want the first and 2 retries", n)
+	}
+}
+
+func TestDownloadDoesNotRetryOutOfRange(t *testing.T) {
+	data := bytes.Repeat([]byte("retry"), 1000)
+	var gets atomic.Int32
+	uri := newFakeServer(t, flakyServer(data, 1, ErrCodeOutOfRange, &gets))
+
+	opts := DownloadOptions{GetRetries: 3, GetRetryDelay: time.Millisecond}
+	err := Download(dialFake(t, uri), "retry", filepath.Join(t.TempDir(), "out.bin"), int64(len(data)), opts)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != ErrCodeOutOfRange {
+		t.Fatalf("Download error = %v, want OUT_OF_RANGE", err)
+	}
+	if n := gets.Load(); n != 1 {
+		t.Errorf("%d GETs, want OUT_OF_RANGE to be fatal at once", n)
+	}
+}
+
+// envelopeServer answers GETs from data with a DATA envelope, marking the
+// response final once it reaches end, which may be short of len(data)
+func envelopeServer(data []byte, end int64) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		for {
+			var msg ControlMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			stop := min(*msg.Offset+int64(*msg.Length), end)
+			chunk := data[*msg.Offset:stop]
+			length := len(chunk)
+			conn.WriteJSON(ControlMessage{Type: "DATA", StreamID: msg.StreamID, Offset: msg.Offset, Length: &length, Final: stop == end})
+			conn.WriteMessage(websocket.BinaryMessage, chunk)
+		}
+	}
+}
+
+func TestDownloadEnvelopeNearEOF(t *testing.T) {
+	data := bytes.Repeat([]byte("envelope"), 10000) // Last chunk is shorter than ChunkSize
+	output := filepath.Join(t.TempDir(), "out.bin")
+	uri := newFakeServer(t, envelopeServer(data, int64(len(data))))
+	if err := Download(dialFake(t, uri), "envelope", output, int64(len(data)), DownloadOptions{Envelope: true}); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+		t.Error("downloaded data differs from the stream")
+	}
+}
+
+func TestDownloadEnvelopeFinalTooEarly(t *testing.T) {
+	data := bytes.Repeat([]byte("envelope"), 10000)
+	uri := newFakeServer(t, envelopeServer(data, int64(len(data))-100))
+	err := Download(dialFake(t, uri), "envelope", filepath.Join(t.TempDir(), "out.bin"), int64(len(data)), DownloadOptions{Envelope: true})
+	if err == nil || !strings.Contains(err.Error(), "stream ended") {
+		t.Fatalf("Download error = %v, want the stream to have ended early", err)
+	}
+}
+
+func TestDownloadAbortsWhenServerOvershoots(t *testing.T) {
+	data := bytes.Repeat([]byte("overshoot"), 10000)
+	// Every response carries three times the requested range
+	uri := newFakeServer(t, getServer(data, 4096, func(offset int64, chunk []byte) []byte {
+		return append(chunk, make([]byte, 2*len(chunk))...)
+	}))
+
+	for name, opts := range map[string]DownloadOptions{
+		"default limit":  {},
+		"explicit limit": {MaxOutputBytes: int64(len(data)) + 1},
+		"resume":         {Resume: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "out.bin")
+			err := Download(dialFake(t, uri), "overshoot", output, int64(len(data)), opts)
+			if !errors.Is(err, ErrOutputLimitExceeded) {
+				t.Fatalf("Download error = %v, want ErrOutputLimitExceeded", err)
+			}
+			if _, err := os.Stat(output); !os.IsNotExist(err) {
+				t.Errorf("partial output was left behind (stat error %v)", err)
+			}
+		})
+	}
+}
+
+// dyingServer serves the first GET from data and then drops the connection
+func dyingServer(data []byte) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		var msg ControlMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.BinaryMessage, data[*msg.Offset:*msg.Offset+int64(*msg.Length)])
+		conn.ReadJSON(&msg)
+	}
+}
+
+func TestDownloadFailureCleanup(t *testing.T) {
+	data := bytes.Repeat([]byte("cleanup"), 30000) // More than one chunk
+	for _, tc := range []struct {
+		name string
+		opts DownloadOptions
+		kept bool
+	}{
+		{"cleanup", DownloadOptions{CleanupOnFailure: true}, false},
+		{"no cleanup", DownloadOptions{}, true},
+		{"cleanup with resume", DownloadOptions{CleanupOnFailure: true, Resume: true}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			uri := newFakeServer(t, dyingServer(data))
+			output := filepath.Join(t.TempDir(), "out.bin")
+			if err := Download(dialFake(t, uri), "dying", output, int64(len(data)), tc.opts); err == nil {
+				t.Fatal("Download succeeded although the server went away")
+			}
+
+			got, err := os.ReadFile(output)
+			if kept := err == nil; kept != tc.kept {
+				t.Fatalf("partial output kept=%v, want %v", kept, tc.kept)
+			}
+			if tc.kept && !bytes.Equal(got, data[:ChunkSize]) {
+				t.Errorf("kept %d bytes, want the %d-byte first chunk", len(got), ChunkSize)
+			}
+		})
+	}
+}
+
+func TestDownloadUncreatableOutputPath(t *testing.T) {
+	data := []byte("never fetched")
+	var gets atomic.Int32
+	uri := newFakeServer(t, flakyServer(data, 0, "", &gets))
+
+	// A regular file where the output directory should be
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(blocker, "audio", "out.bin")
+
+	err := Download(dialFake(t, uri), "uncreatable", output, int64(len(data)), DownloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "cannot create output directory") {
+		t.Fatalf("Download error = %v, want the output directory named", err)
+	}
+	if n := gets.Load(); n != 0 {
+		t.Errorf("%d GETs sent before the output path was checked", n)
+	}
+}
+
+// slowDisk is a sink that takes latency per Write call, like a filesystem
+// where every write is a slow syscall
+type slowDisk struct {
+	latency time.Duration
+	writes  int
+	data    bytes.Buffer
+}
+
+func (d *slowDisk) Write(p []byte) (int, error) {
+	d.writes++
+	time.Sleep(d.latency)
+	return d.data.Write(p)
+}
+
+func TestDownloadBufferChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("buffered"), 8*ChunkSize/8) // Eight chunks
+	uri := newFakeServer(t, getServer(data, 4096, nil))
+
+	for _, tc := range []struct{ bufferChunks, writes int }{{0, 8}, {1, 8}, {4, 2}, {3, 3}, {16, 1}} {
+		disk := &slowDisk{}
+		opts := DownloadOptions{BufferChunks: tc.bufferChunks, Sink: disk}
+		if err := Download(dialFake(t, uri), "buffered", "", int64(len(data)), opts); err != nil {
+			t.Fatalf("buffer %d: %v", tc.bufferChunks, err)
+		}
+		if !bytes.Equal(disk.data.Bytes(), data) {
+			t.Errorf("buffer %d: written data differs from the stream", tc.bufferChunks)
+		}
+		if disk.writes != tc.writes {
+			t.Errorf("buffer %d: %d writes, want %d", tc.bufferChunks, disk.writes, tc.writes)
+		}
+	}
+}
+
+// BenchmarkDownloadSlowDisk downloads 4 MiB to a sink taking 1ms per write,
+// writing every chunk or buffering 8 chunks per write
+func BenchmarkDownloadSlowDisk(b *testing.B) {
+	data := bytes.Repeat([]byte("slowdisk"), 4*1024*1024/8)
+	uri := newFakeServer(b, getServer(data, 4096, nil))
+	logger.SetOutput(io.Discard)
+	b.Cleanup(func() { logger.SetOutput(os.Stdout) })
+
+	for _, bufferChunks := range []int{1, 8} {
+		b.Run(fmt.Sprintf("buffer=%d", bufferChunks), func(b *testing.B) {
+			ws := dialFake(b, uri)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				opts := DownloadOptions{BufferChunks: bufferChunks, Sink: &slowDisk{latency: time.Millisecond}}
+				if err := Download(ws, "slowdisk", "", int64(len(data)), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadProgressCountsWrittenBytes(t *testing.T) {
+	data := bytes.Repeat([]byte("progress"), 8*ChunkSize/8)
+	uri := newFakeServer(t, getServer(data, 4096, nil))
+
+	var captured bytes.Buffer
+	logger.SetOutput(&captured)
+	err := Download(dialFake(t, uri), "progress", "", int64(len(data)), DownloadOptions{BufferChunks: 4, Sink: &slowDisk{}})
+	logger.SetOutput(os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Four chunks are written at a time, so progress is only ever 50% or 100%
+	var reported []string
+	for _, line := range strings.Split(captured.String(), "\n") {
+		if _, progress, ok := strings.Cut(line, "Download progress: "); ok {
+			reported = append(reported, progress)
+		}
+	}
+	half := fmt.Sprintf("%d/%d bytes (50%%)", 4*ChunkSize, len(data))
+	full := fmt.Sprintf("%d/%d bytes (100%%)", len(data), len(data))
+	if len(reported) != 2 || reported[0] != half || reported[1] != full {
+		t.Errorf("progress reported %q, want %q then %q", reported, half, full)
+	}
+}
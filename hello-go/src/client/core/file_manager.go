@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -28,24 +29,89 @@ func ReadChunk(path string, offset int64, size int) ([]byte, error) {
 	return buffer[:n], nil
 }
 
-// WriteChunk writes data to a file
-func WriteChunk(path string, data []byte, append bool) error {
-	// Ensure parent directory exists
+// EnsureParentDir creates the parent directory of path if it does not exist
+func EnsureParentDir(path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
+	return nil
+}
+
+// WriteModeKind identifies how output data is placed in the target file
+type WriteModeKind int
+
+const (
+	ModeTruncate WriteModeKind = iota // Discard existing content and write from the start
+	ModeAppend                        // Write after existing content
+	ModeWriteAt                       // Write starting at an explicit offset, keeping other content
+)
+
+// WriteMode selects the write semantics for an output file
+type WriteMode struct {
+	Kind   WriteModeKind
+	Offset int64 // Only used by ModeWriteAt
+}
+
+var (
+	Truncate = WriteMode{Kind: ModeTruncate}
+	Append   = WriteMode{Kind: ModeAppend}
+)
+
+// WriteAt returns a write mode positioned at offset
+func WriteAt(offset int64) WriteMode {
+	return WriteMode{Kind: ModeWriteAt, Offset: offset}
+}
 
+func (m WriteMode) String() string {
+	switch m.Kind {
+	case ModeTruncate:
+		return "truncate"
+	case ModeAppend:
+		return "append"
+	case ModeWriteAt:
+		return fmt.Sprintf("write-at(%d)", m.Offset)
+	default:
+		return fmt.Sprintf("unknown(%d)", int(m.Kind))
+	}
+}
+
+// OpenOutputFile opens a file for incremental writing using the given mode.
+// The parent directory must exist.
+func OpenOutputFile(path string, mode WriteMode) (*os.File, error) {
 	flags := os.O_CREATE | os.O_WRONLY
-	if append {
-		flags |= os.O_APPEND
-	} else {
+	switch mode.Kind {
+	case ModeTruncate:
 		flags |= os.O_TRUNC
+	case ModeAppend:
+		flags |= os.O_APPEND
+	case ModeWriteAt:
+		if mode.Offset < 0 {
+			return nil, fmt.Errorf("invalid write offset: %d", mode.Offset)
+		}
+	default:
+		return nil, fmt.Errorf("unknown write mode: %s", mode)
 	}
 
 	file, err := os.OpenFile(path, flags, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to open file for writing: %w", err)
+		return nil, fmt.Errorf("failed to open file for writing: %w", err)
+	}
+
+	if mode.Kind == ModeWriteAt {
+		if _, err := file.Seek(mode.Offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek file: %w", err)
+		}
+	}
+	return file, nil
+}
+
+// WriteChunk writes data to a file using the given mode. The parent directory must exist.
+func WriteChunk(path string, data []byte, mode WriteMode) error {
+	file, err := OpenOutputFile(path, mode)
+	if err != nil {
+		return err
 	}
 	defer file.Close()
 
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteChunkModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode WriteMode
+		want string
+	}{
+		{Truncate, "new"},
+		{Append, "0123456789new"},
+		{WriteAt(3), "012new6789"},
+		{WriteAt(0), "new3456789"},
+		{WriteAt(12), "0123456789\x00\x00new"},
+	} {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.bin")
+			if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteChunk(path, []byte("new"), tc.mode); err != nil {
+				t.Fatalf("WriteChunk: %v", err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tc.want {
+				t.Errorf("file holds %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteChunkModesCreateMissingFile(t *testing.T) {
+	for _, mode := range []WriteMode{Truncate, Append, WriteAt(0)} {
+		path := filepath.Join(t.TempDir(), "out.bin")
+		if err := WriteChunk(path, []byte("new"), mode); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != "new" {
+			t.Errorf("%s created %q, want %q", mode, got, "new")
+		}
+	}
+}
+
+func TestWriteChunkInvalidModes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bin")
+	for _, mode := range []WriteMode{WriteAt(-1), {Kind: WriteModeKind(99)}} {
+		if err := WriteChunk(path, []byte("new"), mode); err == nil {
+			t.Errorf("%s was accepted", mode)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("an invalid mode created the file (stat error %v)", err)
+	}
+}
+
+func TestOpenOutputFileAppendsAcrossWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bin")
+	for _, chunk := range []string{"first,", "second"} {
+		if err := WriteChunk(path, []byte(chunk), Append); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := os.ReadFile(path); string(got) != "first,second" {
+		t.Errorf("file holds %q after two appends", got)
+	}
+}
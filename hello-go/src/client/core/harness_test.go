@@ -0,0 +1,101 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newFakeServer serves one WebSocket endpoint whose connections are handled
+// by serve, and returns its ws:// URL
+func newFakeServer(t testing.TB, serve func(conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dialFake connects a client to a fake server
+func dialFake(t testing.TB, uri string) *WebSocketClient {
+	t.Helper()
+	ws, err := Connect(uri, false)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// recordingServer acknowledges START and STOP like the server and sends the
+// bytes received between them on uploaded once the stream is stopped
+func recordingServer(uploaded chan<- []byte) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		var data []byte
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType == websocket.BinaryMessage {
+				data = append(data, message...)
+				continue
+			}
+			var msg ControlMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case "START":
+				conn.WriteJSON(ControlMessage{Type: "STARTED", StreamID: msg.StreamID, Version: ProtocolVersion})
+			case "STOP":
+				conn.WriteJSON(ControlMessage{Type: "STOPPED", StreamID: msg.StreamID})
+				uploaded <- data
+				data = nil
+			}
+		}
+	}
+}
+
+// getServer answers GET from data, passing each response through respond
+// when set, and HASHES with the SHA-256 of each blockSize block of data
+func getServer(data []byte, blockSize int, respond func(offset int64, chunk []byte) []byte) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		for {
+			var msg ControlMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case "HASHES":
+				var hashes []string
+				for start := 0; start < len(data); start += blockSize {
+					sum := sha256.Sum256(data[start:min(start+blockSize, len(data))])
+					hashes = append(hashes, hex.EncodeToString(sum[:]))
+				}
+				size := int64(len(data))
+				conn.WriteJSON(ControlMessage{Type: "HASHES", StreamID: msg.StreamID, BlockSize: &blockSize, Size: &size, Hashes: hashes})
+			case "GET":
+				end := min(*msg.Offset+int64(*msg.Length), int64(len(data)))
+				chunk := append([]byte(nil), data[*msg.Offset:end]...)
+				if respond != nil {
+					chunk = respond(*msg.Offset, chunk)
+				}
+				conn.WriteMessage(websocket.BinaryMessage, chunk)
+			}
+		}
+	}
+}
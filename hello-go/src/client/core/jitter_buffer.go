@@ -0,0 +1,77 @@
+package core
+
+import (
+	"io"
+	"time"
+)
+
+// JitterBuffer smooths bursty delivery by holding a fixed amount of audio
+// before output starts, then releasing bytes at the audio's real-time byte
+// rate. It adds the configured delay as startup latency, and output takes at
+// least as long as the audio's playing time.
+type JitterBuffer struct {
+	out      io.Writer
+	byteRate int
+	prefill  int // Bytes held before output starts
+
+	buffered []byte
+	started  bool
+	start    time.Time
+	released int64
+}
+
+// NewJitterBuffer creates a buffer holding delay worth of audio at byteRate
+func NewJitterBuffer(out io.Writer, byteRate int, delay time.Duration) *JitterBuffer {
+	return &JitterBuffer{
+		out:      out,
+		byteRate: byteRate,
+		prefill:  int(int64(byteRate) * delay.Milliseconds() / 1000),
+	}
+}
+
+// Write accepts received data and releases whatever is due for playout
+func (j *JitterBuffer) Write(p []byte) (int, error) {
+	j.buffered = append(j.buffered, p...)
+	if !j.started {
+		if len(j.buffered) < j.prefill {
+			return len(p), nil
+		}
+		j.started = true
+		j.start = time.Now()
+	}
+	return len(p), j.releaseDue()
+}
+
+// Flush releases all remaining data, paced at the byte rate
+func (j *JitterBuffer) Flush() error {
+	if !j.started {
+		j.started = true
+		j.start = time.Now()
+	}
+	for len(j.buffered) > 0 {
+		if err := j.releaseDue(); err != nil {
+			return err
+		}
+		if len(j.buffered) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// releaseDue writes the bytes whose playout time has arrived, keeping the
+// prefilled lead buffered for as long as the network keeps up
+func (j *JitterBuffer) releaseDue() error {
+	due := int64(time.Since(j.start).Seconds()*float64(j.byteRate)) - j.released
+	if due <= 0 {
+		return nil
+	}
+	if due > int64(len(j.buffered)) {
+		due = int64(len(j.buffered))
+	}
+
+	n, err := j.out.Write(j.buffered[:due])
+	j.released += int64(n)
+	j.buffered = j.buffered[n:]
+	return err
+}
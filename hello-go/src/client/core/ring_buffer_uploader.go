@@ -0,0 +1,212 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// ErrUploaderClosed is returned by Write after Close
+var ErrUploaderClosed = errors.New("ring buffer uploader is closed")
+
+// RingBufferUploader streams live capture to the server through a fixed-size
+// ring buffer: a producer goroutine writes into the buffer while a sender
+// goroutine drains whatever has accumulated over the WebSocket. The buffer is
+// an ordinary in-memory slice, not a memory-mapped file.
+//
+// Overflow: when the network falls behind and the buffer is full, Write blocks
+// until the sender frees space, so backpressure reaches the producer and no
+// captured bytes are dropped. A producer that must not block (for example an
+// audio device callback) should size the buffer for the longest network stall
+// it has to ride out. The buffer is reused, so capture length is unbounded.
+type RingBufferUploader struct {
+	ws        *WebSocketClient
+	streamID  string
+	frameSize int // Max bytes per binary frame
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	buffer []byte
+	head   int // Index of the oldest unsent byte
+	size   int // Unsent bytes in the buffer
+	closed bool
+	abort  bool  // Abort was called: unsent bytes are dropped
+	err    error // First send error; fails later writes
+	sent   int64
+
+	done chan struct{}
+}
+
+// NewRingBufferUploader creates an uploader with a ring buffer of capacity bytes,
+// raised to at least one frame so the sender always has room to work with.
+// The WebSocket must not be used by anyone else until Close returns.
+func NewRingBufferUploader(ws *WebSocketClient, capacity int, frameSize int) *RingBufferUploader {
+	if frameSize <= 0 {
+		frameSize = DefaultUploadChunkSize
+	}
+	if capacity < frameSize {
+		capacity = frameSize
+	}
+	u := &RingBufferUploader{
+		ws:        ws,
+		frameSize: frameSize,
+		buffer:    make([]byte, capacity),
+		done:      make(chan struct{}),
+	}
+	u.cond = sync.NewCond(&u.mutex)
+	return u
+}
+
+// Start opens a stream of unknown length on the server and begins draining the buffer
+func (u *RingBufferUploader) Start() (string, error) {
+	u.streamID = util.GenerateStreamID()
+	if _, err := startStream(u.ws, ControlMessage{StreamID: u.streamID}); err != nil {
+		return "", err
+	}
+	logger.Info(fmt.Sprintf("Live upload started with stream ID: %s", u.streamID))
+
+	go u.drain()
+	return u.streamID, nil
+}
+
+// Write copies p into the ring buffer, blocking while the buffer is full
+func (u *RingBufferUploader) Write(p []byte) (int, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	written := 0
+	for written < len(p) {
+		for u.size == len(u.buffer) && !u.closed && u.err == nil {
+			u.cond.Wait()
+		}
+		if u.err != nil {
+			return written, u.err
+		}
+		if u.closed {
+			return written, ErrUploaderClosed
+		}
+
+		// Copy into the free region, which may wrap past the end of the buffer
+		tail := (u.head + u.size) % len(u.buffer)
+		end := len(u.buffer)
+		if tail < u.head {
+			end = u.head
+		}
+		n := copy(u.buffer[tail:end], p[written:])
+		u.size += n
+		written += n
+		u.cond.Broadcast()
+	}
+	return written, nil
+}
+
+// Buffered returns the number of bytes captured but not yet sent
+func (u *RingBufferUploader) Buffered() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.size
+}
+
+// Sent returns the number of bytes sent so far
+func (u *RingBufferUploader) Sent() int64 {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.sent
+}
+
+// Close stops accepting writes, sends what is still buffered and finalizes the stream
+func (u *RingBufferUploader) Close() error {
+	if u.streamID == "" {
+		return errors.New("ring buffer uploader was not started")
+	}
+
+	u.mutex.Lock()
+	u.closed = true
+	u.cond.Broadcast()
+	u.mutex.Unlock()
+
+	<-u.done
+
+	u.mutex.Lock()
+	err := u.err
+	sent := u.sent
+	u.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if _, err := stopStream(u.ws, u.streamID, u.ws.ReceiveControlMessage); err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Live upload finished: %d bytes sent for stream %s", sent, u.streamID))
+	return nil
+}
+
+// Abort stops accepting writes and drops what is still buffered, closing the
+// connection without STOP so the server marks the stream INCOMPLETE rather
+// than finalizing a partial capture as READY
+func (u *RingBufferUploader) Abort() error {
+	u.mutex.Lock()
+	u.closed = true
+	u.abort = true
+	u.cond.Broadcast()
+	u.mutex.Unlock()
+
+	// Closing first unblocks a send stalled on the network
+	err := u.ws.Close()
+	if u.streamID != "" {
+		<-u.done
+		logger.Warn(fmt.Sprintf("Live upload aborted after %d bytes; stream %s left unfinished", u.Sent(), u.streamID))
+	}
+	return err
+}
+
+// drain sends buffered bytes as they accumulate until the uploader is closed and empty
+func (u *RingBufferUploader) drain() {
+	defer close(u.done)
+
+	for {
+		u.mutex.Lock()
+		for u.size == 0 && !u.closed {
+			u.cond.Wait()
+		}
+		if u.size == 0 || u.abort {
+			u.mutex.Unlock()
+			return
+		}
+
+		// Send the contiguous part of the unsent region; the producer only
+		// writes into free space, so these bytes are stable while unlocked
+		n := u.size
+		if u.head+n > len(u.buffer) {
+			n = len(u.buffer) - u.head
+		}
+		if n > u.frameSize {
+			n = u.frameSize
+		}
+		frame := u.buffer[u.head : u.head+n]
+		u.mutex.Unlock()
+
+		err := u.ws.SendBinary(frame)
+
+		u.mutex.Lock()
+		if u.abort {
+			u.mutex.Unlock()
+			return
+		}
+		if err != nil {
+			u.err = fmt.Errorf("failed to send chunk: %w", err)
+			u.cond.Broadcast()
+			u.mutex.Unlock()
+			return
+		}
+		u.head = (u.head + n) % len(u.buffer)
+		u.size -= n
+		u.sent += int64(n)
+		u.cond.Broadcast()
+		u.mutex.Unlock()
+	}
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRingBufferUploaderHoldsAtLeastOneFrame(t *testing.T) {
+	for _, capacity := range []int{-1, 0, 100} {
+		u := NewRingBufferUploader(nil, capacity, 1024)
+		if len(u.buffer) != 1024 {
+			t.Errorf("capacity %d: buffer holds %d bytes, want one 1024-byte frame", capacity, len(u.buffer))
+		}
+	}
+}
+
+func TestRingBufferUploaderWithZeroCapacity(t *testing.T) {
+	uploaded := make(chan []byte, 1)
+	ws := dialFake(t, newFakeServer(t, recordingServer(uploaded)))
+
+	u := NewRingBufferUploader(ws, 0, 256)
+	if _, err := u.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	data := bytes.Repeat([]byte("capture "), 1000) // Wraps the buffer many times
+	if _, err := u.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	select {
+	case got := <-uploaded:
+		if !bytes.Equal(got, data) {
+			t.Fatalf("server received %d bytes that differ from the %d written", len(got), len(data))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive the stream")
+	}
+}
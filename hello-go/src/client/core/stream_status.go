@@ -0,0 +1,74 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// Stream statuses reported by the server
+const (
+	StreamStatusUploading = "UPLOADING"
+	StreamStatusReady     = "READY"
+)
+
+// StreamStatus is the server's STATUS response for one stream
+type StreamStatus struct {
+	StreamID string
+	Status   string // UPLOADING, READY, ERROR or INCOMPLETE
+	Size     int64  // Bytes stored so far; final once READY
+	Digest   string // Finalize digest, when READY
+
+	Variants []VariantSummary // Transcoded variants, once READY
+}
+
+// QueryStreamStatus asks the server for a stream's status and size
+func QueryStreamStatus(ws *WebSocketClient, streamID string) (*StreamStatus, error) {
+	if err := ws.SendControlMessage(ControlMessage{Type: "STATUS", StreamID: streamID}); err != nil {
+		return nil, fmt.Errorf("failed to send STATUS message: %w", err)
+	}
+
+	response, err := ws.ReceiveControlMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive STATUS: %w", err)
+	}
+	if response.Type == "ERROR" {
+		return nil, fmt.Errorf("server rejected STATUS: %w", newServerError(response))
+	}
+	if response.Type != "STATUS" || response.Size == nil {
+		return nil, fmt.Errorf("unexpected response to STATUS: %s", response.Type)
+	}
+
+	return &StreamStatus{
+		StreamID: response.StreamID,
+		Status:   response.Status,
+		Size:     *response.Size,
+		Digest:   response.Digest,
+		Variants: response.Variants,
+	}, nil
+}
+
+// queryDownloadSize returns the final size of a stream, or of its variant in
+// format variant, for a download that was not told it, failing unless the
+// stream is READY
+func queryDownloadSize(ws *WebSocketClient, streamID string, variant string) (int64, error) {
+	status, err := QueryStreamStatus(ws, streamID)
+	if err != nil {
+		return 0, fmt.Errorf("cannot determine size of stream %s (pass the size explicitly): %w", streamID, err)
+	}
+	if status.Status != StreamStatusReady {
+		return 0, fmt.Errorf("cannot determine size of stream %s: status is %s, not %s (pass the size explicitly)",
+			streamID, status.Status, StreamStatusReady)
+	}
+	if variant != "" {
+		for _, v := range status.Variants {
+			if v.Format == variant {
+				logger.Info(fmt.Sprintf("Stream %s has a %s variant of %d bytes", streamID, variant, v.Size))
+				return v.Size, nil
+			}
+		}
+		return 0, fmt.Errorf("stream %s has no %s variant", streamID, variant)
+	}
+	logger.Info(fmt.Sprintf("Stream %s is %d bytes", streamID, status.Size))
+	return status.Size, nil
+}
@@ -0,0 +1,28 @@
+package core
+
+import "math/rand"
+
+// syntheticPatternSize is the length of the repeated pattern; large enough
+// that compression and caching along the path see varied data
+const syntheticPatternSize = 1024 * 1024
+
+// SyntheticSource generates stream data in memory: a fixed pseudo-random
+// pattern repeated without end, so uploads can be benchmarked without disk I/O
+type SyntheticSource struct {
+	pattern []byte
+}
+
+// NewSyntheticSource creates a source with a deterministic pattern
+func NewSyntheticSource() *SyntheticSource {
+	pattern := make([]byte, syntheticPatternSize)
+	rand.New(rand.NewSource(1)).Read(pattern)
+	return &SyntheticSource{pattern: pattern}
+}
+
+// ReadAt fills p with the pattern bytes at offset off; it never fails
+func (s *SyntheticSource) ReadAt(p []byte, off int64) (int, error) {
+	for n := 0; n < len(p); {
+		n += copy(p[n:], s.pattern[(off+int64(n))%syntheticPatternSize:])
+	}
+	return len(p), nil
+}
@@ -1,62 +1,227 @@
 package core
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
 
 	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
 )
 
-func Upload(ws *WebSocketClient, filePath string, fileSize int64) (string, error) {
-	// Generate unique stream ID
-	streamID := util.GenerateStreamID()
-	logger.Info(fmt.Sprintf("Generated stream ID: %s", streamID))
+// Upload chunk sizes.
+// The default is small (8KB) to avoid WebSocket frame fragmentation,
+// which the Java server doesn't handle properly.
+const (
+	DefaultUploadChunkSize = 8192
+	MinUploadChunkSize     = 1024 // Below this, per-frame overhead dominates throughput
+)
 
-	// Send START message
-	err := ws.SendControlMessage(ControlMessage{
-		Type:     "START",
-		StreamID: streamID,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to send START message: %w", err)
+// ChunkChecksumCRC32 prefixes every uploaded frame with the 4-byte
+// big-endian CRC32 (IEEE) of its payload, checked by the server before writing
+const ChunkChecksumCRC32 = "crc32"
+
+// UploadOptions controls optional upload behavior
+type UploadOptions struct {
+	Tracer    *util.ChunkTracer        // Optional per-chunk timing trace
+	Perf      *util.PerformanceMonitor // Optional; credited with each sent chunk for live snapshots
+	ChunkSize int                      // Bytes per binary frame; 0 uses DefaultUploadChunkSize
+
+	// Bytes read from disk at a time and then sliced into ChunkSize frames;
+	// 0 reads one frame at a time
+	ReadBlockSize int
+
+	ChunkChecksum bool // Prefix each frame with its CRC32; the server must list crc32 in its capabilities
+
+	// Prefix each frame with its sequence number so the server can detect
+	// (and within its reorder window, repair) out-of-order arrival. Only set
+	// when the server advertises SequencedFrames.
+	SequenceFrames bool
+
+	// Ask the server to transcode the finalized stream to this format; the
+	// variant is then downloaded with DownloadOptions.Variant
+	TranscodeTo string
+
+	// Deflate level 1-9 the server should use for this stream's GET
+	// responses; 0 leaves the server default. Only set when the server
+	// advertises compression.
+	CompressionLevel int
+
+	// Hash the input with the algorithm the server reports in STOPPED and
+	// fail the upload if the digests differ
+	VerifyDigest bool
+
+	// SHA-256 hex digest of the input, sent in START. A server that already
+	// holds this content answers ALREADY_EXISTS and the upload is skipped;
+	// Upload then returns the existing stream's ID.
+	SHA256 string
+
+	// ID for the new stream, e.g. a content ID; empty generates one
+	StreamID string
+
+	// Parent of the start, send and stop phase spans when OpenTelemetry
+	// tracing is enabled
+	TraceContext context.Context
+}
+
+// Upload sends the file at filePath as a new stream and returns its ID
+func Upload(ws *WebSocketClient, filePath string, fileSize int64, opts UploadOptions) (string, error) {
+	read := func(offset int64, size int) ([]byte, error) {
+		return ReadChunk(filePath, offset, size)
 	}
+	return upload(ws, filePath, read, fileSize, opts)
+}
 
-	// Wait for START_ACK
-	response, err := ws.ReceiveControlMessage()
+// UploadReader sends size bytes of source as a new stream, e.g. generated
+// data that never touches disk. VerifyDigest is not supported and ignored.
+func UploadReader(ws *WebSocketClient, source io.ReaderAt, size int64, opts UploadOptions) (string, error) {
+	read := func(offset int64, n int) ([]byte, error) {
+		block := make([]byte, n)
+		read, err := source.ReadAt(block, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return block[:read], nil
+	}
+	opts.VerifyDigest = false
+	return upload(ws, "input", read, size, opts)
+}
+
+// upload sends fileSize bytes obtained from read; name identifies the
+// source in errors and is the file hashed for VerifyDigest
+func upload(ws *WebSocketClient, name string, read func(offset int64, size int) ([]byte, error), fileSize int64, opts UploadOptions) (_ string, err error) {
+	// Generate unique stream ID unless the caller chose one
+	streamID := opts.StreamID
+	if streamID == "" {
+		streamID = util.GenerateStreamID()
+		logger.Info(fmt.Sprintf("Generated stream ID: %s", streamID))
+	}
+
+	// Trace each protocol phase; the open phase ends with the upload's error
+	var phase *telemetry.Span
+	var phaseBytes int64
+	enterPhase := func(name string) {
+		phase.End(nil)
+		_, phase = telemetry.Start(opts.TraceContext, name)
+		phase.SetStreamID(streamID)
+	}
+	defer func() {
+		phase.SetBytes(phaseBytes)
+		phase.End(err)
+	}()
+	enterPhase("upload.start")
+
+	checksum := ""
+	if opts.ChunkChecksum {
+		checksum = ChunkChecksumCRC32
+	}
+	started, err := startStream(ws, ControlMessage{
+		StreamID:         streamID,
+		Size:             &fileSize,
+		ChunkChecksum:    checksum,
+		CompressionLevel: opts.CompressionLevel,
+		Sha256:           opts.SHA256,
+		Sequenced:        opts.SequenceFrames,
+		TranscodeTo:      opts.TranscodeTo,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to receive START_ACK: %w", err)
+		return "", err
 	}
-	if response.Type != "STARTED" {
-		return "", fmt.Errorf("unexpected response to START: %s", response.Type)
+	if started.Type == "ALREADY_EXISTS" {
+		logger.Info(fmt.Sprintf("Server already holds this content as stream %s; skipping upload", started.StreamID))
+		if opts.VerifyDigest {
+			if err := verifyServerDigest(name, started); err != nil {
+				return "", err
+			}
+		}
+		return started.StreamID, nil
 	}
 
 	// Upload file in chunks
-	// Use smaller chunk size (8KB) to avoid WebSocket frame fragmentation
-	// which the Java server doesn't handle properly
-	const uploadChunkSize = 8192
+	uploadChunkSize := opts.ChunkSize
+	if uploadChunkSize <= 0 {
+		uploadChunkSize = DefaultUploadChunkSize
+	}
+	if uploadChunkSize < MinUploadChunkSize {
+		logger.Warn(fmt.Sprintf("Upload chunk size %d bytes is very small (recommended at least %d); throughput will suffer",
+			uploadChunkSize, MinUploadChunkSize))
+	}
+	readBlockSize := opts.ReadBlockSize
+	if readBlockSize < uploadChunkSize {
+		readBlockSize = uploadChunkSize
+	}
+
 	var offset int64 = 0
 	var bytesSent int64 = 0
 	lastProgress := 0
+	var sequence uint32
+	enterPhase("upload.send")
+
+	// Watch for an ERROR while sending; the watcher also receives STOPPED.
+	// A failed upload stops the watcher, leaving ws unreadable, so the caller
+	// should close ws.
+	watcher := watchUpload(ws)
+	defer func() {
+		if err != nil {
+			watcher.stop()
+		}
+	}()
 
 	for offset < fileSize {
-		chunkSize := int(Min(int64(uploadChunkSize), fileSize-offset))
-		chunk, err := ReadChunk(filePath, offset, chunkSize)
+		// Read a large block from disk, then send it as frames of uploadChunkSize
+		blockSize := int(Min(int64(readBlockSize), fileSize-offset))
+		block, err := read(offset, blockSize)
 		if err != nil {
 			return "", fmt.Errorf("failed to read chunk: %w", err)
 		}
-
-		if err := ws.SendBinary(chunk); err != nil {
-			return "", fmt.Errorf("failed to send chunk: %w", err)
+		if len(block) == 0 {
+			return "", fmt.Errorf("unexpected end of %s at offset %d (expected %d bytes)", name, offset, fileSize)
 		}
 
-		offset += int64(len(chunk))
-		bytesSent += int64(len(chunk))
+		for start := 0; start < len(block); start += uploadChunkSize {
+			chunk := block[start:min(start+uploadChunkSize, len(block))]
+
+			frame := chunk
+			if opts.ChunkChecksum {
+				frame = make([]byte, 4+len(chunk))
+				binary.BigEndian.PutUint32(frame, crc32.ChecksumIEEE(chunk))
+				copy(frame[4:], chunk)
+			}
+			if opts.SequenceFrames {
+				// The sequence number goes ahead of the checksum
+				sequenced := make([]byte, 4+len(frame))
+				binary.BigEndian.PutUint32(sequenced, sequence)
+				copy(sequenced[4:], frame)
+				frame = sequenced
+				sequence++
+			}
+
+			if err := watcher.interrupted(); err != nil {
+				return "", err
+			}
+			if err := ws.SendBinary(frame); err != nil {
+				if interrupted := watcher.interrupted(); interrupted != nil {
+					return "", interrupted
+				}
+				return "", fmt.Errorf("failed to send chunk: %w", err)
+			}
+			opts.Tracer.Record("upload", offset, len(chunk), 0)
+			opts.Perf.AddUploadBytes(len(chunk))
 
-		// Report progress
-		progress := int(bytesSent * 100 / fileSize)
-		if progress >= lastProgress+25 && progress <= 100 {
-			logger.Info(fmt.Sprintf("Upload progress: %d/%d bytes (%d%%)", bytesSent, fileSize, progress))
-			lastProgress = progress
+			offset += int64(len(chunk))
+			bytesSent += int64(len(chunk))
+			phaseBytes = bytesSent
+
+			// Report progress
+			progress := int(bytesSent * 100 / fileSize)
+			if progress >= lastProgress+25 && progress <= 100 {
+				logger.Info(fmt.Sprintf("Upload progress: %d/%d bytes (%d%%)", bytesSent, fileSize, progress))
+				lastProgress = progress
+			}
 		}
 	}
 
@@ -65,23 +230,88 @@ func Upload(ws *WebSocketClient, filePath string, fileSize int64) (string, error
 		logger.Info(fmt.Sprintf("Upload progress: %d/%d bytes (100%%)", fileSize, fileSize))
 	}
 
+	phase.SetBytes(bytesSent)
+	phaseBytes = 0
+	enterPhase("upload.stop")
+	stopped, err := stopStream(ws, streamID, watcher.next)
+	if err != nil {
+		return "", err
+	}
+	if opts.VerifyDigest {
+		if err := verifyServerDigest(name, stopped); err != nil {
+			return "", err
+		}
+	}
+	return streamID, nil
+}
+
+// verifyServerDigest compares the digest in STOPPED (or ALREADY_EXISTS) with the input file's
+func verifyServerDigest(filePath string, stopped *ControlMessage) error {
+	if stopped.Digest == "" {
+		logger.Warn("Server did not report a stream digest; skipping digest verification")
+		return nil
+	}
+
+	local, err := util.ComputeDigest(filePath, stopped.DigestAlgorithm)
+	if err != nil {
+		return fmt.Errorf("failed to compute %s digest of %s: %w", stopped.DigestAlgorithm, filePath, err)
+	}
+	if !strings.EqualFold(local, stopped.Digest) {
+		return fmt.Errorf("server %s digest %s does not match input digest %s", stopped.DigestAlgorithm, stopped.Digest, local)
+	}
+	logger.Info(fmt.Sprintf("Server %s digest matches input: %s", stopped.DigestAlgorithm, local))
+	return nil
+}
+
+// startStream sends start, filled in as a START message, and returns the
+// STARTED reply. A nil Size leaves the stream length open, as for live
+// capture; an empty ChunkChecksum sends plain frames and a zero
+// CompressionLevel leaves the server default. With Sha256 set the reply may
+// instead be ALREADY_EXISTS, naming a stream with that content.
+func startStream(ws *WebSocketClient, start ControlMessage) (*ControlMessage, error) {
+	// Send START message
+	start.Type = "START"
+	start.Version = ProtocolVersion
+	err := ws.SendControlMessage(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send START message: %w", err)
+	}
+
+	// Wait for START_ACK
+	response, err := ws.ReceiveControlMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive START_ACK: %w", err)
+	}
+	if response.Type == "ERROR" {
+		return nil, fmt.Errorf("server rejected START: %w", newServerError(response))
+	}
+	if response.Type != "STARTED" && !(response.Type == "ALREADY_EXISTS" && start.Sha256 != "") {
+		return nil, fmt.Errorf("unexpected response to START: %s", response.Type)
+	}
+	return response, CheckServerVersion(response.Version)
+}
+
+// stopStream sends STOP and returns the STOPPED reply, read with receive
+func stopStream(ws *WebSocketClient, streamID string, receive func() (*ControlMessage, error)) (*ControlMessage, error) {
 	// Send STOP message
-	err = ws.SendControlMessage(ControlMessage{
+	err := ws.SendControlMessage(ControlMessage{
 		Type:     "STOP",
 		StreamID: streamID,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to send STOP message: %w", err)
+		return nil, fmt.Errorf("failed to send STOP message: %w", err)
 	}
 
 	// Wait for STOPPED
-	response, err = ws.ReceiveControlMessage()
+	response, err := receive()
 	if err != nil {
-		return "", fmt.Errorf("failed to receive STOPPED: %w", err)
+		return nil, fmt.Errorf("failed to receive STOPPED: %w", err)
+	}
+	if response.Type == "ERROR" {
+		return nil, fmt.Errorf("server rejected STOP: %w", newServerError(response))
 	}
 	if response.Type != "STOPPED" {
-		return "", fmt.Errorf("unexpected response to STOP: %s", response.Type)
+		return nil, fmt.Errorf("unexpected response to STOP: %s", response.Type)
 	}
-
-	return streamID, nil
+	return response, nil
 }
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+func TestStartAndStopErrorsKeepLimit(t *testing.T) {
+	limit := int64(2)
+	uri := newFakeServer(t, func(conn *websocket.Conn) {
+		for {
+			var msg ControlMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			conn.WriteJSON(ControlMessage{
+				Type:       "ERROR",
+				Code:       "LIMIT_EXCEEDED",
+				Message:    msg.Type + " refused",
+				LimitName:  "maxStreamsPerConnection",
+				LimitValue: &limit,
+			})
+		}
+	})
+	ws := dialFake(t, uri)
+
+	_, startErr := startStream(ws, ControlMessage{StreamID: "limited"})
+	_, stopErr := stopStream(ws, "limited", ws.ReceiveControlMessage)
+	for name, err := range map[string]error{"START": startErr, "STOP": stopErr} {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			t.Fatalf("%s error %v is not a *ServerError", name, err)
+		}
+		if serverErr.Code != "LIMIT_EXCEEDED" || serverErr.LimitName != "maxStreamsPerConnection" ||
+			serverErr.LimitValue == nil || *serverErr.LimitValue != limit {
+			t.Errorf("%s error = %+v, want LIMIT_EXCEEDED maxStreamsPerConnection=2", name, serverErr)
+		}
+		if !strings.Contains(err.Error(), "maxStreamsPerConnection=2") {
+			t.Errorf("%s error text %q does not state the limit", name, err)
+		}
+	}
+}
+
+func TestUploadStreamID(t *testing.T) {
+	previous := util.IDGenerator
+	util.IDGenerator = func() string { return "generated-id" }
+	t.Cleanup(func() { util.IDGenerator = previous })
+
+	uploaded := make(chan []byte, 2)
+	ws := dialFake(t, newFakeServer(t, recordingServer(uploaded)))
+	data := []byte("deterministic")
+
+	streamID, err := UploadReader(ws, bytes.NewReader(data), int64(len(data)), UploadOptions{})
+	if err != nil || streamID != "generated-id" {
+		t.Fatalf("upload = %q, %v; want the generator's ID", streamID, err)
+	}
+	streamID, err = UploadReader(ws, bytes.NewReader(data), int64(len(data)), UploadOptions{StreamID: "chosen-id"})
+	if err != nil || streamID != "chosen-id" {
+		t.Fatalf("upload with StreamID = %q, %v; want the chosen ID", streamID, err)
+	}
+}
+
+// countingFile counts the ReadAt calls, one pread each, made on a file
+type countingFile struct {
+	*os.File
+	reads int
+}
+
+func (f *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	f.reads++
+	return f.File.ReadAt(p, off)
+}
+
+// BenchmarkUploadReadBlockSize uploads a 16 MiB file as 8 KiB frames,
+// reading it one frame at a time or in larger blocks, and reports the file
+// reads per upload
+func BenchmarkUploadReadBlockSize(b *testing.B) {
+	const size = 16 * 1024 * 1024
+	path := filepath.Join(b.TempDir(), "input.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("audio"), size/5+1)[:size], 0644); err != nil {
+		b.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, readBlockSize := range []int{DefaultUploadChunkSize, 64 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("read-block=%d", readBlockSize), func(b *testing.B) {
+			uploaded := make(chan []byte, 1)
+			ws := dialFake(b, newFakeServer(b, recordingServer(uploaded)))
+			source := &countingFile{File: file}
+			opts := UploadOptions{ChunkSize: DefaultUploadChunkSize, ReadBlockSize: readBlockSize}
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := UploadReader(ws, source, size, opts); err != nil {
+					b.Fatal(err)
+				}
+				<-uploaded
+			}
+			b.ReportMetric(float64(source.reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestTinyUploadChunkSizeWarns(t *testing.T) {
+	uploaded := make(chan []byte, 2)
+	ws := dialFake(t, newFakeServer(t, recordingServer(uploaded)))
+	data := bytes.Repeat([]byte("tiny"), 100)
+
+	for _, tc := range []struct {
+		chunkSize int
+		warned    bool
+	}{{16, true}, {MinUploadChunkSize, false}} {
+		var captured bytes.Buffer
+		logger.SetOutput(&captured)
+		_, err := UploadReader(ws, bytes.NewReader(data), int64(len(data)), UploadOptions{ChunkSize: tc.chunkSize})
+		logger.SetOutput(os.Stdout)
+		if err != nil {
+			t.Fatalf("upload with %d-byte chunks: %v", tc.chunkSize, err)
+		}
+		if got := <-uploaded; !bytes.Equal(got, data) {
+			t.Errorf("upload with %d-byte chunks sent different data", tc.chunkSize)
+		}
+		if warned := strings.Contains(captured.String(), "is very small"); warned != tc.warned {
+			t.Errorf("%d-byte chunks: warned=%v, want %v", tc.chunkSize, warned, tc.warned)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// controlReply is one control message, or the read error, seen by an uploadWatcher
+type controlReply struct {
+	msg *ControlMessage
+	err error
+}
+
+// uploadWatcher reads control messages while Upload is busy sending binary
+// frames, so an ERROR from the server is noticed mid-upload instead of at STOP.
+// It is the connection's only reader until it delivers a terminal reply
+// (anything but PROGRESS, or a read error) and exits.
+type uploadWatcher struct {
+	ws      *WebSocketClient
+	replies chan controlReply
+	done    chan struct{} // Closed when the reading goroutine exits
+}
+
+// watchUpload starts reading control messages from ws
+func watchUpload(ws *WebSocketClient) *uploadWatcher {
+	w := &uploadWatcher{ws: ws, replies: make(chan controlReply, 1), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		for {
+			msg, err := ws.ReceiveControlMessage()
+			if err == nil && msg.Type == "PROGRESS" {
+				logger.Debug(fmt.Sprintf("Server progress for stream %s: offset %v", msg.StreamID, msg.Offset))
+				continue
+			}
+			w.replies <- controlReply{msg: msg, err: err}
+			return
+		}
+	}()
+	return w
+}
+
+// interrupted returns an error if the server answered while frames are still
+// being sent; any reply at this point ends the upload
+func (w *uploadWatcher) interrupted() error {
+	select {
+	case reply := <-w.replies:
+		if reply.err != nil {
+			return fmt.Errorf("connection lost during upload: %w", reply.err)
+		}
+		if reply.msg.Type == "ERROR" {
+			return fmt.Errorf("server aborted upload: %w", newServerError(reply.msg))
+		}
+		return fmt.Errorf("unexpected %s message during upload", reply.msg.Type)
+	default:
+		return nil
+	}
+}
+
+// next waits for the watcher's terminal reply
+func (w *uploadWatcher) next() (*ControlMessage, error) {
+	reply := <-w.replies
+	return reply.msg, reply.err
+}
+
+// stop ends the watcher if it is still reading, by expiring the connection's
+// read deadline, and waits for it to exit. The connection cannot be read
+// afterwards, so stop is only for an upload that has failed.
+func (w *uploadWatcher) stop() {
+	w.ws.conn.SetReadDeadline(time.Now())
+	<-w.done
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// failingServer acknowledges START, then answers the first binary frame
+// with ERROR and keeps reading without replying
+func failingServer(conn *websocket.Conn) {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType == websocket.BinaryMessage {
+			conn.WriteJSON(ControlMessage{Type: "ERROR", Code: "LIMIT_EXCEEDED", Message: "disk full"})
+			continue
+		}
+		var msg ControlMessage
+		if json.Unmarshal(message, &msg) == nil && msg.Type == "START" {
+			conn.WriteJSON(ControlMessage{Type: "STARTED", StreamID: msg.StreamID, Version: ProtocolVersion})
+		}
+	}
+}
+
+func TestUploadStopsWhenServerErrorsMidUpload(t *testing.T) {
+	ws := dialFake(t, newFakeServer(t, failingServer))
+	data := bytes.Repeat([]byte("x"), 64<<20)
+
+	start := time.Now()
+	_, err := UploadReader(ws, bytes.NewReader(data), int64(len(data)), UploadOptions{ChunkSize: 4096})
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Message != "disk full" {
+		t.Fatalf("upload returned %v, want the server's ERROR", err)
+	}
+	if !strings.Contains(err.Error(), "server aborted upload") {
+		t.Errorf("error %q does not say the server aborted the upload", err)
+	}
+	t.Logf("upload aborted after %v", time.Since(start))
+}
+
+// failingReader returns an error for reads past limit
+type failingReader struct {
+	data  []byte
+	limit int64
+}
+
+func (r *failingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.limit {
+		return 0, errors.New("disk read failed")
+	}
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
+
+func TestUploadReadErrorStopsWatcher(t *testing.T) {
+	// The server acknowledges START and then stays silent, so only stopping
+	// the watcher ends its read
+	ws := dialFake(t, newFakeServer(t, func(conn *websocket.Conn) {
+		var msg ControlMessage
+		if conn.ReadJSON(&msg) != nil {
+			return
+		}
+		conn.WriteJSON(ControlMessage{Type: "STARTED", StreamID: msg.StreamID, Version: ProtocolVersion})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	baseline := runtime.NumGoroutine()
+
+	source := &failingReader{data: make([]byte, 100000), limit: 50000}
+	_, err := UploadReader(ws, source, int64(len(source.data)), UploadOptions{ChunkSize: 8192})
+	if err == nil || !strings.Contains(err.Error(), "disk read failed") {
+		t.Fatalf("upload returned %v, want the read error", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines after the failed upload, want %d:\n%s",
+				runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
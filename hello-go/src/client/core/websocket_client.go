@@ -3,11 +3,53 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 
+	"github.com/feuyeux/hello-mmap/hello-go/src/auth"
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
 	"github.com/gorilla/websocket"
 )
 
+// ProtocolVersion is the protocol version sent in START.
+// Servers advertising a version below MinServerProtocolVersion are refused.
+const (
+	ProtocolVersion          = 1
+	MinServerProtocolVersion = 1
+)
+
+// Machine-readable ERROR codes the client acts on
+const (
+	ErrCodeOutOfRange = "OUT_OF_RANGE" // GET past the end of a finalized stream; fatal
+	ErrCodeNotReady   = "NOT_READY"    // GET past the data uploaded so far; retry later
+	ErrCodeBusy       = "BUSY"         // Too many GETs in flight on the connection; retry later
+)
+
+// ServerError is an ERROR message returned by the server
+type ServerError struct {
+	Message string
+	Code    string // Optional machine-readable reason, e.g. OUT_OF_RANGE
+	Size    *int64 // Stream size reported with OUT_OF_RANGE/NOT_READY
+
+	LimitName  string // Limit that refused the request, with LIMIT_EXCEEDED/BUSY
+	LimitValue *int64
+}
+
+// newServerError builds the error for an ERROR reply, keeping every detail it carries
+func newServerError(msg *ControlMessage) *ServerError {
+	return &ServerError{Message: msg.Message, Code: msg.Code, Size: msg.Size, LimitName: msg.LimitName, LimitValue: msg.LimitValue}
+}
+
+func (e *ServerError) Error() string {
+	text := fmt.Sprintf("server error: %s", e.Message)
+	if e.Code != "" {
+		text = fmt.Sprintf("server error [%s]: %s", e.Code, e.Message)
+	}
+	if e.LimitName != "" && e.LimitValue != nil {
+		text += fmt.Sprintf(" (limit %s=%d)", e.LimitName, *e.LimitValue)
+	}
+	return text
+}
+
 type WebSocketClient struct {
 	conn *websocket.Conn
 }
@@ -18,24 +60,86 @@ type ControlMessage struct {
 	Offset   *int64 `json:"offset,omitempty"`
 	Length   *int   `json:"length,omitempty"`
 	Message  string `json:"message,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Version  int    `json:"version,omitempty"`
+	Size     *int64 `json:"size,omitempty"`
+
+	LimitName  string `json:"limitName,omitempty"` // ERROR: limit that refused the request
+	LimitValue *int64 `json:"limitValue,omitempty"`
+
+	Envelope bool `json:"envelope,omitempty"` // GET: ask for a DATA envelope before the binary frame
+	Final    bool `json:"final,omitempty"`    // DATA: this chunk ends the stream
+
+	ChunkChecksum    string `json:"chunkChecksum,omitempty"`    // START: per-chunk checksum framing
+	CompressionLevel int    `json:"compressionLevel,omitempty"` // START: deflate level 1-9 for GET responses
+	Sha256           string `json:"sha256,omitempty"`           // START: SHA-256 of the content, for server dedup
+	Sequenced        bool   `json:"sequenced,omitempty"`        // START: frames carry a sequence number prefix
+	TranscodeTo      string `json:"transcodeTo,omitempty"`      // START: format the server should transcode to on finalize
+	Variant          string `json:"variant,omitempty"`          // GET: read the transcoded variant in this format
+
+	Variants []VariantSummary `json:"variants,omitempty"` // STATUS: transcoded variants ready for GET
+
+	BlockSize *int     `json:"blockSize,omitempty"` // HASHES request and response
+	Hashes    []string `json:"hashes,omitempty"`    // HASHES response: SHA-256 hex per block
+
+	Status          string `json:"status,omitempty"`          // STATUS: stream status, e.g. READY
+	Digest          string `json:"digest,omitempty"`          // STOPPED, STATUS, ALREADY_EXISTS: digest of the finalized stream
+	DigestAlgorithm string `json:"digestAlgorithm,omitempty"` // STOPPED, ALREADY_EXISTS: e.g. sha256 or tree-sha256
+
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// AuthSecret, when set, signs every connection with the server's HMAC
+// handshake (see package auth)
+var AuthSecret string
+
+// VariantSummary is a transcoded variant listed in STATUS
+type VariantSummary struct {
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
 }
 
-func Connect(uri string) (*WebSocketClient, error) {
-	// Configure dialer to disable compression and set larger buffer sizes
+// Connect dials the server. With compression the client offers per-message
+// deflate, which the server may accept.
+func Connect(uri string, compression bool) (*WebSocketClient, error) {
+	if AuthSecret != "" {
+		signed, err := auth.SignURL(uri, AuthSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign handshake: %w", err)
+		}
+		uri = signed
+	}
+
+	// Configure dialer with larger buffer sizes; compression is off unless asked for
 	dialer := websocket.Dialer{
-		EnableCompression: false,
+		EnableCompression: compression,
 		WriteBufferSize:   65536,
 		ReadBufferSize:    65536,
 	}
 
-	conn, _, err := dialer.Dial(uri, nil)
+	conn, resp, err := dialer.Dial(uri, nil)
 	if err != nil {
+		if resp != nil {
+			// The server refused the upgrade, e.g. 401 for a failed auth handshake
+			return nil, fmt.Errorf("failed to connect: %w (%s)", err, resp.Status)
+		}
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
 	return &WebSocketClient{conn: conn}, nil
 }
 
+// SetNoDelay controls TCP_NODELAY on the underlying connection.
+// Disabling it enables Nagle's algorithm: fewer, larger packets at the cost
+// of latency for small frames.
+func (c *WebSocketClient) SetNoDelay(noDelay bool) error {
+	tcpConn, ok := c.conn.UnderlyingConn().(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("underlying connection is not TCP")
+	}
+	return tcpConn.SetNoDelay(noDelay)
+}
+
 func (c *WebSocketClient) Close() error {
 	return c.conn.Close()
 }
@@ -70,7 +174,7 @@ func (c *WebSocketClient) ReceiveBinary() ([]byte, error) {
 		// This might be an error response, try to parse it
 		var msg ControlMessage
 		if err := json.Unmarshal(data, &msg); err == nil && msg.Type == "ERROR" {
-			return nil, fmt.Errorf("server error: %s", msg.Message)
+			return nil, newServerError(&msg)
 		}
 		return nil, fmt.Errorf("expected binary message, got text: %s", string(data))
 	}
@@ -80,6 +184,21 @@ func (c *WebSocketClient) ReceiveBinary() ([]byte, error) {
 	return data, nil
 }
 
+// CheckServerVersion validates the protocol version advertised by the server
+func CheckServerVersion(version int) error {
+	if version == 0 {
+		logger.Warn("Server did not advertise a protocol version, assuming compatible")
+		return nil
+	}
+	if version < MinServerProtocolVersion {
+		return fmt.Errorf("incompatible server protocol version %d (client requires >= %d)", version, MinServerProtocolVersion)
+	}
+	if version > ProtocolVersion {
+		logger.Warn(fmt.Sprintf("Server protocol version %d is newer than client version %d", version, ProtocolVersion))
+	}
+	return nil
+}
+
 func (c *WebSocketClient) SendControlMessage(msg ControlMessage) error {
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+// BenchmarkSmallChunksNoDelay uploads 256 KiB as 1000 frames of 256 bytes,
+// with and without TCP_NODELAY on the client's connection
+func BenchmarkSmallChunksNoDelay(b *testing.B) {
+	const frames, frameSize = 1000, 256
+	data := bytes.Repeat([]byte("small"), frames*frameSize/5+1)[:frames*frameSize]
+	logger.SetOutput(io.Discard)
+	b.Cleanup(func() { logger.SetOutput(os.Stdout) })
+
+	for _, noDelay := range []bool{true, false} {
+		b.Run(fmt.Sprintf("nodelay=%v", noDelay), func(b *testing.B) {
+			uploaded := make(chan []byte, 1)
+			ws := dialFake(b, newFakeServer(b, recordingServer(uploaded)))
+			if err := ws.SetNoDelay(noDelay); err != nil {
+				b.Fatal(err)
+			}
+			opts := UploadOptions{ChunkSize: frameSize}
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := UploadReader(ws, bytes.NewReader(data), int64(len(data)), opts); err != nil {
+					b.Fatal(err)
+				}
+				<-uploaded
+			}
+		})
+	}
+}
+
+func TestCheckServerVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version int
+		ok      bool
+	}{
+		{0, true}, // Not advertised: assumed compatible
+		{MinServerProtocolVersion, true},
+		{ProtocolVersion, true},
+		{ProtocolVersion + 1, true}, // Newer: warned about only
+		{-1, false},
+	} {
+		if err := CheckServerVersion(tc.version); (err == nil) != tc.ok {
+			t.Errorf("CheckServerVersion(%d) = %v, want ok=%v", tc.version, err, tc.ok)
+		}
+	}
+}
+
+func TestStartRefusesIncompatibleServer(t *testing.T) {
+	for _, tc := range []struct {
+		version int
+		ok      bool
+	}{{ProtocolVersion, true}, {ProtocolVersion + 1, true}, {-1, false}} {
+		sent := make(chan int, 1)
+		uri := newFakeServer(t, func(conn *websocket.Conn) {
+			var start ControlMessage
+			if conn.ReadJSON(&start) == nil {
+				sent <- start.Version
+				conn.WriteJSON(ControlMessage{Type: "STARTED", StreamID: start.StreamID, Version: tc.version})
+			}
+		})
+		ws := dialFake(t, uri)
+		_, err := startStream(ws, ControlMessage{StreamID: "versioned"})
+		if (err == nil) != tc.ok {
+			t.Errorf("server version %d: START error %v, want ok=%v", tc.version, err, tc.ok)
+		}
+		if version := <-sent; version != ProtocolVersion {
+			t.Errorf("START carried version %d, want %d", version, ProtocolVersion)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/cli"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/core"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
+)
+
+// runDownload implements the download subcommand: fetch an existing stream
+// by ID, without an input file. Without --size the size comes from STATUS.
+func runDownload(config *cli.Config) {
+	rep := reporter{units: config.Units}
+
+	logger.Info("Audio Stream Cache Client - Go Implementation")
+	logger.Info(fmt.Sprintf("Server URI: %s", config.Server))
+	logger.Info(fmt.Sprintf("Stream ID: %s", config.StreamID))
+	logger.Info(fmt.Sprintf("Output file: %s", config.Output))
+
+	var tracer *util.ChunkTracer
+	if config.TraceFile != "" {
+		var err error
+		tracer, err = util.NewChunkTracer(config.TraceFile)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to open trace file: %v", err))
+			os.Exit(ExitFailure)
+		}
+		defer tracer.Close()
+		onExit(func() { tracer.Close() })
+	}
+
+	logger.Phase("Connecting to Server")
+	ws, err := core.Connect(config.Server, config.CompressionLevel > 0)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to connect to server: %v", err))
+		rep.failRun("connect", err, nil)
+	}
+	defer ws.Close()
+	if err := ws.SetNoDelay(config.NoDelay); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to set TCP_NODELAY=%v: %v", config.NoDelay, err))
+	}
+
+	capabilities, err := core.QueryCapabilities(ws)
+	if err != nil {
+		rep.failRun("connect", err, nil)
+	}
+
+	logger.Phase("Starting Download")
+	start := time.Now()
+	options := newDownloadOptions(config, tracer, capabilities)
+	var span *telemetry.Span
+	options.TraceContext, span = telemetry.Start(context.Background(), "download")
+	span.SetStreamID(config.StreamID)
+	err = core.Download(ws, config.StreamID, config.Output, config.Size, options)
+	if err != nil {
+		span.End(err)
+		logger.Error(fmt.Sprintf("Download failed: %v", err))
+		rep.failRun("download", err, nil)
+	}
+	elapsed := time.Since(start)
+
+	size, err := util.GetFileSize(config.Output)
+	span.SetBytes(size)
+	span.End(err)
+	if err != nil {
+		rep.failRun("download", err, nil)
+	}
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(size*8) / elapsed.Seconds() / 1_000_000
+	}
+	logger.Info(fmt.Sprintf("Downloaded %s in %d ms (%s)", humanizeBytes(size), elapsed.Milliseconds(), rep.formatThroughput(throughput)))
+	logger.Info(fmt.Sprintf("Saved stream %s to %s", config.StreamID, config.Output))
+	logLatencyStats(options.Latency)
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/cli"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/core"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// fanOutResult is the outcome of uploading to one server
+type fanOutResult struct {
+	server   string
+	streamID string
+	report   *util.PerformanceReport
+	err      error
+}
+
+// runFanOut uploads the input file to every configured server and reports
+// per-server and aggregate upload throughput. A non-empty contentSHA256 is
+// offered to each server for dedup, and a non-empty contentStreamID names the
+// stream on every server.
+func runFanOut(config *cli.Config, fileSize int64, tracer *util.ChunkTracer, contentSHA256, contentStreamID string) {
+	rep := reporter{units: config.Units}
+
+	mode := "sequentially"
+	if config.FanOutConcurrent {
+		mode = "concurrently"
+	}
+	logger.Phase(fmt.Sprintf("Fan-out Upload to %d Servers", len(config.Servers)))
+	logger.Info(fmt.Sprintf("Uploading %s", mode))
+
+	options := core.UploadOptions{
+		Tracer:           tracer,
+		ChunkSize:        config.UploadChunkSize,
+		ChunkChecksum:    config.ChunkChecksum,
+		SequenceFrames:   config.SequenceFrames,
+		TranscodeTo:      config.TranscodeTo,
+		VerifyDigest:     config.VerifyDigest,
+		CompressionLevel: config.CompressionLevel,
+		ReadBlockSize:    config.ReadBlockSize,
+		SHA256:           contentSHA256,
+		StreamID:         contentStreamID,
+	}
+	results := make([]fanOutResult, len(config.Servers))
+	start := time.Now()
+
+	if config.FanOutConcurrent {
+		var wg sync.WaitGroup
+		for i, server := range config.Servers {
+			wg.Add(1)
+			go func(i int, server string) {
+				defer wg.Done()
+				results[i] = uploadTo(server, config.Input, fileSize, options)
+			}(i, server)
+		}
+		wg.Wait()
+	} else {
+		for i, server := range config.Servers {
+			results[i] = uploadTo(server, config.Input, fileSize, options)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// Per-server and aggregate report
+	logger.Phase("Fan-out Performance Report")
+	succeeded := 0
+	for _, result := range results {
+		if result.err != nil {
+			logger.Error(fmt.Sprintf("%s: FAILED: %v", result.server, result.err))
+			continue
+		}
+		succeeded++
+		logger.Info(fmt.Sprintf("%s: stream %s, %d ms, %s",
+			result.server, result.streamID, result.report.UploadDurationMs, rep.formatThroughput(result.report.UploadThroughputMbps)))
+	}
+
+	totalBytes := fileSize * int64(succeeded)
+	logger.Info(fmt.Sprintf("Servers Succeeded: %d/%d", succeeded, len(results)))
+	logger.Info(fmt.Sprintf("Total Duration: %d ms", elapsed.Milliseconds()))
+	if elapsed > 0 {
+		logger.Info(fmt.Sprintf("Aggregate Throughput: %s", rep.formatThroughput(float64(totalBytes*8)/elapsed.Seconds()/1_000_000)))
+	}
+
+	if succeeded < len(results) {
+		exit(ExitFailure)
+	}
+	logger.Phase("Workflow Complete")
+}
+
+// uploadTo connects to one server and uploads the file
+func uploadTo(server string, input string, fileSize int64, options core.UploadOptions) fanOutResult {
+	result := fanOutResult{server: server}
+
+	ws, err := core.Connect(server, options.CompressionLevel > 0)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer ws.Close()
+
+	if options.ChunkChecksum || options.SequenceFrames || options.TranscodeTo != "" {
+		capabilities, err := core.QueryCapabilities(ws)
+		if err == nil && options.ChunkChecksum && !capabilities.SupportsChecksum(core.ChunkChecksumCRC32) {
+			err = fmt.Errorf("server does not support %s chunk checksums", core.ChunkChecksumCRC32)
+		}
+		if err == nil && options.SequenceFrames && (capabilities == nil || !capabilities.SequencedFrames) {
+			err = fmt.Errorf("server does not support sequenced frames")
+		}
+		if err == nil && options.TranscodeTo != "" && (capabilities == nil || capabilities.Transcoder == "") {
+			err = fmt.Errorf("server does not support transcoding")
+		}
+		if err != nil {
+			result.err = err
+			return result
+		}
+	}
+
+	perf := util.NewPerformanceMonitor(fileSize)
+	perf.StartUpload()
+	result.streamID, result.err = core.Upload(ws, input, fileSize, options)
+	perf.EndUpload()
+	if result.err == nil {
+		result.report = perf.GetReport()
+		logger.Info(fmt.Sprintf("Uploaded to %s as stream %s", server, result.streamID))
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/cli"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/core"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// followSession holds the state of an interactive --follow session
+type followSession struct {
+	ws        *core.WebSocketClient
+	config    *cli.Config
+	streamID  string
+	fileSize  int64
+	options   core.DownloadOptions
+	downloads int
+	lastPath  string
+	rep       reporter
+}
+
+// runFollow keeps the connection open after the upload and serves
+// commands read from stdin until "quit" or end of input
+func runFollow(ws *core.WebSocketClient, config *cli.Config, streamID string, fileSize int64, options core.DownloadOptions) {
+	session := &followSession{
+		ws:       ws,
+		config:   config,
+		streamID: streamID,
+		fileSize: fileSize,
+		options:  options,
+		rep:      reporter{units: config.Units},
+	}
+
+	logger.Phase("Follow Mode")
+	logger.Info("Commands: download [path], status, quit")
+
+	if err := session.serve(os.Stdin); err != nil {
+		logger.Error(fmt.Sprintf("Follow mode stopped: %v", err))
+		os.Exit(ExitFailure)
+	}
+	logger.Info("Disconnected from server")
+}
+
+// serve reads one command per line and executes it
+func (s *followSession) serve(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "download":
+			path := s.config.Output
+			if len(fields) > 1 {
+				path = fields[1]
+			}
+			s.download(path)
+		case "status":
+			s.status()
+		case "quit", "exit":
+			return nil
+		default:
+			logger.Warn(fmt.Sprintf("Unknown command: %s (expected download [path], status, quit)", fields[0]))
+		}
+	}
+}
+
+// download fetches the uploaded stream to path and verifies it against the input
+func (s *followSession) download(path string) {
+	logger.Info(fmt.Sprintf("Downloading stream %s to %s", s.streamID, path))
+	start := time.Now()
+	if err := core.Download(s.ws, s.streamID, path, s.fileSize, s.options); err != nil {
+		logger.Error(fmt.Sprintf("Download failed: %v", err))
+		return
+	}
+	elapsed := time.Since(start)
+	s.downloads++
+	s.lastPath = path
+
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(s.fileSize*8) / elapsed.Seconds() / 1_000_000
+	}
+	logger.Info(fmt.Sprintf("Downloaded %s in %d ms (%s)", humanizeBytes(s.fileSize), elapsed.Milliseconds(), s.rep.formatThroughput(throughput)))
+
+	result, err := util.Verify(s.config.Input, path)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Verification error: %v", err))
+		return
+	}
+	if result.Passed {
+		logger.Info("✓ File verification PASSED - Files are identical")
+	} else {
+		logger.Error("✗ File verification FAILED")
+	}
+}
+
+// status prints the session state together with the stream's status as
+// the server reports it
+func (s *followSession) status() {
+	logger.Info(fmt.Sprintf("Server: %s", s.config.Server))
+	logger.Info(fmt.Sprintf("Stream ID: %s", s.streamID))
+	logger.Info(fmt.Sprintf("Uploaded: %d bytes (%s)", s.fileSize, humanizeBytes(s.fileSize)))
+	if status, err := core.QueryStreamStatus(s.ws, s.streamID); err != nil {
+		logger.Error(fmt.Sprintf("Status query failed: %v", err))
+	} else {
+		logger.Info(fmt.Sprintf("Server status: %s", status.Status))
+		logger.Info(fmt.Sprintf("Server size: %d bytes (%s)", status.Size, humanizeBytes(status.Size)))
+		if status.Digest != "" {
+			logger.Info(fmt.Sprintf("Server digest: %s", status.Digest))
+		}
+	}
+	logger.Info(fmt.Sprintf("Downloads: %d", s.downloads))
+	if s.lastPath != "" {
+		logger.Info(fmt.Sprintf("Last output: %s", s.lastPath))
+	}
+}
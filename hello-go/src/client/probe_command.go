@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/cli"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/core"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
+)
+
+// countingWriter discards what is written to it and counts the bytes
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// runProbe implements the probe subcommand: upload generated data and
+// optionally download it into a discarding sink, so the report reflects the
+// network and server rather than the client's disk
+func runProbe(config *cli.Config) {
+	rep := reporter{units: config.Units}
+
+	logger.Info("Audio Stream Cache Client - Go Implementation")
+	logger.Info(fmt.Sprintf("Server URI: %s", config.Server))
+	logger.Info(fmt.Sprintf("Probe size: %d bytes (%s)", config.ProbeSize, humanizeBytes(config.ProbeSize)))
+
+	perf := util.NewPerformanceMonitor(config.ProbeSize)
+
+	logger.Phase("Connecting to Server")
+	ws, err := core.Connect(config.Server, config.CompressionLevel > 0)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to connect to server: %v", err))
+		rep.failRun("connect", err, perf)
+	}
+	defer ws.Close()
+	if err := ws.SetNoDelay(config.NoDelay); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to set TCP_NODELAY=%v: %v", config.NoDelay, err))
+	}
+
+	capabilities, err := core.QueryCapabilities(ws)
+	if err != nil {
+		rep.failRun("connect", err, perf)
+	}
+	if config.ChunkChecksum && !capabilities.SupportsChecksum(core.ChunkChecksumCRC32) {
+		rep.failRun("connect", fmt.Errorf("server does not support %s chunk checksums", core.ChunkChecksumCRC32), perf)
+	}
+	if config.SequenceFrames && (capabilities == nil || !capabilities.SequencedFrames) {
+		rep.failRun("connect", fmt.Errorf("server does not support sequenced frames"), perf)
+	}
+
+	logger.Phase("Starting Upload")
+	perf.StartUpload()
+	uploadCtx, uploadSpan := telemetry.Start(context.Background(), "upload")
+	streamID, err := core.UploadReader(ws, core.NewSyntheticSource(), config.ProbeSize, core.UploadOptions{
+		Perf:             perf,
+		ChunkSize:        config.UploadChunkSize,
+		ChunkChecksum:    config.ChunkChecksum,
+		SequenceFrames:   config.SequenceFrames,
+		ReadBlockSize:    config.ReadBlockSize,
+		CompressionLevel: config.CompressionLevel,
+		TraceContext:     uploadCtx,
+	})
+	uploadSpan.SetStreamID(streamID)
+	uploadSpan.SetBytes(config.ProbeSize)
+	uploadSpan.End(err)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Upload failed: %v", err))
+		rep.failRun("upload", err, perf)
+	}
+	perf.EndUpload()
+	logger.Info(fmt.Sprintf("Probe stream uploaded with stream ID: %s", streamID))
+
+	options := newDownloadOptions(config, nil, capabilities)
+	if config.ProbeDownload {
+		logger.Phase("Starting Download")
+		sink := &countingWriter{}
+		options.Sink = sink
+		options.Perf = perf
+
+		perf.StartDownload()
+		var downloadSpan *telemetry.Span
+		options.TraceContext, downloadSpan = telemetry.Start(context.Background(), "download")
+		downloadSpan.SetStreamID(streamID)
+		err := core.Download(ws, streamID, "", config.ProbeSize, options)
+		downloadSpan.SetBytes(sink.n)
+		downloadSpan.End(err)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Download failed: %v", err))
+			rep.failRun("download", err, perf)
+		}
+		perf.EndDownload()
+		if sink.n != config.ProbeSize {
+			rep.failRun("download", fmt.Errorf("downloaded %d bytes, expected %d", sink.n, config.ProbeSize), perf)
+		}
+		logger.Info(fmt.Sprintf("Downloaded and discarded %s", humanizeBytes(sink.n)))
+	}
+
+	rep.logPerformanceReport(perf)
+	logLatencyStats(options.Latency)
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// Throughput units accepted by --units
+const (
+	UnitsMbps = "mbps" // Megabits per second (default)
+	UnitsMBs  = "mbs"  // Megabytes per second
+	UnitsGbps = "gbps" // Gigabits per second
+	UnitsAuto = "auto" // Kbps, Mbps or Gbps depending on magnitude
+)
+
+// reporter prints transfer figures with throughput in the units chosen with --units
+type reporter struct {
+	units string // One of the Units constants; anything else prints Mbps
+}
+
+// formatThroughput renders a throughput given in Mbps in the reporter's units
+func (r reporter) formatThroughput(mbps float64) string {
+	switch r.units {
+	case UnitsMBs:
+		return fmt.Sprintf("%.2f MB/s", mbps/8)
+	case UnitsGbps:
+		return fmt.Sprintf("%.3f Gbps", mbps/1000)
+	case UnitsAuto:
+		switch {
+		case mbps >= 1000:
+			return fmt.Sprintf("%.2f Gbps", mbps/1000)
+		case mbps < 1:
+			return fmt.Sprintf("%.2f Kbps", mbps*1000)
+		}
+	}
+	return fmt.Sprintf("%.2f Mbps", mbps)
+}
+
+// humanizeBytes renders a byte count with binary units, e.g. "1.50 MiB"
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// logPerformanceReport prints the durations and throughput of the measured
+// phases; download figures only when a download was timed
+func (r reporter) logPerformanceReport(perf *util.PerformanceMonitor) {
+	logger.Phase("Performance Report")
+	report := perf.GetReport()
+	logger.Info(fmt.Sprintf("Upload Duration: %d ms", report.UploadDurationMs))
+	logger.Info(fmt.Sprintf("Upload Throughput: %s", r.formatThroughput(report.UploadThroughputMbps)))
+	if !perf.DownloadCompleted() {
+		return
+	}
+	logger.Info(fmt.Sprintf("Download Duration: %d ms", report.DownloadDurationMs))
+	logger.Info(fmt.Sprintf("Download Throughput: %s", r.formatThroughput(report.DownloadThroughputMbps)))
+	logger.Info(fmt.Sprintf("Total Duration: %d ms", report.TotalDurationMs))
+	logger.Info(fmt.Sprintf("Average Throughput: %s", r.formatThroughput(report.AverageThroughputMbps)))
+
+	// Check performance targets
+	if report.UploadThroughputMbps < 100.0 || report.DownloadThroughputMbps < 200.0 {
+		logger.Warn("⚠ Performance targets not met (Upload >100 Mbps, Download >200 Mbps)")
+	}
+}
+
+// logSnapshot prints the numbers of every phase that has started so far,
+// whether or not it has finished
+func (r reporter) logSnapshot(perf *util.PerformanceMonitor) {
+	report := perf.Snapshot()
+	if perf.UploadStarted() {
+		logger.Info(fmt.Sprintf("Upload Duration: %d ms", report.UploadDurationMs))
+		logger.Info(fmt.Sprintf("Upload Throughput: %s", r.formatThroughput(report.UploadThroughputMbps)))
+	}
+	if perf.DownloadStarted() {
+		logger.Info(fmt.Sprintf("Download Duration: %d ms", report.DownloadDurationMs))
+		logger.Info(fmt.Sprintf("Download Throughput: %s", r.formatThroughput(report.DownloadThroughputMbps)))
+	}
+}
+
+// logLatencyStats prints the distribution of GET round-trip times: a high
+// median points at per-request latency, a low one with slow throughput at
+// bandwidth. Nothing is printed without a recorder (--latency-stats unset).
+func logLatencyStats(latency *util.LatencyRecorder) {
+	if latency == nil {
+		return
+	}
+	summary := latency.Summary()
+	if summary.Count == 0 {
+		return
+	}
+	logger.Info(fmt.Sprintf("GET Round-Trip (%d requests): min %v, median %v, p95 %v, max %v",
+		summary.Count, summary.Min, summary.Median, summary.P95, summary.Max))
+}
@@ -0,0 +1,53 @@
+package client
+
+import "testing"
+
+func TestFormatThroughput(t *testing.T) {
+	tests := []struct {
+		units string
+		mbps  float64
+		want  string
+	}{
+		{UnitsMbps, 0, "0.00 Mbps"},
+		{UnitsMbps, 123.456, "123.46 Mbps"},
+		{"", 5, "5.00 Mbps"}, // Unset units print Mbps
+		{UnitsMBs, 80, "10.00 MB/s"},
+		{UnitsGbps, 1500, "1.500 Gbps"},
+		{UnitsAuto, 0, "0.00 Kbps"},
+		{UnitsAuto, 0.5, "500.00 Kbps"},
+		{UnitsAuto, 0.999, "999.00 Kbps"},
+		{UnitsAuto, 1, "1.00 Mbps"},
+		{UnitsAuto, 999.99, "999.99 Mbps"},
+		{UnitsAuto, 1000, "1.00 Gbps"},
+		{UnitsAuto, 2500, "2.50 Gbps"},
+	}
+	for _, tt := range tests {
+		if got := (reporter{units: tt.units}).formatThroughput(tt.mbps); got != tt.want {
+			t.Errorf("formatThroughput(%v) in %q = %q, want %q", tt.mbps, tt.units, got, tt.want)
+		}
+	}
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{1024, "1.00 KiB"},
+		{1536, "1.50 KiB"},
+		{1<<20 - 1, "1024.00 KiB"},
+		{1 << 20, "1.00 MiB"},
+		{3 << 29, "1.50 GiB"},
+		{1 << 40, "1.00 TiB"},
+		{1 << 50, "1.00 PiB"},
+		{1 << 60, "1024.00 PiB"}, // PiB is the largest unit
+	}
+	for _, tt := range tests {
+		if got := humanizeBytes(tt.n); got != tt.want {
+			t.Errorf("humanizeBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package client
+
+import "github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+
+// dumpSnapshotsOnSignal does nothing where SIGUSR1 does not exist
+func dumpSnapshotsOnSignal(rep reporter, perf *util.PerformanceMonitor) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/util"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// dumpSnapshotsOnSignal logs perf's numbers so far each time the process
+// receives SIGUSR1, until the returned stop function is called
+func dumpSnapshotsOnSignal(rep reporter, perf *util.PerformanceMonitor) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				logger.Phase("Performance Snapshot")
+				rep.logSnapshot(perf)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/cli"
+	"github.com/feuyeux/hello-mmap/hello-go/src/client/core"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// tailPollInterval is how often a tailed file is checked for new bytes
+const tailPollInterval = 100 * time.Millisecond
+
+// runTail implements --tail: upload --input while another process is still
+// writing it, as one live stream of unknown length. Bytes are sent as they
+// appear; the stream is finalized once the file has not grown for
+// --tail-idle, or on SIGINT/SIGTERM after sending what was read so far.
+func runTail(config *cli.Config) {
+	rep := reporter{units: config.Units}
+
+	logger.Info("Audio Stream Cache Client - Go Implementation")
+	logger.Info(fmt.Sprintf("Server URI: %s", config.Server))
+	logger.Info(fmt.Sprintf("Tailing input file: %s", config.Input))
+
+	file, err := os.Open(config.Input)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to open input file: %v", err))
+		os.Exit(ExitFailure)
+	}
+	defer file.Close()
+
+	logger.Phase("Connecting to Server")
+	ws, err := core.Connect(config.Server, false)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to connect to server: %v", err))
+		rep.failRun("connect", err, nil)
+	}
+	defer ws.Close()
+	if err := ws.SetNoDelay(config.NoDelay); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to set TCP_NODELAY=%v: %v", config.NoDelay, err))
+	}
+
+	logger.Phase("Starting Live Upload")
+	uploader := core.NewRingBufferUploader(ws, config.ReadBlockSize, config.UploadChunkSize)
+	streamID, err := uploader.Start()
+	if err != nil {
+		rep.failRun("upload", err, nil)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	start := time.Now()
+	read, err := tailFile(file, uploader, config.ReadBlockSize, config.TailIdle, stop)
+	if err != nil {
+		// Finalizing would publish the partial capture as a complete stream
+		uploader.Abort()
+		rep.failRun("upload", err, nil)
+	}
+	if err := uploader.Close(); err != nil {
+		rep.failRun("upload", err, nil)
+	}
+	elapsed := time.Since(start)
+
+	logger.Info(fmt.Sprintf("Uploaded %s from %s in %d ms", humanizeBytes(read), config.Input, elapsed.Milliseconds()))
+	logger.Info(fmt.Sprintf("Live upload completed with stream ID: %s", streamID))
+}
+
+// tailFile copies file to w as it grows and returns the bytes copied. It
+// returns once the file has not grown for idle (never when idle is zero) or
+// a signal arrives on stop. A file that shrinks below what was already read
+// was truncated or replaced, which cannot be appended to the stream.
+func tailFile(file *os.File, w io.Writer, blockSize int, idle time.Duration, stop <-chan os.Signal) (int64, error) {
+	block := make([]byte, max(blockSize, 1))
+	var read int64
+	lastGrowth := time.Now()
+	for {
+		n, err := file.Read(block)
+		if n > 0 {
+			if _, err := w.Write(block[:n]); err != nil {
+				return read, err
+			}
+			read += int64(n)
+			lastGrowth = time.Now()
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return read, fmt.Errorf("failed to read input: %w", err)
+		}
+
+		// At the current end of the file: wait for more
+		if info, err := file.Stat(); err == nil && info.Size() < read {
+			return read, fmt.Errorf("input shrank to %d bytes after %d were uploaded (truncated or replaced)", info.Size(), read)
+		}
+		if idle > 0 && time.Since(lastGrowth) >= idle {
+			logger.Info(fmt.Sprintf("Input has not grown for %v, ending stream", idle))
+			return read, nil
+		}
+		select {
+		case sig := <-stop:
+			logger.Info(fmt.Sprintf("Received %v, ending stream", sig))
+			return read, nil
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
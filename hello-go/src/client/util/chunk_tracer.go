@@ -0,0 +1,63 @@
+package util
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ChunkTracer writes one CSV row per transferred chunk for offline analysis.
+// All methods are no-ops on a nil *ChunkTracer, so tracing costs nothing when disabled.
+type ChunkTracer struct {
+	file   *os.File
+	writer *csv.Writer
+	mutex  sync.Mutex
+}
+
+// NewChunkTracer creates a tracer writing to path
+func NewChunkTracer(path string) (*ChunkTracer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace file: %w", err)
+	}
+
+	t := &ChunkTracer{file: file, writer: csv.NewWriter(file)}
+	t.writer.Write([]string{"timestamp_ns", "direction", "offset", "bytes", "rtt_us"})
+	return t, nil
+}
+
+// Record appends a trace row. rtt is zero for uploads, which have no per-chunk response.
+func (t *ChunkTracer) Record(direction string, offset int64, bytes int, rtt time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.writer.Write([]string{
+		strconv.FormatInt(time.Now().UnixNano(), 10),
+		direction,
+		strconv.FormatInt(offset, 10),
+		strconv.Itoa(bytes),
+		strconv.FormatInt(rtt.Microseconds(), 10),
+	})
+}
+
+// Close flushes and closes the trace file
+func (t *ChunkTracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.writer.Flush()
+	if err := t.writer.Error(); err != nil {
+		t.file.Close()
+		return fmt.Errorf("failed to write trace file: %w", err)
+	}
+	return t.file.Close()
+}
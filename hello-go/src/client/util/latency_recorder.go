@@ -0,0 +1,68 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyRecorder collects request round-trip times for a summary.
+// All methods are no-ops on a nil *LatencyRecorder, so recording costs nothing when disabled.
+type LatencyRecorder struct {
+	samples []time.Duration
+	mutex   sync.Mutex
+}
+
+// LatencySummary describes the distribution of recorded round-trip times
+type LatencySummary struct {
+	Count  int
+	Min    time.Duration
+	Median time.Duration
+	P95    time.Duration
+	Max    time.Duration
+}
+
+// NewLatencyRecorder creates an empty recorder
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{}
+}
+
+// Record adds one round-trip time
+func (r *LatencyRecorder) Record(rtt time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.samples = append(r.samples, rtt)
+}
+
+// Summary returns min, median, 95th percentile and max of the recorded
+// times; the zero summary when nothing was recorded
+func (r *LatencyRecorder) Summary() LatencySummary {
+	if r == nil {
+		return LatencySummary{}
+	}
+
+	r.mutex.Lock()
+	sorted := append([]time.Duration(nil), r.samples...)
+	r.mutex.Unlock()
+	if len(sorted) == 0 {
+		return LatencySummary{}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// Nearest-rank percentile: the smallest sample at or above p of all samples
+	percentile := func(p int) time.Duration {
+		rank := (len(sorted)*p + 99) / 100
+		return sorted[max(rank, 1)-1]
+	}
+	return LatencySummary{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Median: percentile(50),
+		P95:    percentile(95),
+		Max:    sorted[len(sorted)-1],
+	}
+}
@@ -1,15 +1,21 @@
 package util
 
 import (
+	"sync"
 	"time"
 )
 
 type PerformanceMonitor struct {
+	mutex         sync.Mutex // Lets Snapshot run concurrently with the phases it measures
 	fileSize      int64
 	uploadStart   time.Time
 	uploadEnd     time.Time
 	downloadStart time.Time
 	downloadEnd   time.Time
+
+	// Bytes transferred so far, so a running phase reports what it has moved
+	uploadBytes   int64
+	downloadBytes int64
 }
 
 type PerformanceReport struct {
@@ -28,37 +34,139 @@ func NewPerformanceMonitor(fileSize int64) *PerformanceMonitor {
 }
 
 func (m *PerformanceMonitor) StartUpload() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.uploadStart = time.Now()
 }
 
 func (m *PerformanceMonitor) EndUpload() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.uploadEnd = time.Now()
 }
 
 func (m *PerformanceMonitor) StartDownload() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.downloadStart = time.Now()
 }
 
 func (m *PerformanceMonitor) EndDownload() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.downloadEnd = time.Now()
 }
 
+// AddUploadBytes credits n transferred bytes to the upload phase.
+// It is a no-op on a nil *PerformanceMonitor.
+func (m *PerformanceMonitor) AddUploadBytes(n int) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploadBytes += int64(n)
+}
+
+// AddDownloadBytes credits n transferred bytes to the download phase.
+// It is a no-op on a nil *PerformanceMonitor.
+func (m *PerformanceMonitor) AddDownloadBytes(n int) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.downloadBytes += int64(n)
+}
+
+// UploadStarted reports whether the upload phase has started
+func (m *PerformanceMonitor) UploadStarted() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.uploadStart.IsZero()
+}
+
+// DownloadStarted reports whether the download phase has started
+func (m *PerformanceMonitor) DownloadStarted() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.downloadStart.IsZero()
+}
+
+// UploadCompleted reports whether both upload timestamps were recorded
+func (m *PerformanceMonitor) UploadCompleted() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.uploadStart.IsZero() && !m.uploadEnd.IsZero()
+}
+
+// DownloadCompleted reports whether both download timestamps were recorded
+func (m *PerformanceMonitor) DownloadCompleted() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.downloadStart.IsZero() && !m.downloadEnd.IsZero()
+}
+
+// GetReport returns the performance report; see Snapshot for how phases
+// that have not started or finished yet are reported
 func (m *PerformanceMonitor) GetReport() *PerformanceReport {
-	uploadDurationMs := m.uploadEnd.Sub(m.uploadStart).Milliseconds()
-	downloadDurationMs := m.downloadEnd.Sub(m.downloadStart).Milliseconds()
+	return m.Snapshot()
+}
+
+// Snapshot returns a consistent report that may be taken at any time.
+// A phase that has not started reports zero; one still running is measured
+// up to now and credited with the bytes added so far; a finished one with
+// the whole file.
+func (m *PerformanceMonitor) Snapshot() *PerformanceReport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	uploadDurationMs := phaseDurationMs(m.uploadStart, m.uploadEnd, now)
+	downloadDurationMs := phaseDurationMs(m.downloadStart, m.downloadEnd, now)
 	totalDurationMs := uploadDurationMs + downloadDurationMs
 
-	// Throughput (Mbps) = (file_size_bytes * 8) / (duration_ms * 1_000_000)
-	uploadThroughputMbps := float64(m.fileSize*8) / float64(uploadDurationMs*1_000_000)
-	downloadThroughputMbps := float64(m.fileSize*8) / float64(downloadDurationMs*1_000_000)
-	averageThroughputMbps := float64(m.fileSize*2*8) / float64(totalDurationMs*1_000_000)
+	uploadBytes := m.phaseBytes(m.uploadStart, m.uploadEnd, m.uploadBytes)
+	downloadBytes := m.phaseBytes(m.downloadStart, m.downloadEnd, m.downloadBytes)
 
+	// Throughput (Mbps) = (bytes * 8) / (duration_ms * 1_000_000)
 	return &PerformanceReport{
 		UploadDurationMs:       uploadDurationMs,
-		UploadThroughputMbps:   uploadThroughputMbps,
+		UploadThroughputMbps:   throughputMbps(uploadBytes, uploadDurationMs),
 		DownloadDurationMs:     downloadDurationMs,
-		DownloadThroughputMbps: downloadThroughputMbps,
+		DownloadThroughputMbps: throughputMbps(downloadBytes, downloadDurationMs),
 		TotalDurationMs:        totalDurationMs,
-		AverageThroughputMbps:  averageThroughputMbps,
+		AverageThroughputMbps:  throughputMbps(uploadBytes+downloadBytes, totalDurationMs),
+	}
+}
+
+// phaseBytes returns the bytes a phase has moved: none before it starts,
+// those added so far while running and the whole file once finished
+func (m *PerformanceMonitor) phaseBytes(start, end time.Time, added int64) int64 {
+	if start.IsZero() {
+		return 0
+	}
+	if end.IsZero() || end.Before(start) {
+		return added
+	}
+	return m.fileSize
+}
+
+// phaseDurationMs measures a phase: zero before it starts, up to now while running
+func phaseDurationMs(start, end, now time.Time) int64 {
+	if start.IsZero() {
+		return 0
+	}
+	if end.IsZero() || end.Before(start) {
+		end = now
+	}
+	return end.Sub(start).Milliseconds()
+}
+
+// throughputMbps returns zero rather than Inf/NaN for an empty duration
+func throughputMbps(bytes int64, durationMs int64) float64 {
+	if durationMs <= 0 {
+		return 0
 	}
+	return float64(bytes*8) / float64(durationMs*1_000_000)
 }
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotBeforeAnyPhase(t *testing.T) {
+	m := NewPerformanceMonitor(1 << 20)
+	m.AddUploadBytes(100) // Not credited until the phase starts
+
+	if report := m.Snapshot(); *report != (PerformanceReport{}) {
+		t.Fatalf("snapshot before any phase = %+v, want all zero", *report)
+	}
+}
+
+func TestSnapshotRunningPhaseUsesBytesSoFar(t *testing.T) {
+	m := NewPerformanceMonitor(1 << 30)
+	m.StartUpload()
+	m.uploadStart = m.uploadStart.Add(-time.Second)
+	m.AddUploadBytes(4096)
+	m.AddUploadBytes(4096)
+
+	report := m.Snapshot()
+	if report.UploadDurationMs < 1000 {
+		t.Fatalf("running upload measured %d ms, want at least 1000", report.UploadDurationMs)
+	}
+	if want := throughputMbps(8192, report.UploadDurationMs); report.UploadThroughputMbps != want {
+		t.Errorf("running upload throughput = %v, want %v from the 8192 bytes sent", report.UploadThroughputMbps, want)
+	}
+	if report.DownloadDurationMs != 0 || report.DownloadThroughputMbps != 0 {
+		t.Errorf("download not started but reported %d ms, %v", report.DownloadDurationMs, report.DownloadThroughputMbps)
+	}
+	if report.AverageThroughputMbps != report.UploadThroughputMbps {
+		t.Errorf("average = %v, want the upload's %v while only it has started", report.AverageThroughputMbps, report.UploadThroughputMbps)
+	}
+}
+
+func TestSnapshotFinishedPhasesUseFileSize(t *testing.T) {
+	const fileSize = 1 << 20
+	m := NewPerformanceMonitor(fileSize)
+	start := time.Now()
+	m.uploadStart, m.uploadEnd = start, start.Add(500*time.Millisecond)
+	m.downloadStart, m.downloadEnd = start.Add(time.Second), start.Add(1250*time.Millisecond)
+	m.AddDownloadBytes(10) // A resumed download moves less than the file
+
+	report := m.Snapshot()
+	want := PerformanceReport{
+		UploadDurationMs:       500,
+		UploadThroughputMbps:   throughputMbps(fileSize, 500),
+		DownloadDurationMs:     250,
+		DownloadThroughputMbps: throughputMbps(fileSize, 250),
+		TotalDurationMs:        750,
+		AverageThroughputMbps:  throughputMbps(2*fileSize, 750),
+	}
+	if *report != want {
+		t.Fatalf("snapshot = %+v, want %+v", *report, want)
+	}
+}
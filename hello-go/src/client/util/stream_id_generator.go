@@ -7,11 +7,31 @@ import (
 	"time"
 )
 
+// IDGenerator produces stream IDs for GenerateStreamID. It is a test seam:
+// tests may replace it to get predictable IDs, production code never does.
+// Callers that need a particular ID pass it explicitly instead.
+var IDGenerator func() string = RandomStreamID
+
 // GenerateStreamID generates a unique stream identifier
 func GenerateStreamID() string {
+	return IDGenerator()
+}
+
+// RandomStreamID generates a stream identifier from the current time and random bytes
+func RandomStreamID() string {
 	timestamp := time.Now().Format("20060102-150405")
 	randomBytes := make([]byte, 4)
 	rand.Read(randomBytes)
 	randomHex := hex.EncodeToString(randomBytes)
 	return fmt.Sprintf("stream-%s-%s", timestamp, randomHex)
 }
+
+// ContentIDPrefixLength is the number of SHA-256 hex digits in a content ID
+const ContentIDPrefixLength = 16
+
+// ContentStreamIDFor derives a stream ID from the SHA-256 hex digest of a
+// file's content, stream-<first 16 hex digits>, so the same file always maps
+// to the same ID
+func ContentStreamIDFor(checksum string) string {
+	return "stream-" + checksum[:ContentIDPrefixLength]
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+// useSequentialIDs makes GenerateStreamID yield prefix-1, prefix-2, ...
+// until the test ends
+func useSequentialIDs(t *testing.T, prefix string) {
+	t.Helper()
+	previous := IDGenerator
+	next := 0
+	IDGenerator = func() string {
+		next++
+		return fmt.Sprintf("%s-%d", prefix, next)
+	}
+	t.Cleanup(func() { IDGenerator = previous })
+}
+
+func TestGenerateStreamIDIsDeterministicWhenOverridden(t *testing.T) {
+	useSequentialIDs(t, "test")
+	for _, want := range []string{"test-1", "test-2", "test-3"} {
+		if got := GenerateStreamID(); got != want {
+			t.Fatalf("GenerateStreamID() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRandomStreamIDFormat(t *testing.T) {
+	format := regexp.MustCompile(`^stream-\d{8}-\d{6}-[0-9a-f]{8}$`)
+	first, second := RandomStreamID(), RandomStreamID()
+	if !format.MatchString(first) {
+		t.Fatalf("RandomStreamID() = %q, want stream-<date>-<time>-<8 hex digits>", first)
+	}
+	if first == second {
+		t.Fatalf("two random IDs are both %q", first)
+	}
+}
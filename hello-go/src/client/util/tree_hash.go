@@ -0,0 +1,85 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Digest algorithms a server may report for a finalized stream
+const (
+	DigestSHA256     = "sha256"
+	DigestTreeSHA256 = "tree-sha256"
+)
+
+// TreeHashSegmentSize is the segment length of DigestTreeSHA256
+const TreeHashSegmentSize = 8 * 1024 * 1024
+
+// ComputeDigest computes a file digest with the named algorithm
+func ComputeDigest(path string, algorithm string) (string, error) {
+	switch algorithm {
+	case DigestSHA256:
+		return ComputeSHA256(path)
+	case DigestTreeSHA256:
+		return ComputeTreeSHA256(path)
+	default:
+		return "", fmt.Errorf("unknown digest algorithm %q", algorithm)
+	}
+}
+
+// ComputeTreeSHA256 computes the server's tree-sha256 digest of a file: the
+// SHA-256 of the concatenated SHA-256 digests of its 8 MiB segments, with the
+// segments hashed concurrently
+func ComputeTreeSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+	size := info.Size()
+
+	segments := int((size + TreeHashSegmentSize - 1) / TreeHashSegmentSize)
+	sums := make([][]byte, segments)
+	errs := make([]error, segments)
+
+	workers := min(runtime.GOMAXPROCS(0), segments)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := int64(i) * TreeHashSegmentSize
+				hasher := sha256.New()
+				section := io.NewSectionReader(file, start, min(TreeHashSegmentSize, size-start))
+				if _, errs[i] = io.Copy(hasher, section); errs[i] == nil {
+					sums[i] = hasher.Sum(nil)
+				}
+			}
+		}()
+	}
+	for i := 0; i < segments; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	root := sha256.New()
+	for i, sum := range sums {
+		if errs[i] != nil {
+			return "", fmt.Errorf("failed to read file: %w", errs[i])
+		}
+		root.Write(sum)
+	}
+	return hex.EncodeToString(root.Sum(nil)), nil
+}
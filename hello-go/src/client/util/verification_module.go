@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
 )
@@ -33,15 +34,27 @@ func Verify(originalPath string, downloadedPath string) (*VerificationResult, er
 	logger.Info(fmt.Sprintf("Original size: %d bytes", originalSize))
 	logger.Info(fmt.Sprintf("Downloaded size: %d bytes", downloadedSize))
 
-	// Compute checksums
-	originalChecksum, err := ComputeSHA256(originalPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute original checksum: %w", err)
-	}
+	// Compute both checksums concurrently; each goroutine writes only its own result
+	var originalChecksum, downloadedChecksum string
+	var originalErr, downloadedErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		originalChecksum, originalErr = ComputeSHA256(originalPath)
+	}()
+	go func() {
+		defer wg.Done()
+		downloadedChecksum, downloadedErr = ComputeSHA256(downloadedPath)
+	}()
+	wg.Wait()
 
-	downloadedChecksum, err := ComputeSHA256(downloadedPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute downloaded checksum: %w", err)
+	// Report a single error, preferring the original file's as before
+	if originalErr != nil {
+		return nil, fmt.Errorf("failed to compute original checksum: %w", originalErr)
+	}
+	if downloadedErr != nil {
+		return nil, fmt.Errorf("failed to compute downloaded checksum: %w", downloadedErr)
 	}
 
 	logger.Info(fmt.Sprintf("Original checksum (SHA-256): %s", originalChecksum))
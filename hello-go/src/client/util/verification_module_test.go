@@ -0,0 +1,90 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// writeVerifyFiles writes an original of size bytes and a copy of it,
+// flipping the copy's last byte when differ is set
+func writeVerifyFiles(t testing.TB, size int, differ bool) (original string, downloaded string) {
+	t.Helper()
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("verify"), size/6+1)[:size]
+	original = filepath.Join(dir, "original.bin")
+	downloaded = filepath.Join(dir, "downloaded.bin")
+	if err := os.WriteFile(original, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if differ {
+		data[len(data)-1] ^= 0xff
+	}
+	if err := os.WriteFile(downloaded, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return original, downloaded
+}
+
+func TestVerify(t *testing.T) {
+	for _, differ := range []bool{false, true} {
+		original, downloaded := writeVerifyFiles(t, 1<<20, differ)
+		result, err := Verify(original, downloaded)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if result.Passed == differ {
+			t.Errorf("files differing=%v: Passed=%v", differ, result.Passed)
+		}
+		if result.OriginalSize != 1<<20 || result.DownloadedSize != 1<<20 {
+			t.Errorf("sizes %d and %d, want %d", result.OriginalSize, result.DownloadedSize, 1<<20)
+		}
+		if (result.OriginalChecksum == result.DownloadedChecksum) == differ {
+			t.Errorf("files differing=%v: checksums %s and %s", differ, result.OriginalChecksum, result.DownloadedChecksum)
+		}
+	}
+}
+
+func TestVerifyReportsOneError(t *testing.T) {
+	// Directories have a size but cannot be read, so both hashes fail
+	original, downloaded := t.TempDir(), t.TempDir()
+
+	_, err := Verify(original, downloaded)
+	if err == nil || !strings.Contains(err.Error(), "original checksum") || strings.Contains(err.Error(), "downloaded") {
+		t.Errorf("Verify error = %v, want only the original file's", err)
+	}
+}
+
+// BenchmarkVerify compares hashing the original and downloaded files one
+// after the other with Verify, which hashes them concurrently
+func BenchmarkVerify(b *testing.B) {
+	const size = 64 * 1024 * 1024
+	original, downloaded := writeVerifyFiles(b, size, false)
+	logger.SetOutput(io.Discard)
+	b.Cleanup(func() { logger.SetOutput(os.Stdout) })
+
+	b.Run("sequential", func(b *testing.B) {
+		b.SetBytes(2 * size)
+		for i := 0; i < b.N; i++ {
+			if _, err := ComputeSHA256(original); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := ComputeSHA256(downloaded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.SetBytes(2 * size)
+		for i := 0; i < b.N; i++ {
+			if _, err := Verify(original, downloaded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
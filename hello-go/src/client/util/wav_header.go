@@ -0,0 +1,31 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// ParseWavByteRate returns the byte rate (bytes per second of audio) from a
+// RIFF/WAVE header, or false if header does not start a WAV file
+func ParseWavByteRate(header []byte) (int, bool) {
+	if len(header) < 12 || !bytes.Equal(header[0:4], []byte("RIFF")) || !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return 0, false
+	}
+
+	// Walk the chunks after the RIFF header looking for "fmt "
+	pos := 12
+	for pos+8 <= len(header) {
+		chunkID := header[pos : pos+4]
+		chunkSize := int(binary.LittleEndian.Uint32(header[pos+4 : pos+8]))
+		if bytes.Equal(chunkID, []byte("fmt ")) {
+			// audioFormat(2) channels(2) sampleRate(4) byteRate(4)
+			if pos+16 > len(header) || chunkSize < 12 {
+				return 0, false
+			}
+			byteRate := int(binary.LittleEndian.Uint32(header[pos+16 : pos+20]))
+			return byteRate, byteRate > 0
+		}
+		pos += 8 + chunkSize + chunkSize%2
+	}
+	return 0, false
+}
@@ -2,38 +2,93 @@ package logger
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sync"
 	"time"
 )
 
 var verbose bool
 
+// output receives every log line; guarded by outputMutex, which also keeps
+// lines from concurrent goroutines whole
+var (
+	output      io.Writer = os.Stdout
+	outputMutex sync.Mutex
+)
+
+// color wraps level tags in ANSI colors; on by default only when stdout is a
+// terminal and NO_COLOR is unset
+var color = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+
+// ANSI escape sequences for the level tags
+const (
+	colorGray   = "\x1b[90m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
 func Init(v bool) {
 	verbose = v
 }
 
+// SetOutput sends log lines to w instead of stdout, e.g. to capture them in tests
+func SetOutput(w io.Writer) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	output = w
+}
+
+// DisableColor turns off colored level tags, e.g. for --no-color
+func DisableColor() {
+	color = false
+}
+
+// isTerminal reports whether f is a character device such as a TTY, as
+// opposed to a file or pipe the output is redirected to
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func formatTimestamp() string {
 	return time.Now().Format("2006-01-02 15:04:05.000")
 }
 
+// levelTag returns "[level]", colored when color is on
+func levelTag(level, ansi string) string {
+	if !color || ansi == "" {
+		return "[" + level + "]"
+	}
+	return ansi + "[" + level + "]" + colorReset
+}
+
+// printf writes one formatted log entry to the output
+func printf(format string, args ...any) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	fmt.Fprintf(output, format, args...)
+}
+
 func Debug(message string) {
 	if verbose {
-		fmt.Printf("[%s] [debug] %s\n", formatTimestamp(), message)
+		printf("[%s] %s %s\n", formatTimestamp(), levelTag("debug", colorGray), message)
 	}
 }
 
 func Info(message string) {
-	fmt.Printf("[%s] [info] %s\n", formatTimestamp(), message)
+	printf("[%s] %s %s\n", formatTimestamp(), levelTag("info", ""), message)
 }
 
 func Warn(message string) {
-	fmt.Printf("[%s] [warn] %s\n", formatTimestamp(), message)
+	printf("[%s] %s %s\n", formatTimestamp(), levelTag("warn", colorYellow), message)
 }
 
 func Error(message string) {
-	fmt.Printf("[%s] [error] %s\n", formatTimestamp(), message)
+	printf("[%s] %s %s\n", formatTimestamp(), levelTag("error", colorRed), message)
 }
 
 func Phase(phase string) {
-	fmt.Println()
-	fmt.Printf("[%s] [info] === %s ===\n", formatTimestamp(), phase)
+	printf("\n[%s] %s === %s ===\n", formatTimestamp(), levelTag("info", ""), phase)
 }
@@ -1,41 +1,220 @@
 package server
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/feuyeux/hello-mmap/hello-go/src/auth"
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/grpc"
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/handler"
 	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
 	"github.com/feuyeux/hello-mmap/hello-go/src/server/network"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
 )
 
 // Run starts the audio server application
 func Run() {
 	// Parse command-line arguments
 	port := flag.Int("port", 8080, "Server port")
+	bind := flag.String("bind", "", "Address to listen on, e.g. 127.0.0.1 (default all interfaces)")
 	path := flag.String("path", "/audio", "WebSocket path")
+	cacheDirs := flag.String("cache-dirs", "cache", "Comma-separated cache directories to stripe streams across")
+	fairQuantum := flag.Int("fair-quantum", 0, "Bytes written per download per round-robin turn (0 disables fair scheduling)")
+	fairWriteTimeout := flag.Duration("fair-write-timeout", handler.DefaultFairWriteTimeout, "With --fair-quantum, fail a download whose client does not take one quantum within this long")
+	strictFrames := flag.Bool("strict-frames", false, "Reject empty binary frames with an ERROR instead of ignoring them")
+	maxUploadDuration := flag.Duration("max-upload-duration", 0, "Maximum time a stream may stay uploading (0 disables)")
+	noDelay := flag.Bool("nodelay", true, "Set TCP_NODELAY on connections (false enables Nagle batching: more throughput, more latency)")
+	maxInflightGets := flag.Int("max-inflight-gets", 0, "Max GETs queued or in flight per connection, served off the read loop (0 serves inline)")
+	grpcPort := flag.Int("grpc-port", 0, "Also serve the gRPC transport on this port (0 disables)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "On shutdown, wait this long for active uploads to finish")
+	liveReads := flag.Bool("live-reads", true, "Serve GETs of streams still uploading (false answers NOT_READY until STOP)")
+	maxMessageSize := flag.Int64("max-message-size", 0, "Close connections that send a message larger than this many bytes (0 is unlimited)")
+	minChunkSize := flag.Int("min-chunk-size", 0, "Coalesce uploaded binary frames smaller than this many bytes into one write (0 disables)")
+	poolMaxOverflow := flag.Int("pool-max-overflow", -1, "Buffers that may be allocated beyond the pool before acquires wait for a release (-1 is unlimited)")
+	poolAcquireTimeout := flag.Duration("pool-acquire-timeout", 5*time.Second, "How long an acquire waits at the buffer cap before failing (0 waits indefinitely)")
+	poolIdleShrink := flag.Duration("pool-idle-shrink", 0, "Shrink the idle buffer pool when no buffer was used for this long (0 disables)")
+	wavRepair := flag.Bool("wav-repair", false, "On finalize, rewrite WAV RIFF/data chunk sizes to match the bytes received")
+	maxTotalMbps := flag.Float64("max-total-mbps", 0, "Cap total upload plus download bandwidth across all connections, in Mbps (0 disables)")
+	parallelHash := flag.Bool("parallel-hash", false, "Compute the finalize digest as a parallel tree hash (tree-sha256) instead of plain SHA-256")
+	compression := flag.Bool("compression", false, "Negotiate per-message deflate with clients; START may pick a level 1-9 per stream")
+	keepFailedCache := flag.Bool("keep-failed-cache", false, "Keep the cache file of a stream whose finalize failed (e.g. disk full) for inspection instead of removing it")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Close connections that send nothing for this long (0 disables the idle reaper)")
+	reapInterval := flag.Duration("reap-interval", 10*time.Second, "How often the idle reaper scans connections")
+	adminToken := flag.String("admin-token", "", "Bearer token for the /export and /import admin endpoints (empty disables them)")
+	authSecret := flag.String("auth-secret", "", "Shared secret for the HMAC connection handshake; when set, unsigned, forged, stale or replayed connections are refused")
+	authWindow := flag.Duration("auth-window", auth.DefaultWindow, "How far a handshake timestamp may be from the server's clock")
+	selfVerify := flag.Bool("self-verify", false, "Read every written chunk back and compare it, failing the stream on a mismatch (doubles I/O; for development)")
+	writeTimeout := flag.Duration("write-timeout", 0, "Fail the stream and close the connection when a single cache write takes longer than this (0 disables)")
+	verifyOnFinalize := flag.Bool("verify-on-finalize", false, "On finalize, re-read the whole cache file and compare its SHA-256 with the digest of the bytes written; a mismatch fails the stream")
+	compressAtRest := flag.Bool("compress-at-rest", false, "Store finalized cache files gzip-compressed in 256 KiB blocks, decompressing on read")
+	storage := flag.String("storage", "local", "Where finalized streams are kept: local, or s3://bucket/prefix (stub)")
+	accessLogPath := flag.String("access-log", "", "Append a JSON line per control message and transfer to this file")
+	allowedFormats := flag.String("allowed-formats", "", "Comma-separated audio formats accepted for upload (wav, mp3, ogg, flac), detected at the first write; empty allows all")
+	maxStreamsPerConn := flag.Int("max-streams-per-connection", 0, "Max streams one connection may have uploading at once; further STARTs get LIMIT_EXCEEDED (0 is unlimited)")
+	reorderWindow := flag.Int("reorder-window", 0, "Sequenced uploads: hold up to this many early frames until the missing one arrives (0 fails any out-of-order frame)")
+	dedupFrames := flag.Bool("dedup-frames", false, "Sequenced uploads: ignore a frame whose sequence number was already received (a client resend) instead of failing the stream")
+	transcoderSpec := flag.String("transcoder", "passthrough", "How START transcodeTo variants are made on finalize: passthrough (copies streams already in the target format) or ffmpeg")
+	noColor := flag.Bool("no-color", false, "Disable colored log levels (also off when output is redirected or NO_COLOR is set)")
+	otelEndpoint := flag.String("otel-endpoint", "", "OTLP/HTTP collector URL (e.g. http://localhost:4318) to export upload and GET spans to; empty disables tracing")
 	flag.Parse()
+	if *noColor {
+		logger.DisableColor()
+	}
 
 	logger.Info(fmt.Sprintf("Starting Audio Server on port %d with path %s", *port, *path))
+	if err := telemetry.Init(*otelEndpoint, "audio-stream-server"); err != nil {
+		logger.Error(fmt.Sprintf("Invalid --otel-endpoint: %v", err))
+		os.Exit(1)
+	}
 
 	// Get singleton instances
-	streamMgr := memory.GetStreamManager("cache")
+	streamMgr := memory.GetStreamManager(splitList(*cacheDirs)...)
+	streamMgr.SetMaxUploadDuration(*maxUploadDuration)
+	streamMgr.SetRepairWavHeaders(*wavRepair)
+	streamMgr.SetKeepFailedCache(*keepFailedCache)
+	streamMgr.SetSelfVerify(*selfVerify)
+	streamMgr.SetVerifyOnFinalize(*verifyOnFinalize)
+	streamMgr.SetCompressAtRest(*compressAtRest)
+	streamMgr.SetWriteTimeout(*writeTimeout)
+	if *parallelHash {
+		streamMgr.SetDigestAlgorithm(memory.DigestTreeSHA256)
+	}
+	transcoder, err := memory.NewTranscoder(*transcoderSpec)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Invalid --transcoder: %v", err))
+		os.Exit(1)
+	}
+	streamMgr.SetTranscoder(transcoder)
+	backend, err := memory.NewStorageBackend(*storage, streamMgr.LocalCache())
+	if err != nil {
+		logger.Error(fmt.Sprintf("Invalid --storage: %v", err))
+		os.Exit(1)
+	}
+	streamMgr.SetStorageBackend(backend)
 	memoryPool := memory.GetMemoryPoolManager(65536, 100)
+	memoryPool.SetMaxOverflow(*poolMaxOverflow, *poolAcquireTimeout)
+	if *poolIdleShrink > 0 {
+		memoryPool.StartIdleShrink(*poolIdleShrink)
+	}
 
 	// Create and start WebSocket server
 	wsServer := network.NewAudioWebSocketServer(*port, *path, streamMgr, memoryPool)
+	wsServer.SetBindAddress(*bind)
+	wsServer.SetNoDelay(*noDelay)
+	wsServer.SetCompression(*compression)
+	wsServer.SetAdminToken(*adminToken)
+	var authVerifier *auth.Verifier
+	if *authSecret != "" {
+		// One verifier for every transport, so a nonce is single-use across them
+		authVerifier = auth.NewVerifier(*authSecret, *authWindow)
+		wsServer.SetAuthVerifier(authVerifier)
+	}
+	if *maxTotalMbps > 0 {
+		wsServer.SetBandwidthLimiter(handler.NewBandwidthLimiter(*maxTotalMbps))
+	}
+	wsServer.MessageHandler().SetStrictFrames(*strictFrames)
+	wsServer.MessageHandler().SetMaxInflightGets(*maxInflightGets)
+	wsServer.MessageHandler().SetMinChunkSize(*minChunkSize)
+	wsServer.MessageHandler().SetLiveReads(*liveReads)
+	wsServer.MessageHandler().SetMaxMessageSize(*maxMessageSize)
+	wsServer.MessageHandler().SetReorderWindow(*reorderWindow)
+	wsServer.MessageHandler().SetMaxStreamsPerConnection(*maxStreamsPerConn)
+	wsServer.MessageHandler().SetDedupFrames(*dedupFrames)
+	wsServer.MessageHandler().SetTranscoderName(*transcoderSpec)
+	if err := wsServer.MessageHandler().SetAllowedFormats(splitList(*allowedFormats)); err != nil {
+		logger.Error(fmt.Sprintf("Invalid --allowed-formats: %v", err))
+		os.Exit(1)
+	}
+	if *fairQuantum > 0 {
+		wsServer.MessageHandler().SetFairScheduler(handler.NewFairScheduler(*fairQuantum, *fairWriteTimeout))
+	}
+	if *idleTimeout > 0 {
+		wsServer.MessageHandler().StartIdleReaper(*reapInterval, *idleTimeout)
+	}
+	var accessLog *handler.AccessLogger
+	if *accessLogPath != "" {
+		var err error
+		if accessLog, err = handler.OpenAccessLog(*accessLogPath); err != nil {
+			logger.Error(fmt.Sprintf("Failed to open access log %s: %v", *accessLogPath, err))
+			os.Exit(1)
+		}
+		wsServer.MessageHandler().SetAccessLogger(accessLog)
+	}
 
-	// Handle graceful shutdown
+	// Optional gRPC transport sharing the same stream registry
+	var grpcServer *grpc.AudioGrpcServer
+	if *grpcPort > 0 {
+		// Upload policies enforced per WebSocket message have no gRPC counterpart
+		if *allowedFormats != "" || *accessLogPath != "" {
+			logger.Error("--grpc-port cannot be combined with --allowed-formats or --access-log; the gRPC transport does not apply them")
+			os.Exit(1)
+		}
+		grpcServer = grpc.NewAudioGrpcServer(*grpcPort, streamMgr)
+		grpcServer.SetBindAddress(*bind)
+		if authVerifier != nil {
+			grpcServer.SetAuthVerifier(authVerifier)
+		}
+		go func() {
+			if err := grpcServer.Start(); err != nil {
+				logger.Error(fmt.Sprintf("gRPC server stopped: %v", err))
+			}
+		}()
+	}
+
+	// Handle graceful shutdown: drain on the first signal, exit immediately on a second
 	go func() {
-		sigChan := make(chan os.Signal, 1)
+		sigChan := make(chan os.Signal, 2)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
 		logger.Info("Shutting down server...")
+		wsServer.Drain()
+		if grpcServer != nil {
+			grpcServer.Drain()
+		}
+
+		go func() {
+			<-sigChan
+			logger.Warn("Second signal received, exiting without waiting for active streams")
+			os.Exit(1)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+		if err := wsServer.WaitForStreams(ctx); err != nil {
+			logger.Warn(fmt.Sprintf("Drain incomplete: %v", err))
+		}
+		if grpcServer != nil {
+			grpcServer.Shutdown(ctx)
+		}
+		wsServer.Shutdown(ctx)
+		wsServer.MessageHandler().LogSessionSummary()
+		accessLog.Close()
+		telemetry.Shutdown()
 		os.Exit(0)
 	}()
 
 	wsServer.Start()
+
+	// Start returns as soon as shutdown begins; let the shutdown goroutine finish and exit
+	if wsServer.IsDraining() {
+		select {}
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -0,0 +1,264 @@
+// Package grpc exposes the stream cache over gRPC as an alternative to the
+// WebSocket transport. Both transports share the same StreamManager.
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/auth"
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	pb "github.com/feuyeux/hello-mmap/hello-go/src/server/grpc/audiostreampb"
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const defaultChunkSize = 65536 // 64KB, matches the WebSocket GET default
+
+// AudioGrpcServer serves the AudioStream service defined in proto/audio_stream.proto
+type AudioGrpcServer struct {
+	port          int
+	bindAddress   string // Empty listens on all interfaces
+	streamManager *memory.StreamManager
+	server        *grpclib.Server
+	draining      atomic.Bool    // Refuse new uploads while set
+	authVerifier  *auth.Verifier // Checks the HMAC handshake in RPC metadata; nil accepts all
+}
+
+// NewAudioGrpcServer creates a gRPC server backed by streamMgr
+func NewAudioGrpcServer(port int, streamMgr *memory.StreamManager) *AudioGrpcServer {
+	s := &AudioGrpcServer{
+		port:          port,
+		streamManager: streamMgr,
+		server:        grpclib.NewServer(),
+	}
+	s.server.RegisterService(&audioStreamServiceDesc, s)
+	return s
+}
+
+// SetBindAddress restricts the listener to one host or interface address
+func (s *AudioGrpcServer) SetBindAddress(address string) {
+	s.bindAddress = address
+}
+
+// SetAuthVerifier requires every RPC to carry an HMAC handshake that
+// verifier accepts, as ts, nonce and sig metadata
+func (s *AudioGrpcServer) SetAuthVerifier(verifier *auth.Verifier) {
+	s.authVerifier = verifier
+}
+
+// Start listens on the configured port and serves until Stop is called
+func (s *AudioGrpcServer) Start() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.bindAddress, strconv.Itoa(s.port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", s.port, err)
+	}
+
+	logger.Info(fmt.Sprintf("gRPC server started on %s", listener.Addr()))
+	return s.Serve(listener)
+}
+
+// Serve serves on an existing listener until Stop is called
+func (s *AudioGrpcServer) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Drain makes Upload fail with Unavailable; uploads and downloads already
+// running continue
+func (s *AudioGrpcServer) Drain() {
+	s.draining.Store(true)
+}
+
+// Stop stops the server after in-flight RPCs complete
+func (s *AudioGrpcServer) Stop() {
+	s.server.GracefulStop()
+}
+
+// Shutdown stops the server like Stop, but once ctx is done cancels the RPCs
+// still running; their uploads are marked incomplete
+func (s *AudioGrpcServer) Shutdown(ctx context.Context) {
+	s.Drain()
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Warn("gRPC drain incomplete, cancelling remaining RPCs")
+		s.server.Stop()
+		<-stopped
+	}
+}
+
+// audioStreamServiceDesc describes the AudioStream service for registration
+var audioStreamServiceDesc = grpclib.ServiceDesc{
+	ServiceName: "audiostream.v1.AudioStream",
+	HandlerType: (*any)(nil),
+	Streams: []grpclib.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       func(srv any, stream grpclib.ServerStream) error { return srv.(*AudioGrpcServer).upload(stream) },
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       func(srv any, stream grpclib.ServerStream) error { return srv.(*AudioGrpcServer).download(stream) },
+			ServerStreams: true,
+		},
+	},
+	Metadata: "audio_stream.proto",
+}
+
+// authorize checks the HMAC handshake in the metadata of an RPC
+func (s *AudioGrpcServer) authorize(ctx context.Context) error {
+	if s.authVerifier == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	handshake := url.Values{}
+	for _, key := range []string{auth.ParamTimestamp, auth.ParamNonce, auth.ParamSignature} {
+		if values := md.Get(key); len(values) > 0 {
+			handshake.Set(key, values[0])
+		}
+	}
+	if err := s.authVerifier.Verify(handshake); err != nil {
+		logger.Warn(fmt.Sprintf("Rejected gRPC call: %v", err))
+		return status.Errorf(codes.Unauthenticated, "unauthorized: %v", err)
+	}
+	return nil
+}
+
+// upload handles a client-streaming Upload: one start message, then chunks
+func (s *AudioGrpcServer) upload(stream grpclib.ServerStream) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	first := &pb.UploadRequest{}
+	if err := stream.RecvMsg(first); err != nil {
+		return err
+	}
+	start := first.GetStart()
+	if start == nil || start.GetStreamId() == "" {
+		return status.Error(codes.InvalidArgument, "first message must be a start with a streamId")
+	}
+	if start.GetSize() < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid declared size: %d", start.GetSize())
+	}
+
+	if s.draining.Load() {
+		return status.Error(codes.Unavailable, "server is draining and not accepting new streams")
+	}
+
+	streamID := start.GetStreamId()
+	if !s.streamManager.CreateStream(streamID) {
+		return status.Errorf(codes.AlreadyExists, "failed to create stream: %s", streamID)
+	}
+	if start.GetSize() > 0 {
+		s.streamManager.SetDeclaredSize(streamID, start.GetSize())
+	}
+	logger.Debug(fmt.Sprintf("gRPC upload started: %s", streamID))
+
+	// Any failure before finalize leaves partial data that must not be served
+	finalized := false
+	defer func() {
+		if !finalized {
+			s.streamManager.MarkIncomplete(streamID)
+		}
+	}()
+
+	for {
+		req := &pb.UploadRequest{}
+		err := stream.RecvMsg(req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetStart() != nil {
+			return status.Error(codes.InvalidArgument, "unexpected start message after upload began")
+		}
+		if err := s.streamManager.WriteChunk(streamID, req.GetChunk()); err != nil {
+			return status.Errorf(codes.FailedPrecondition, "failed to write to stream: %v", err)
+		}
+	}
+
+	if err := s.streamManager.FinalizeStream(streamID); err != nil {
+		return status.Errorf(codes.Internal, "failed to finalize stream: %v", err)
+	}
+	finalized = true
+
+	info, ok := s.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return status.Errorf(codes.NotFound, "stream %s was removed during finalize", streamID)
+	}
+	logger.Debug(fmt.Sprintf("gRPC upload finalized: %s with %d bytes", streamID, info.Size))
+	return stream.SendMsg(&pb.UploadResponse{StreamId: streamID, Size: info.Size})
+}
+
+// download handles a server-streaming Download of a byte range
+func (s *AudioGrpcServer) download(stream grpclib.ServerStream) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	req := &pb.DownloadRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	streamID := req.GetStreamId()
+	info, ok := s.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return status.Errorf(codes.NotFound, "stream not found: %s", streamID)
+	}
+	if info.Status != memory.StatusReady {
+		// A size snapshot of an unfinished stream would pass for the whole file
+		return status.Errorf(codes.FailedPrecondition, "stream %s is %s, not READY", streamID, info.Status)
+	}
+
+	offset := req.GetOffset()
+	if offset < 0 || req.GetLength() < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid range: offset %d, length %d", offset, req.GetLength())
+	}
+	if offset > info.Size {
+		return status.Errorf(codes.OutOfRange, "offset %d is beyond end of stream %s (size %d)", offset, streamID, info.Size)
+	}
+
+	end := info.Size
+	if req.GetLength() > 0 && offset+req.GetLength() < end {
+		end = offset + req.GetLength()
+	}
+	chunkSize := int64(defaultChunkSize)
+	if req.GetChunkSize() > 0 {
+		chunkSize = int64(req.GetChunkSize())
+	}
+
+	for offset < end {
+		length := end - offset
+		if length > chunkSize {
+			length = chunkSize
+		}
+
+		data := s.streamManager.ReadChunk(streamID, offset, int(length))
+		if len(data) == 0 {
+			return status.Errorf(codes.Internal, "failed to read from stream %s at offset %d", streamID, offset)
+		}
+		if err := stream.SendMsg(&pb.DownloadResponse{Offset: offset, Chunk: data}); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+	}
+
+	logger.Debug(fmt.Sprintf("gRPC download of %s complete at offset %d", streamID, offset))
+	return nil
+}
@@ -0,0 +1,172 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/auth"
+	pb "github.com/feuyeux/hello-mmap/hello-go/src/server/grpc/audiostreampb"
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startTestServer serves s on an in-memory listener and returns a client
+// connection to it
+func startTestServer(t *testing.T, s *AudioGrpcServer) *grpclib.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	go s.Serve(listener)
+	t.Cleanup(s.server.Stop)
+
+	conn, err := grpclib.NewClient("passthrough:///bufconn",
+		grpclib.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpclib.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func uploadStream(ctx context.Context, conn *grpclib.ClientConn, streamID string, chunks ...[]byte) (*pb.UploadResponse, error) {
+	stream, err := conn.NewStream(ctx, &audioStreamServiceDesc.Streams[0], "/audiostream.v1.AudioStream/Upload")
+	if err != nil {
+		return nil, err
+	}
+	start := &pb.UploadRequest{Payload: &pb.UploadRequest_Start{Start: &pb.UploadStart{StreamId: streamID}}}
+	if err := stream.SendMsg(start); err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if err := stream.SendMsg(&pb.UploadRequest{Payload: &pb.UploadRequest_Chunk{Chunk: chunk}}); err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	response := &pb.UploadResponse{}
+	return response, stream.RecvMsg(response)
+}
+
+func downloadStream(ctx context.Context, conn *grpclib.ClientConn, req *pb.DownloadRequest) ([]byte, error) {
+	stream, err := conn.NewStream(ctx, &audioStreamServiceDesc.Streams[1], "/audiostream.v1.AudioStream/Download")
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		response := &pb.DownloadResponse{}
+		err := stream.RecvMsg(response)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+		data = append(data, response.GetChunk()...)
+	}
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	conn := startTestServer(t, NewAudioGrpcServer(0, memory.NewStreamManager(t.TempDir())))
+	ctx := context.Background()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 10000) // Spans several download chunks
+	uploaded, err := uploadStream(ctx, conn, "grpc-round-trip", payload[:70000], payload[70000:])
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if uploaded.GetSize() != int64(len(payload)) {
+		t.Fatalf("upload size = %d, want %d", uploaded.GetSize(), len(payload))
+	}
+
+	data, err := downloadStream(ctx, conn, &pb.DownloadRequest{StreamId: "grpc-round-trip"})
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("downloaded %d bytes that differ from the %d uploaded", len(data), len(payload))
+	}
+
+	data, err = downloadStream(ctx, conn, &pb.DownloadRequest{StreamId: "grpc-round-trip", Offset: 100, Length: 50})
+	if err != nil {
+		t.Fatalf("ranged download: %v", err)
+	}
+	if !bytes.Equal(data, payload[100:150]) {
+		t.Fatalf("ranged download = %q, want %q", data, payload[100:150])
+	}
+}
+
+func TestDownloadRejectsUnfinishedStream(t *testing.T) {
+	streamMgr := memory.NewStreamManager(t.TempDir())
+	conn := startTestServer(t, NewAudioGrpcServer(0, streamMgr))
+
+	streamMgr.CreateStream("grpc-uploading")
+	if err := streamMgr.WriteChunk("grpc-uploading", []byte("partial")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	_, err := downloadStream(context.Background(), conn, &pb.DownloadRequest{StreamId: "grpc-uploading"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("download of an UPLOADING stream: got %v, want FailedPrecondition", err)
+	}
+}
+
+func TestUploadRefusedWhileDraining(t *testing.T) {
+	s := NewAudioGrpcServer(0, memory.NewStreamManager(t.TempDir()))
+	conn := startTestServer(t, s)
+	s.Drain()
+
+	_, err := uploadStream(context.Background(), conn, "grpc-draining", []byte("data"))
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("upload while draining: got %v, want Unavailable", err)
+	}
+}
+
+func TestCallsRequireHandshake(t *testing.T) {
+	s := NewAudioGrpcServer(0, memory.NewStreamManager(t.TempDir()))
+	s.SetAuthVerifier(auth.NewVerifier("grpc-secret", time.Minute))
+	conn := startTestServer(t, s)
+
+	_, err := uploadStream(context.Background(), conn, "grpc-unsigned", []byte("data"))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("unsigned upload: got %v, want Unauthenticated", err)
+	}
+
+	handshake, err := auth.NewHandshake("grpc-secret")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	var pairs []string
+	for key := range handshake {
+		pairs = append(pairs, key, handshake.Get(key))
+	}
+	signed := metadata.AppendToOutgoingContext(context.Background(), pairs...)
+	if _, err := uploadStream(signed, conn, "grpc-signed", []byte("data")); err != nil {
+		t.Fatalf("signed upload: %v", err)
+	}
+
+	// The nonce was used by the upload
+	_, err = downloadStream(signed, conn, &pb.DownloadRequest{StreamId: "grpc-signed"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("download replaying the upload's handshake: got %v, want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AccessEntry is one access log line
+type AccessEntry struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	StreamID string    `json:"streamId,omitempty"`
+	Type     string    `json:"type"`            // Control message type, or DATA for an uploaded frame
+	Bytes    int64     `json:"bytes"`           // Payload bytes received (DATA) or sent (GET)
+	Outcome  string    `json:"outcome"`         // "ok" or "error"
+	Error    string    `json:"error,omitempty"` // Reason sent to the client on error
+}
+
+// AccessLogger writes one JSON line per control message and per transfer.
+// It is separate from the debug logger and always written regardless of verbosity.
+type AccessLogger struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// OpenAccessLog opens (appending to) the access log at path
+func OpenAccessLog(path string) (*AccessLogger, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLogger{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Log writes an entry; a nil logger discards it
+func (l *AccessLogger) Log(entry AccessEntry) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.encoder.Encode(entry)
+}
+
+// Close closes the underlying file
+func (l *AccessLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// SetAccessLogger enables access logging for all handled messages
+func (h *WebSocketMessageHandler) SetAccessLogger(accessLog *AccessLogger) {
+	h.accessLog = accessLog
+}
+
+// logAccess records the outcome of one message from conn
+func (h *WebSocketMessageHandler) logAccess(conn *websocket.Conn, msgType, streamID string, bytes int64, err error) {
+	if h.accessLog == nil {
+		return
+	}
+
+	entry := AccessEntry{
+		Time:     time.Now(),
+		Client:   conn.RemoteAddr().String(),
+		StreamID: streamID,
+		Type:     msgType,
+		Bytes:    bytes,
+		Outcome:  "ok",
+	}
+	if err != nil {
+		entry.Outcome = "error"
+		entry.Error = err.Error()
+	}
+	h.accessLog.Log(entry)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+// errFormatNotAllowed marks a checkFormat refusal
+var errFormatNotAllowed = errors.New("audio format not allowed")
+
+// knownFormats are the formats memory.DetectAudioFormat can report
+var knownFormats = []string{memory.FormatWAV, memory.FormatMP3, memory.FormatOGG, memory.FormatFLAC}
+
+// SetAllowedFormats restricts uploads to streams whose detected format is one
+// of formats (e.g. "wav", "flac"); others fail at their first write with
+// FORMAT_NOT_ALLOWED. An empty list allows every format, including unknown ones.
+func (h *WebSocketMessageHandler) SetAllowedFormats(formats []string) error {
+	if len(formats) == 0 {
+		h.allowedFormats = nil
+		return nil
+	}
+	allowed := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		format = strings.ToLower(format)
+		if !isKnownFormat(format) {
+			return fmt.Errorf("unknown audio format %q (expected one of %s)", format, strings.Join(knownFormats, ", "))
+		}
+		allowed[format] = true
+	}
+	h.allowedFormats = allowed
+	return nil
+}
+
+// allowedFormatList returns the allowed formats sorted, or nil when all are allowed
+func (h *WebSocketMessageHandler) allowedFormatList() []string {
+	if h.allowedFormats == nil {
+		return nil
+	}
+	list := make([]string, 0, len(h.allowedFormats))
+	for format := range h.allowedFormats {
+		list = append(list, format)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// checkFormat detects the format of a stream's first chunk and returns an
+// error when it is not allowed; later chunks are not checked
+func (h *WebSocketMessageHandler) checkFormat(streamID string, chunk []byte) error {
+	if h.allowedFormats == nil {
+		return nil
+	}
+	if info, ok := h.streamManager.GetStreamInfo(streamID); !ok || info.Size > 0 {
+		return nil
+	}
+	format := memory.DetectAudioFormat(chunk)
+	if h.allowedFormats[format] {
+		return nil
+	}
+	if format == "" {
+		format = "unknown"
+	}
+	return fmt.Errorf("%w: %s for stream %s (allowed: %s)",
+		errFormatNotAllowed, format, streamID, strings.Join(h.allowedFormatList(), ", "))
+}
+
+func isKnownFormat(format string) bool {
+	for _, known := range knownFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
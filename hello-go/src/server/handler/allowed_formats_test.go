@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+// wavHeader is the start of a RIFF/WAVE file, enough for format detection
+const wavHeader = "RIFF\x24\x00\x00\x00WAVEfmt "
+
+func TestAllowedFormats(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.handler.SetAllowedFormats([]string{"wav", "FLAC"}); err != nil {
+		t.Fatalf("SetAllowedFormats: %v", err)
+	}
+	client := s.dial(t)
+
+	allowed := map[string][]byte{
+		"format-wav":  []byte(wavHeader + "samples"),
+		"format-flac": []byte("fLaC\x00\x00\x00\x22frames"),
+	}
+	for streamID, data := range allowed {
+		client.upload(streamID, data)
+		if info, _ := s.streamManager.GetStreamInfo(streamID); info.Status != memory.StatusReady || info.Size != int64(len(data)) {
+			t.Errorf("%s: info %+v, want READY with %d bytes", streamID, info, len(data))
+		}
+	}
+
+	disallowed := map[string][]byte{
+		"format-ogg":     []byte("OggS\x00\x02pages"),
+		"format-mp3":     []byte("ID3\x04\x00\x00frames"),
+		"format-unknown": []byte("plain bytes"),
+	}
+	for streamID, data := range disallowed {
+		client.start(streamID)
+		client.sendBinary(data)
+		refused := client.expectError(ErrCodeFormatNotAllowed)
+		if !strings.Contains(refused.Message, "allowed: flac, wav") {
+			t.Errorf("%s: message %q does not list the allowed formats", streamID, refused.Message)
+		}
+		if info, _ := s.streamManager.GetStreamInfo(streamID); info.Status != memory.StatusError || info.Size != 0 {
+			t.Errorf("%s: info %+v, want ERROR with nothing written", streamID, info)
+		}
+	}
+
+	// Only the first write is checked
+	client.start("format-later")
+	client.sendBinary([]byte(wavHeader))
+	client.sendBinary([]byte("OggS later chunk"))
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "format-later"})
+	client.expect("STOPPED")
+}
+
+func TestAllowedFormatsCheckCoalescedUpload(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMinChunkSize(1024)
+	if err := s.handler.SetAllowedFormats([]string{"wav"}); err != nil {
+		t.Fatalf("SetAllowedFormats: %v", err)
+	}
+	client := s.dial(t)
+
+	// Shorter than the minimum chunk size, these uploads are only written at STOP
+	client.upload("short-wav", []byte(wavHeader+"samples"))
+	if info, _ := s.streamManager.GetStreamInfo("short-wav"); info.Status != memory.StatusReady {
+		t.Errorf("short WAV upload is %s, want READY", info.Status)
+	}
+
+	client.start("short-random")
+	client.sendBinary(testPayload(1, 500))
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "short-random"})
+	client.expectError(ErrCodeFormatNotAllowed)
+	if info, _ := s.streamManager.GetStreamInfo("short-random"); info.Status != memory.StatusError || info.Size != 0 {
+		t.Errorf("short random upload: info %+v, want ERROR with nothing written", info)
+	}
+}
+
+func TestSetAllowedFormatsRejectsUnknown(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.handler.SetAllowedFormats([]string{"wav", "aiff"}); err == nil {
+		t.Fatal("SetAllowedFormats accepted an unknown format")
+	}
+	if err := s.handler.SetAllowedFormats(nil); err != nil || s.handler.allowedFormatList() != nil {
+		t.Fatalf("empty list: err %v, formats %v; want every format allowed", err, s.handler.allowedFormatList())
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// bandwidthSlice is the most a single reservation takes from the bucket, so
+// one large frame cannot hold the whole budget while others wait
+const bandwidthSlice = 64 * 1024
+
+// BandwidthLimiter is a token bucket shared by every connection, capping the
+// server's total upload plus download rate. Callers reserve tokens in arrival
+// order and then sleep until their reservation is covered, so concurrent
+// connections are served first come, first served in slices of at most 64KB.
+type BandwidthLimiter struct {
+	mutex       sync.Mutex
+	bytesPerSec float64
+	burst       float64
+	tokens      float64 // May go negative: outstanding reservations
+	last        time.Time
+}
+
+// NewBandwidthLimiter creates a limiter for the given total rate in megabits per second
+func NewBandwidthLimiter(mbps float64) *BandwidthLimiter {
+	bytesPerSec := mbps * 1_000_000 / 8
+	burst := bytesPerSec / 10 // 100ms worth of traffic
+	if burst < bandwidthSlice {
+		burst = bandwidthSlice
+	}
+	return &BandwidthLimiter{
+		bytesPerSec: bytesPerSec,
+		burst:       burst,
+		tokens:      burst,
+		last:        time.Now(),
+	}
+}
+
+// Wait blocks until n bytes fit in the shared budget; a nil limiter never blocks
+func (l *BandwidthLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	for n > 0 {
+		slice := min(n, bandwidthSlice)
+		if delay := l.reserve(slice); delay > 0 {
+			time.Sleep(delay)
+		}
+		n -= slice
+	}
+}
+
+// reserve takes n tokens and returns how long to wait until they are earned
+func (l *BandwidthLimiter) reserve(n int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.bytesPerSec)
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.bytesPerSec * float64(time.Second))
+}
+
+// SetBandwidthLimiter throttles GET responses through a limiter shared with uploads
+func (h *WebSocketMessageHandler) SetBandwidthLimiter(limiter *BandwidthLimiter) {
+	h.bandwidth = limiter
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterSharedByTwoConnections(t *testing.T) {
+	s := newTestServer(t)
+	const size = 256 * 1024
+
+	// 8 Mbps is 1 MB/s with a 100 KB burst: two 256 KB responses take about
+	// 0.4s together, while either alone would be done in about 0.16s
+	s.handler.SetBandwidthLimiter(NewBandwidthLimiter(8))
+	s.dial(t).upload("shared-cap", testPayload(1, size)) // Only GET responses are throttled by the handler
+	clients := []*testClient{s.dial(t), s.dial(t)}
+
+	start := time.Now()
+	elapsed := make([]time.Duration, len(clients))
+	received := make([]int, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		c.get("shared-cap", 0, size)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, data, err := c.conn.ReadMessage(); err == nil {
+				received[i] = len(data)
+			}
+			elapsed[i] = time.Since(start)
+		}()
+	}
+	wg.Wait()
+
+	for i := range clients {
+		if received[i] != size {
+			t.Fatalf("connection %d received %d bytes, want %d", i, received[i], size)
+		}
+		// Sharing the budget fairly, neither connection gets its response at full rate
+		if elapsed[i] < 250*time.Millisecond {
+			t.Errorf("connection %d was served in %v, faster than its share of the cap allows", i, elapsed[i])
+		}
+	}
+	if total := max(elapsed[0], elapsed[1]); total < 350*time.Millisecond || total > 2*time.Second {
+		t.Errorf("both responses took %v, want about 0.4s at 1 MB/s", total)
+	}
+}
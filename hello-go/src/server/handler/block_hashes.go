@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+// Block hash sizes for HASHES requests
+const (
+	DefaultHashBlockSize = 256 * 1024
+	MinHashBlockSize     = 4 * 1024
+	MaxHashBlockSize     = 16 * 1024 * 1024
+)
+
+// handleHashes handles HASHES message: SHA-256 of each block of a stream,
+// letting a client check data it already holds. The last block may be short.
+func (h *WebSocketMessageHandler) handleHashes(conn *websocket.Conn, data *WebSocketMessage) error {
+	streamID := data.StreamId
+	if streamID == "" {
+		return h.reject(conn, "Missing streamId")
+	}
+
+	blockSize := DefaultHashBlockSize
+	if data.BlockSize != nil {
+		blockSize = *data.BlockSize
+	}
+	if blockSize < MinHashBlockSize || blockSize > MaxHashBlockSize {
+		return h.reject(conn, fmt.Sprintf("Invalid blockSize: %d (expected %d-%d)", blockSize, MinHashBlockSize, MaxHashBlockSize))
+	}
+
+	info, ok := h.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return h.reject(conn, fmt.Sprintf("Stream not found: %s", streamID))
+	}
+
+	hashes := make([]string, 0, (info.Size+int64(blockSize)-1)/int64(blockSize))
+	for offset := int64(0); offset < info.Size; offset += int64(blockSize) {
+		block := h.streamManager.ReadChunk(streamID, offset, blockSize)
+		if len(block) == 0 {
+			return h.reject(conn, fmt.Sprintf("Failed to read from stream: %s", streamID))
+		}
+		sum := sha256.Sum256(block)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+
+	if err := h.sendJSON(conn, NewHashesMessage(streamID, info.Size, blockSize, hashes)); err != nil {
+		return err
+	}
+	logger.Debug(fmt.Sprintf("Sent %d block hashes for stream %s (block size %d)", len(hashes), streamID, blockSize))
+	return nil
+}
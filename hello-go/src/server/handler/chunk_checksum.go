@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Per-chunk checksums. With chunkChecksum "crc32" in START, every binary
+// frame of the upload is a 4-byte big-endian CRC32 (IEEE) of the payload
+// followed by the payload itself.
+const (
+	ChunkChecksumCRC32      = "crc32"
+	ChunkChecksumHeaderSize = 4
+
+	// ErrCodeChecksumMismatch fails a stream whose chunk did not match its CRC32
+	ErrCodeChecksumMismatch = "CHECKSUM_MISMATCH"
+)
+
+// verifyChunkChecksum checks a checksummed frame and returns its payload
+func verifyChunkChecksum(frame []byte) ([]byte, error) {
+	if len(frame) <= ChunkChecksumHeaderSize {
+		return nil, fmt.Errorf("frame of %d bytes is too short for a checksummed chunk", len(frame))
+	}
+	expected := binary.BigEndian.Uint32(frame[:ChunkChecksumHeaderSize])
+	payload := frame[ChunkChecksumHeaderSize:]
+	if actual := crc32.ChecksumIEEE(payload); actual != expected {
+		return nil, fmt.Errorf("CRC32 mismatch: frame says %08x, payload is %08x", expected, actual)
+	}
+	return payload, nil
+}
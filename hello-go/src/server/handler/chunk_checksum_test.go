@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+// checksummed frames payload as a CRC32-prefixed chunk
+func checksummed(payload []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(payload))
+	return append(frame, payload...)
+}
+
+func TestChunkChecksumAcceptsIntactChunks(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	data := testPayload(1, 3*4096)
+
+	c.send(WebSocketMessage{Type: "START", StreamId: "crc-intact", ChunkChecksum: ChunkChecksumCRC32})
+	c.expect("STARTED")
+	for offset := 0; offset < len(data); offset += 4096 {
+		c.sendBinary(checksummed(data[offset : offset+4096]))
+	}
+	c.send(WebSocketMessage{Type: "STOP", StreamId: "crc-intact"})
+	c.expect("STOPPED")
+
+	c.get("crc-intact", 0, len(data))
+	if got := c.expectBinary(); !bytes.Equal(got, data) {
+		t.Error("stored stream differs from the checksummed payloads")
+	}
+}
+
+func TestChunkChecksumRejectsCorruptedChunk(t *testing.T) {
+	for name, corrupt := range map[string]func(frame []byte) []byte{
+		"payload":   func(frame []byte) []byte { frame[ChunkChecksumHeaderSize+100] ^= 0x01; return frame },
+		"checksum":  func(frame []byte) []byte { frame[0] ^= 0x80; return frame },
+		"too short": func(frame []byte) []byte { return frame[:ChunkChecksumHeaderSize] },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t)
+			c := s.dial(t)
+			data := testPayload(2, 3*4096)
+
+			c.send(WebSocketMessage{Type: "START", StreamId: "crc-corrupt", ChunkChecksum: ChunkChecksumCRC32})
+			c.expect("STARTED")
+			c.sendBinary(checksummed(data[:4096]))
+			c.sendBinary(corrupt(checksummed(data[4096:8192])))
+			c.expectError(ErrCodeChecksumMismatch)
+
+			// Nothing after the corrupted chunk is written
+			c.sendBinary(checksummed(data[8192:]))
+			info := c.status("crc-corrupt")
+			if info.Status != string(memory.StatusError) {
+				t.Errorf("stream is %s after a corrupted chunk, want ERROR", info.Status)
+			}
+			if stream, _ := s.streamManager.GetStreamInfo("crc-corrupt"); stream.Size != 4096 {
+				t.Errorf("stream holds %d bytes, want only the 4096 before the corrupted chunk", stream.Size)
+			}
+		})
+	}
+}
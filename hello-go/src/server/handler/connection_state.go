@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	"github.com/gorilla/websocket"
+)
+
+// ErrCodeBusy rejects a GET when the connection already has the maximum
+// number of GETs in flight
+const ErrCodeBusy = "BUSY"
+
+// connectionState holds handler state for one connection
+type connectionState struct {
+	writeMutex sync.Mutex             // Serializes writes; gorilla allows one concurrent writer
+	gets       chan *WebSocketMessage // Pending GETs; nil when GETs are served inline
+	pendingMu  sync.Mutex             // Guards pending; Close flushes it from outside the read loop
+	pending    []byte                 // Frames below the minimum chunk size, not yet written
+
+	chunkChecksum bool            // Binary frames of the current upload carry a CRC32 prefix; read loop only
+	sequencer     *frameSequencer // Orders the current upload's sequenced frames; nil when unsequenced
+
+	streams map[string]struct{} // Streams started on this connection and maybe unfinished; guarded by clientsMutex
+
+	lastRead atomic.Int64 // UnixNano of the last message received, for the idle reaper
+
+	// Done once the connection is gone, so reads for its GETs stop early
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// HandleConnect registers a new client connection
+func (h *WebSocketMessageHandler) HandleConnect(conn *websocket.Conn) {
+	state := &connectionState{streams: make(map[string]struct{})}
+	state.ctx, state.cancel = context.WithCancel(context.Background())
+	state.lastRead.Store(time.Now().UnixNano())
+	if h.maxMessageSize > 0 {
+		conn.SetReadLimit(h.maxMessageSize)
+	}
+	if h.maxInflightGets > 0 {
+		// One GET is being served by the worker plus up to max-1 waiting
+		state.gets = make(chan *WebSocketMessage, h.maxInflightGets-1)
+		go h.serveQueuedGets(conn, state)
+	}
+
+	h.clientsMutex.Lock()
+	h.clients[conn] = ""
+	h.connections[conn] = state
+	connections := len(h.connections)
+	h.clientsMutex.Unlock()
+	h.session.recordConnections(connections)
+}
+
+// connectionState returns the state of a registered connection, or nil
+func (h *WebSocketMessageHandler) connectionState(conn *websocket.Conn) *connectionState {
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+	return h.connections[conn]
+}
+
+// pendingStreams returns how many streams started on conn are still
+// uploading, forgetting the ones that have finished, failed or been deleted
+func (h *WebSocketMessageHandler) pendingStreams(conn *websocket.Conn) int {
+	h.clientsMutex.Lock()
+	defer h.clientsMutex.Unlock()
+	state := h.connections[conn]
+	if state == nil {
+		return 0
+	}
+	for streamID := range state.streams {
+		if info, ok := h.streamManager.GetStreamInfo(streamID); !ok || info.Status != memory.StatusUploading {
+			delete(state.streams, streamID)
+		}
+	}
+	return len(state.streams)
+}
+
+// connectionContext returns the context of conn, cancelled on disconnect;
+// an unregistered connection is treated as gone
+func (h *WebSocketMessageHandler) connectionContext(conn *websocket.Conn) context.Context {
+	if state := h.connectionState(conn); state != nil {
+		return state.ctx
+	}
+	return closedContext
+}
+
+// closedContext is an already cancelled context
+var closedContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// serveQueuedGets serves a connection's GETs in arrival order
+func (h *WebSocketMessageHandler) serveQueuedGets(conn *websocket.Conn, state *connectionState) {
+	for data := range state.gets {
+		if state.ctx.Err() != nil {
+			logger.Debug(fmt.Sprintf("Skipping queued GET for stream %s: client disconnected", data.StreamId))
+			continue
+		}
+		h.serveGet(conn, data)
+	}
+}
+
+// coalesce buffers binary frames smaller than the minimum chunk size and
+// returns the bytes ready to write, or nil while the buffer is still short.
+// Once anything is buffered, later frames are appended too so order is kept.
+func (h *WebSocketMessageHandler) coalesce(state *connectionState, data []byte) []byte {
+	if h.minChunkSize <= 0 || state == nil {
+		return data
+	}
+	state.pendingMu.Lock()
+	defer state.pendingMu.Unlock()
+	if len(state.pending) == 0 && len(data) >= h.minChunkSize {
+		return data
+	}
+
+	state.pending = append(state.pending, data...)
+	if len(state.pending) < h.minChunkSize {
+		return nil
+	}
+	ready := state.pending
+	state.pending = nil
+	return ready
+}
+
+// flushPending writes any coalesced bytes still buffered for conn, which is
+// how the final short chunk of an upload reaches the stream. An upload that
+// never reached the minimum chunk size is format-checked here, failing the
+// stream when its format is not allowed.
+func (h *WebSocketMessageHandler) flushPending(state *connectionState, streamID string) error {
+	if state == nil {
+		return nil
+	}
+	state.pendingMu.Lock()
+	pending := state.pending
+	state.pending = nil
+	state.pendingMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := h.checkFormat(streamID, pending); err != nil {
+		h.streamManager.FailStream(streamID)
+		return err
+	}
+	if err := h.streamManager.WriteChunk(streamID, pending); err != nil {
+		return err
+	}
+	h.session.bytesWritten.Add(int64(len(pending)))
+	return nil
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestMaxInflightGetsRejectsFlood(t *testing.T) {
+	s := newTestServer(t)
+	const max, flood, size = 2, 10, 128 * 1024
+	s.handler.SetMaxInflightGets(max)
+	// A slow worker keeps the first GETs in flight while the rest arrive
+	s.handler.SetBandwidthLimiter(NewBandwidthLimiter(8))
+	c := s.dial(t)
+	c.upload("inflight", testPayload(1, size))
+
+	for i := 0; i < flood; i++ {
+		c.get("inflight", 0, size)
+	}
+
+	served, busy := 0, 0
+	for i := 0; i < flood; i++ {
+		msg, data := c.next()
+		switch {
+		case msg == nil && len(data) == size:
+			served++
+		case msg != nil && msg.Code == ErrCodeBusy:
+			busy++
+			if msg.LimitName != "maxInflightGets" || msg.LimitValue == nil || *msg.LimitValue != max {
+				t.Errorf("BUSY names limit %s=%v, want maxInflightGets=%d", msg.LimitName, msg.LimitValue, max)
+			}
+		default:
+			t.Fatalf("unexpected reply %+v with %d bytes", msg, len(data))
+		}
+	}
+	if served < 1 || served > max || served+busy != flood {
+		t.Errorf("%d GETs served and %d BUSY, want at most %d served and the rest BUSY", served, busy, max)
+	}
+
+	// Once the queue has drained the connection is served again
+	c.get("inflight", 0, 16)
+	if data := c.expectBinary(); len(data) != 16 {
+		t.Errorf("GET after the flood returned %d bytes, want 16", len(data))
+	}
+}
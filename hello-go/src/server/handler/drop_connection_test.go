@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	"github.com/gorilla/websocket"
+)
+
+func TestWriteToClosedConnectionDropsIt(t *testing.T) {
+	streamManager := memory.NewStreamManager(t.TempDir())
+	clients := make(map[*websocket.Conn]string)
+	clientsMutex := &sync.RWMutex{}
+	h := NewWebSocketMessageHandler(streamManager, memory.GetMemoryPoolManager(65536, 10), clients, clientsMutex)
+
+	conn, _ := newConnPair(t)
+	h.HandleConnect(conn)
+	h.HandleTextMessage(conn, []byte(`{"type":"START","streamId":"dropped"}`))
+	h.HandleBinaryMessage(conn, []byte("partial upload"), "dropped")
+
+	// The connection dies under the handler; the next reply cannot be written
+	conn.Close()
+	h.HandleTextMessage(conn, []byte(`{"type":"STATUS","streamId":"dropped"}`))
+
+	clientsMutex.RLock()
+	_, registered := clients[conn]
+	clientsMutex.RUnlock()
+	if registered {
+		t.Error("connection is still registered after a failed write")
+	}
+	if info, _ := streamManager.GetStreamInfo("dropped"); info.Status != memory.StatusIncomplete {
+		t.Errorf("stream is %s after its connection was dropped, want INCOMPLETE", info.Status)
+	}
+	if ctx := h.connectionContext(conn); ctx.Err() == nil {
+		t.Error("reads for the dropped connection are not cancelled")
+	}
+
+	// Dropping again, and the read loop's disconnect, find nothing left to do
+	h.dropConnection(conn, nil)
+	h.HandleDisconnect(conn)
+	if info, _ := streamManager.GetStreamInfo("dropped"); info.Status != memory.StatusIncomplete {
+		t.Errorf("stream is %s after the disconnect, want INCOMPLETE", info.Status)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+// FairScheduler interleaves binary responses across connections.
+// Each queued response gets at most quantum bytes written per turn, in
+// round-robin order, so a large download cannot starve smaller ones.
+// A single goroutine performs all scheduled writes, each under a deadline:
+// a client that stops reading fails its own response after writeTimeout
+// instead of holding up every other connection.
+type FairScheduler struct {
+	quantum      int
+	writeTimeout time.Duration // Longest one quantum may take to write
+	queue        []*sendJob
+	mutex        sync.Mutex
+	cond         *sync.Cond
+}
+
+// DefaultFairWriteTimeout bounds each scheduled write unless configured otherwise
+const DefaultFairWriteTimeout = 10 * time.Second
+
+// sendJob is a binary message being written in quantum-sized pieces
+type sendJob struct {
+	conn    *websocket.Conn
+	data    []byte
+	written int
+	writer  interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	done chan error
+}
+
+// NewFairScheduler creates a scheduler writing quantum bytes per turn, each
+// within writeTimeout (DefaultFairWriteTimeout when 0 or less)
+func NewFairScheduler(quantum int, writeTimeout time.Duration) *FairScheduler {
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultFairWriteTimeout
+	}
+	s := &FairScheduler{quantum: quantum, writeTimeout: writeTimeout}
+	s.cond = sync.NewCond(&s.mutex)
+	go s.run()
+	logger.Info(fmt.Sprintf("FairScheduler enabled with %d byte quantum and %v write timeout", quantum, writeTimeout))
+	return s
+}
+
+// Send queues data as one binary message on conn and blocks until it has
+// been fully written or failed
+func (s *FairScheduler) Send(conn *websocket.Conn, data []byte) error {
+	job := &sendJob{conn: conn, data: data, done: make(chan error, 1)}
+
+	s.mutex.Lock()
+	s.queue = append(s.queue, job)
+	s.mutex.Unlock()
+	s.cond.Signal()
+
+	return <-job.done
+}
+
+// run services queued jobs round-robin
+func (s *FairScheduler) run() {
+	for {
+		s.mutex.Lock()
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		job := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		finished, err := job.writeQuantum(s.quantum, s.writeTimeout)
+		if err != nil || finished {
+			job.done <- err
+			continue
+		}
+
+		s.mutex.Lock()
+		s.queue = append(s.queue, job)
+		s.mutex.Unlock()
+	}
+}
+
+// writeQuantum writes up to quantum bytes of the job's message, failing if
+// the client does not take them within timeout. The caller of Send holds
+// the connection's write lock, so the deadline affects no other writer.
+func (j *sendJob) writeQuantum(quantum int, timeout time.Duration) (bool, error) {
+	j.conn.SetWriteDeadline(time.Now().Add(timeout))
+	defer j.conn.SetWriteDeadline(time.Time{})
+
+	if j.writer == nil {
+		w, err := j.conn.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			return false, err
+		}
+		j.writer = w
+	}
+
+	end := j.written + quantum
+	if end > len(j.data) {
+		end = len(j.data)
+	}
+	n, err := j.writer.Write(j.data[j.written:end])
+	j.written += n
+	if err != nil {
+		j.writer.Close()
+		return false, err
+	}
+
+	if j.written < len(j.data) {
+		return false, nil
+	}
+	return true, j.writer.Close()
+}
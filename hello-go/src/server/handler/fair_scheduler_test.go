@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFairSchedulerDeliversWholeMessages(t *testing.T) {
+	scheduler := NewFairScheduler(1000, time.Second)
+	server, client := newConnPair(t)
+
+	data := bytes.Repeat([]byte("fair"), 10000) // Several quanta
+	errs := make(chan error, 1)
+	go func() { errs <- scheduler.Send(server, data) }()
+
+	messageType, got, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if messageType != websocket.BinaryMessage || !bytes.Equal(got, data) {
+		t.Fatalf("received type %d with %d bytes, want one binary message of %d bytes", messageType, len(got), len(data))
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+}
+
+func TestFairSchedulerStalledReaderDoesNotBlockOthers(t *testing.T) {
+	scheduler := NewFairScheduler(64*1024, 200*time.Millisecond)
+	stalledServer, _ := newConnPair(t) // Its client never reads
+	server, client := newConnPair(t)
+
+	// Far more than the socket buffers hold, so the write to the stalled client blocks
+	stalled := make(chan error, 1)
+	go func() { stalled <- scheduler.Send(stalledServer, make([]byte, 64<<20)) }()
+	time.Sleep(100 * time.Millisecond)
+
+	small := []byte("small response")
+	sent := make(chan error, 1)
+	start := time.Now()
+	go func() { sent <- scheduler.Send(server, small) }()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Send to the reading client: %v", err)
+		}
+		t.Logf("small response sent after %v", time.Since(start))
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled client blocked the response to another connection")
+	}
+	if _, got, err := client.ReadMessage(); err != nil || !bytes.Equal(got, small) {
+		t.Fatalf("small response: got %q, %v", got, err)
+	}
+
+	select {
+	case err := <-stalled:
+		if err == nil {
+			t.Fatal("Send to a client that never reads succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send to a client that never reads did not time out")
+	}
+}
+
+// BenchmarkSmallResponseLatency measures how long a small response takes to
+// reach its client while another connection downloads in bulk, writing
+// inline and through the fair scheduler
+func BenchmarkSmallResponseLatency(b *testing.B) {
+	modes := []struct {
+		name string
+		send func(conn *websocket.Conn, data []byte) error
+	}{
+		{"inline", func(conn *websocket.Conn, data []byte) error {
+			return conn.WriteMessage(websocket.BinaryMessage, data)
+		}},
+		{"fair", NewFairScheduler(64*1024, time.Second).Send},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			bulkServer, bulkClient := newConnPair(b)
+			server, client := newConnPair(b)
+
+			// Bulk download running for the whole benchmark
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			var bulkBytes atomic.Int64
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				buffer := make([]byte, 64*1024)
+				for {
+					_, reader, err := bulkClient.NextReader()
+					if err != nil {
+						return
+					}
+					for {
+						n, err := reader.Read(buffer)
+						bulkBytes.Add(int64(n))
+						if err != nil {
+							break
+						}
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				bulk := make([]byte, 4<<20)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if mode.send(bulkServer, bulk) != nil {
+						return
+					}
+				}
+			}()
+
+			// Measure only once the bulk transfer is flowing
+			for bulkBytes.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			bulkBytes.Store(0)
+
+			small := make([]byte, 4096)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := mode.send(server, small); err != nil {
+					b.Fatalf("send: %v", err)
+				}
+				if _, _, err := client.ReadMessage(); err != nil {
+					b.Fatalf("read: %v", err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(bulkBytes.Load())/b.Elapsed().Seconds()/1e6, "bulk-MB/s")
+
+			close(stop)
+			bulkClient.Close()
+			bulkServer.Close()
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Sequenced frames. With sequenced true in START, every binary frame of the
+// upload starts with a 4-byte big-endian sequence number, counting from 0,
+// ahead of any checksum prefix. Frames that arrive early are held until the
+// gap before them is filled, up to the server's reorder window; anything else
+// out of order fails the stream instead of corrupting it.
+const (
+	FrameSequenceHeaderSize = 4
+
+	// ErrCodeOutOfOrder fails a stream whose frames could not be put back in order
+	ErrCodeOutOfOrder = "OUT_OF_ORDER"
+)
+
+// errDuplicateFrame is a frame already applied or already held, e.g. a
+// client resend; ignored when frame dedup is on
+var errDuplicateFrame = errors.New("duplicate frame")
+
+// frameSequencer holds one upload's early frames until they can be applied
+// in send order; read loop only. The stream records how far it has applied.
+type frameSequencer struct {
+	held   map[uint32][]byte // Early frames by sequence number, without their prefix
+	window int               // Most frames held at once; 0 accepts only in-order frames
+}
+
+func newFrameSequencer(window int) *frameSequencer {
+	return &frameSequencer{held: make(map[uint32][]byte), window: window}
+}
+
+// accept takes one sequenced frame, given the sequence number the stream
+// expects next, and returns the frames (prefix removed) now ready in order
+// along with the new expected number; no frames while a gap remains
+func (s *frameSequencer) accept(frame []byte, next uint32) ([][]byte, uint32, error) {
+	if len(frame) <= FrameSequenceHeaderSize {
+		return nil, next, fmt.Errorf("frame of %d bytes is too short for a sequenced chunk", len(frame))
+	}
+	seq := binary.BigEndian.Uint32(frame[:FrameSequenceHeaderSize])
+	payload := frame[FrameSequenceHeaderSize:]
+
+	switch {
+	case seq < next:
+		return nil, next, fmt.Errorf("%w: frame %d was already applied (expected frame %d)", errDuplicateFrame, seq, next)
+	case seq > next:
+		if _, ok := s.held[seq]; ok {
+			return nil, next, fmt.Errorf("%w: frame %d is already waiting for frame %d", errDuplicateFrame, seq, next)
+		}
+		if len(s.held) >= s.window {
+			return nil, next, fmt.Errorf("frame %d arrived while frame %d is still missing (reorder window %d frames)", seq, next, s.window)
+		}
+		s.held[seq] = payload
+		return nil, next, nil
+	}
+
+	ready := [][]byte{payload}
+	next++
+	for {
+		held, ok := s.held[next]
+		if !ok {
+			return ready, next, nil
+		}
+		delete(s.held, next)
+		ready = append(ready, held)
+		next++
+	}
+}
+
+// waiting reports whether later frames are held while one is missing, so
+// STOP can refuse a stream with a gap
+func (s *frameSequencer) waiting() bool {
+	return len(s.held) > 0
+}
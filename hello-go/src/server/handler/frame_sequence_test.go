@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// sequenced prefixes payload with its sequence number
+func sequenced(seq uint32, payload []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, seq)
+	return append(frame, payload...)
+}
+
+// shuffledFrames splits data into frames of size bytes, numbered in order,
+// and shuffles them so that no frame arrives more than window places early
+func shuffledFrames(data []byte, size, window int, seed int64) [][]byte {
+	var frames [][]byte
+	for seq := 0; seq*size < len(data); seq++ {
+		frames = append(frames, sequenced(uint32(seq), data[seq*size:min((seq+1)*size, len(data))]))
+	}
+	random := rand.New(rand.NewSource(seed))
+	for start := 0; start < len(frames); start += window + 1 {
+		group := frames[start:min(start+window+1, len(frames))]
+		random.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+	}
+	return frames
+}
+
+func TestFrameSequencerReordersWithinWindow(t *testing.T) {
+	data := testPayload(1248, 5000)
+	for _, window := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("window=%d", window), func(t *testing.T) {
+			sequencer := newFrameSequencer(window)
+			var got []byte
+			next := uint32(0)
+			for _, frame := range shuffledFrames(data, 100, window, int64(window)) {
+				ready, n, err := sequencer.accept(frame, next)
+				if err != nil {
+					t.Fatalf("accept: %v", err)
+				}
+				for _, payload := range ready {
+					got = append(got, payload...)
+				}
+				next = n
+			}
+			if !bytes.Equal(got, data) || sequencer.waiting() {
+				t.Fatalf("reassembled %d bytes (waiting %v), want the %d sent in order", len(got), sequencer.waiting(), len(data))
+			}
+		})
+	}
+}
+
+func TestFrameSequencerRefusesBeyondWindow(t *testing.T) {
+	sequencer := newFrameSequencer(2)
+	for _, seq := range []uint32{1, 2} {
+		if ready, _, err := sequencer.accept(sequenced(seq, []byte("early")), 0); err != nil || ready != nil {
+			t.Fatalf("frame %d: ready %d frames, %v; want it held", seq, len(ready), err)
+		}
+	}
+	if _, _, err := sequencer.accept(sequenced(3, []byte("too early")), 0); err == nil {
+		t.Fatal("a third early frame was held with a window of 2")
+	}
+	if !sequencer.waiting() {
+		t.Fatal("sequencer with held frames is not waiting")
+	}
+
+	inOrder := newFrameSequencer(0)
+	if _, _, err := inOrder.accept(sequenced(1, []byte("early")), 0); err == nil {
+		t.Fatal("an early frame was held with a window of 0")
+	}
+	if _, _, err := inOrder.accept([]byte{0, 0, 0, 0}, 0); err == nil {
+		t.Fatal("a frame with no payload was accepted")
+	}
+}
+
+func TestShuffledSequencedUpload(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetReorderWindow(8)
+	client := s.dial(t)
+	data := testPayload(1248, 64*1024)
+
+	client.send(WebSocketMessage{Type: "START", StreamId: "shuffled", Sequenced: true})
+	client.expect("STARTED")
+	for _, frame := range shuffledFrames(data, 1000, 8, 1) {
+		client.sendBinary(frame)
+	}
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "shuffled"})
+	client.expect("STOPPED")
+	if got := s.streamManager.ReadChunk("shuffled", 0, len(data)); !bytes.Equal(got, data) {
+		t.Fatalf("stored %d bytes that differ from the %d sent", len(got), len(data))
+	}
+}
+
+func TestSequencedUploadWithGap(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetReorderWindow(8)
+	client := s.dial(t)
+
+	// Frame 1 never arrives
+	client.send(WebSocketMessage{Type: "START", StreamId: "gap", Sequenced: true})
+	client.expect("STARTED")
+	client.sendBinary(sequenced(0, []byte("first")))
+	client.sendBinary(sequenced(2, []byte("third")))
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "gap"})
+	client.expectError(ErrCodeOutOfOrder)
+
+	// Beyond the window the stream fails at once
+	client.send(WebSocketMessage{Type: "START", StreamId: "too-far", Sequenced: true})
+	client.expect("STARTED")
+	for seq := uint32(1); seq <= 9; seq++ {
+		client.sendBinary(sequenced(seq, []byte("early")))
+	}
+	client.expectError(ErrCodeOutOfOrder)
+}
+
+func TestFrameSequencerReportsDuplicates(t *testing.T) {
+	sequencer := newFrameSequencer(4)
+	if _, _, err := sequencer.accept(sequenced(2, []byte("held")), 1); err != nil {
+		t.Fatalf("hold frame 2: %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		seq  uint32
+	}{{"already applied", 0}, {"already held", 2}} {
+		if _, next, err := sequencer.accept(sequenced(tt.seq, []byte("again")), 1); !errors.Is(err, errDuplicateFrame) || next != 1 {
+			t.Errorf("%s: next %d, %v; want errDuplicateFrame and next 1", tt.name, next, err)
+		}
+	}
+
+	// The held copy, not the duplicate, is applied once the gap closes
+	ready, next, err := sequencer.accept(sequenced(1, []byte("gap")), 1)
+	if err != nil || next != 3 || len(ready) != 2 || string(ready[1]) != "held" {
+		t.Fatalf("closing the gap: %q, next %d, %v", ready, next, err)
+	}
+}
+
+func TestDuplicateFrames(t *testing.T) {
+	frames := [][]byte{
+		sequenced(0, []byte("zero ")),
+		sequenced(2, []byte("two ")),
+		sequenced(0, []byte("zero again ")), // Resend of an applied frame
+		sequenced(2, []byte("two again ")),  // Resend of a held frame
+		sequenced(1, []byte("one ")),
+		sequenced(3, []byte("three")),
+	}
+
+	t.Run("dedup", func(t *testing.T) {
+		s := newTestServer(t)
+		s.handler.SetReorderWindow(4)
+		s.handler.SetDedupFrames(true)
+		client := s.dial(t)
+
+		client.send(WebSocketMessage{Type: "START", StreamId: "dedup-frames", Sequenced: true})
+		client.expect("STARTED")
+		for _, frame := range frames {
+			client.sendBinary(frame)
+		}
+		client.send(WebSocketMessage{Type: "STOP", StreamId: "dedup-frames"})
+		client.expect("STOPPED")
+		if got := s.streamManager.ReadChunk("dedup-frames", 0, 100); string(got) != "zero one two three" {
+			t.Fatalf("stored %q, want each frame once in order", got)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		s := newTestServer(t)
+		s.handler.SetReorderWindow(4)
+		client := s.dial(t)
+
+		client.send(WebSocketMessage{Type: "START", StreamId: "strict-frames", Sequenced: true})
+		client.expect("STARTED")
+		for _, frame := range frames[:3] {
+			client.sendBinary(frame)
+		}
+		refused := client.expectError(ErrCodeOutOfOrder)
+		if !strings.Contains(refused.Message, "already applied") {
+			t.Fatalf("message %q does not name the duplicate", refused.Message)
+		}
+	})
+}
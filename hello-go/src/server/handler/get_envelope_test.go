@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+)
+
+// getEnveloped sends a GET asking for a DATA envelope and returns the
+// envelope and the binary frame that follows it
+func (c *testClient) getEnveloped(streamID string, offset int64, length int) (*WebSocketMessage, []byte) {
+	c.t.Helper()
+	c.send(WebSocketMessage{Type: "GET", StreamId: streamID, Offset: &offset, Length: &length, Envelope: true})
+	envelope := c.expect("DATA")
+	data := c.expectBinary()
+	if envelope.Length == nil || *envelope.Length != len(data) {
+		c.t.Fatalf("envelope states %v bytes, frame has %d", envelope.Length, len(data))
+	}
+	if envelope.Offset == nil || *envelope.Offset != offset {
+		c.t.Fatalf("envelope for offset %v, want %d", envelope.Offset, offset)
+	}
+	return envelope, data
+}
+
+func TestGetEnvelopeNearEOF(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	data := testPayload(1, 10000)
+	c.upload("envelope-eof", data)
+
+	for _, tc := range []struct {
+		name   string
+		offset int64
+		length int
+		want   int
+		final  bool
+	}{
+		{"middle", 0, 4096, 4096, false},
+		{"ends exactly at EOF", 10000 - 4096, 4096, 4096, true},
+		{"runs past EOF", 8000, 4096, 2000, true},
+		{"last byte", 9999, 4096, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			envelope, got := c.getEnveloped("envelope-eof", tc.offset, tc.length)
+			if len(got) != tc.want || envelope.Final != tc.final {
+				t.Errorf("got %d bytes, final=%v; want %d bytes, final=%v", len(got), envelope.Final, tc.want, tc.final)
+			}
+			if !bytes.Equal(got, data[tc.offset:tc.offset+int64(len(got))]) {
+				t.Error("frame does not hold the requested range")
+			}
+		})
+	}
+
+	// Past the end the GET is refused rather than answered with an empty final chunk
+	offset, length := int64(10000), 4096
+	c.send(WebSocketMessage{Type: "GET", StreamId: "envelope-eof", Offset: &offset, Length: &length, Envelope: true})
+	c.expectError(ErrCodeOutOfRange)
+}
+
+func TestGetEnvelopeNotFinalWhileUploading(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	reader := s.dial(t)
+	data := testPayload(2, 8192)
+
+	uploader.start("envelope-live")
+	uploader.sendBinary(data)
+	uploader.status("envelope-live") // Answered once the frame before it is written
+
+	// Reaching the end of the data written so far is not the end of the stream
+	envelope, got := reader.getEnveloped("envelope-live", 4096, 8192)
+	if len(got) != 4096 || envelope.Final {
+		t.Errorf("got %d bytes, final=%v; want 4096 bytes, not final", len(got), envelope.Final)
+	}
+}
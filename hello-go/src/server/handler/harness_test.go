@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	"github.com/gorilla/websocket"
+)
+
+// newConnPair returns the server and client ends of one WebSocket connection
+func newConnPair(t testing.TB) (server *websocket.Conn, client *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(httpServer.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+// testServer runs a message handler behind the same read loop as the
+// WebSocket server, with its own stream manager
+type testServer struct {
+	handler       *WebSocketMessageHandler
+	streamManager *memory.StreamManager
+	clients       map[*websocket.Conn]string
+	clientsMutex  *sync.RWMutex
+	url           string
+	disconnected  chan struct{} // Receives once per connection whose read loop ended
+}
+
+func newTestServer(t testing.TB) *testServer {
+	t.Helper()
+	s := &testServer{
+		streamManager: memory.NewStreamManager(t.TempDir()),
+		clients:       make(map[*websocket.Conn]string),
+		clientsMutex:  &sync.RWMutex{},
+		disconnected:  make(chan struct{}, 64),
+	}
+	s.handler = NewWebSocketMessageHandler(s.streamManager, memory.GetMemoryPoolManager(65536, 10), s.clients, s.clientsMutex)
+
+	upgrader := websocket.Upgrader{ReadBufferSize: 65536, WriteBufferSize: 65536}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(conn)
+	}))
+	t.Cleanup(httpServer.Close)
+	s.url = "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	return s
+}
+
+// serve mirrors AudioWebSocketServer.handleConnection
+func (s *testServer) serve(conn *websocket.Conn) {
+	s.handler.HandleConnect(conn)
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.handler.RecordRead(conn)
+		if messageType == websocket.BinaryMessage {
+			s.clientsMutex.RLock()
+			streamID := s.clients[conn]
+			s.clientsMutex.RUnlock()
+			s.handler.HandleBinaryMessage(conn, message, streamID)
+		} else {
+			s.handler.HandleTextMessage(conn, message)
+		}
+	}
+	s.handler.HandleDisconnect(conn)
+	s.disconnected <- struct{}{}
+}
+
+// waitDisconnect waits until the server has finished handling one disconnect
+func (s *testServer) waitDisconnect(t testing.TB) {
+	t.Helper()
+	select {
+	case <-s.disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not handle the disconnect")
+	}
+}
+
+// testClient is a raw protocol client for driving the handler
+type testClient struct {
+	t    testing.TB
+	conn *websocket.Conn
+}
+
+func (s *testServer) dial(t testing.TB) *testClient {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+// send sends a control message
+func (c *testClient) send(msg WebSocketMessage) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("send %s: %v", msg.Type, err)
+	}
+}
+
+// sendBinary sends one binary frame
+func (c *testClient) sendBinary(data []byte) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		c.t.Fatalf("send binary: %v", err)
+	}
+}
+
+// next reads the next message: a control message, or the payload of a binary frame
+func (c *testClient) next() (*WebSocketMessage, []byte) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	if messageType == websocket.BinaryMessage {
+		return nil, data
+	}
+	msg := &WebSocketMessage{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		c.t.Fatalf("decode %q: %v", data, err)
+	}
+	return msg, nil
+}
+
+// expect reads the next message and fails unless it is a control message of type want
+func (c *testClient) expect(want string) *WebSocketMessage {
+	c.t.Helper()
+	msg, data := c.next()
+	if msg == nil {
+		c.t.Fatalf("got a %d-byte binary frame, want %s", len(data), want)
+	}
+	if msg.Type != want {
+		c.t.Fatalf("got %s (%s %s), want %s", msg.Type, msg.Code, msg.Message, want)
+	}
+	return msg
+}
+
+// expectBinary reads the next message and fails unless it is a binary frame
+func (c *testClient) expectBinary() []byte {
+	c.t.Helper()
+	msg, data := c.next()
+	if msg != nil {
+		c.t.Fatalf("got %s (%s %s), want a binary frame", msg.Type, msg.Code, msg.Message)
+	}
+	return data
+}
+
+// expectError reads the next message and fails unless it is an ERROR with code
+func (c *testClient) expectError(code string) *WebSocketMessage {
+	c.t.Helper()
+	msg := c.expect("ERROR")
+	if msg.Code != code {
+		c.t.Fatalf("ERROR code %q (%s), want %q", msg.Code, msg.Message, code)
+	}
+	return msg
+}
+
+// start sends START for streamID and expects STARTED
+func (c *testClient) start(streamID string) {
+	c.t.Helper()
+	c.send(WebSocketMessage{Type: "START", StreamId: streamID})
+	c.expect("STARTED")
+}
+
+// upload starts streamID, sends data as one frame and stops it, returning STOPPED
+func (c *testClient) upload(streamID string, data []byte) *WebSocketMessage {
+	c.t.Helper()
+	c.start(streamID)
+	c.sendBinary(data)
+	c.send(WebSocketMessage{Type: "STOP", StreamId: streamID})
+	return c.expect("STOPPED")
+}
+
+// get sends a GET for length bytes at offset
+func (c *testClient) get(streamID string, offset int64, length int) {
+	c.t.Helper()
+	c.send(WebSocketMessage{Type: "GET", StreamId: streamID, Offset: &offset, Length: &length})
+}
+
+// status returns the server's STATUS for streamID
+func (c *testClient) status(streamID string) *WebSocketMessage {
+	c.t.Helper()
+	c.send(WebSocketMessage{Type: "STATUS", StreamId: streamID})
+	return c.expect("STATUS")
+}
+
+// testPayload returns size deterministic bytes distinct for each seed
+func testPayload(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+// RecordRead marks conn as active; the read loop calls it for every message
+func (h *WebSocketMessageHandler) RecordRead(conn *websocket.Conn) {
+	if state := h.connectionState(conn); state != nil {
+		state.lastRead.Store(time.Now().UnixNano())
+	}
+}
+
+// ReapedConnections returns how many connections the idle reaper has closed
+func (h *WebSocketMessageHandler) ReapedConnections() int64 {
+	return h.reaped.Load()
+}
+
+// StartIdleReaper checks all connections every interval and closes those
+// that sent nothing for longer than idle. Closing the connection ends its
+// read loop, which unregisters it and marks an unfinished upload incomplete.
+// A single GET answered for longer than idle also counts as idle, so idle
+// must exceed the longest expected response.
+func (h *WebSocketMessageHandler) StartIdleReaper(interval time.Duration, idle time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			h.reapIdle(idle)
+		}
+	}()
+}
+
+// reapIdle closes every connection idle for longer than idle
+func (h *WebSocketMessageHandler) reapIdle(idle time.Duration) {
+	cutoff := time.Now().Add(-idle).UnixNano()
+
+	h.clientsMutex.RLock()
+	var stale []*websocket.Conn
+	for conn, state := range h.connections {
+		if state.lastRead.Load() < cutoff {
+			stale = append(stale, conn)
+		}
+	}
+	h.clientsMutex.RUnlock()
+
+	for _, conn := range stale {
+		h.reaped.Add(1)
+		logger.Warn(fmt.Sprintf("Closing connection %s: idle for more than %v", conn.RemoteAddr(), idle))
+		conn.Close()
+	}
+}
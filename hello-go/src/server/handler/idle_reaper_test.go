@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+func TestIdleReaperClosesStalledConnection(t *testing.T) {
+	s := newTestServer(t)
+	stalled := s.dial(t)
+	active := s.dial(t)
+
+	// The stalled client starts an upload, sends one frame and goes quiet
+	stalled.start("reaper-stalled")
+	stalled.sendBinary(testPayload(1, 4096))
+	active.status("reaper-stalled")
+
+	s.handler.StartIdleReaper(10*time.Millisecond, 150*time.Millisecond)
+
+	// The active client keeps talking past the idle threshold
+	deadline := time.Now().Add(400 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		active.status("reaper-stalled")
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.waitDisconnect(t)
+
+	if got := s.handler.ReapedConnections(); got != 1 {
+		t.Errorf("ReapedConnections = %d, want 1", got)
+	}
+	stalled.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := stalled.conn.ReadMessage(); err == nil {
+		t.Error("stalled connection is still open")
+	}
+	if info, _ := s.streamManager.GetStreamInfo("reaper-stalled"); info.Status != memory.StatusIncomplete {
+		t.Errorf("stalled upload is %s, want INCOMPLETE", info.Status)
+	}
+	if msg := active.status("reaper-stalled"); msg.Status != string(memory.StatusIncomplete) {
+		t.Errorf("active client sees %s, want INCOMPLETE", msg.Status)
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTinyFramesAreCoalesced(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMinChunkSize(1024)
+	c := s.dial(t)
+	data := testPayload(1, 3000)
+
+	c.start("tiny-frames")
+	for i := range data {
+		c.sendBinary(data[i : i+1])
+	}
+	// Only whole minimum-size chunks are written before STOP
+	if msg := c.status("tiny-frames"); msg.Size == nil || *msg.Size != 2*1024 {
+		t.Errorf("STATUS size %v before STOP, want the 2048 bytes of two full chunks", msg.Size)
+	}
+	c.send(WebSocketMessage{Type: "STOP", StreamId: "tiny-frames"})
+	c.expect("STOPPED")
+
+	info, _ := s.streamManager.GetStreamInfo("tiny-frames")
+	if info.WriteCount != 3 {
+		t.Errorf("%d writes for 3000 one-byte frames, want 3 (two full chunks and the final short one)", info.WriteCount)
+	}
+	c.get("tiny-frames", 0, len(data))
+	if got := c.expectBinary(); !bytes.Equal(got, data) {
+		t.Error("coalesced stream differs from the frames sent")
+	}
+}
+
+func TestLargeFrameAfterTinyOnesKeepsOrder(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMinChunkSize(1024)
+	c := s.dial(t)
+	data := testPayload(2, 10+4096+4096)
+
+	// Frames at or above the minimum are written as they are, unless short
+	// ones are still buffered ahead of them
+	c.start("mixed-frames")
+	c.sendBinary(data[:10])
+	c.sendBinary(data[10 : 10+4096])
+	c.sendBinary(data[10+4096:])
+	c.send(WebSocketMessage{Type: "STOP", StreamId: "mixed-frames"})
+	c.expect("STOPPED")
+
+	info, _ := s.streamManager.GetStreamInfo("mixed-frames")
+	if info.WriteCount != 2 {
+		t.Errorf("%d writes, want 2: the short frame with the next, then the last as it is", info.WriteCount)
+	}
+	c.get("mixed-frames", 0, len(data))
+	if got := c.expectBinary(); !bytes.Equal(got, data) {
+		t.Error("stream differs from the frames sent")
+	}
+}
+
+func TestNoMinChunkSizeWritesEveryFrame(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+
+	c.start("no-minimum")
+	for i := 0; i < 10; i++ {
+		c.sendBinary([]byte{byte(i)})
+	}
+	c.send(WebSocketMessage{Type: "STOP", StreamId: "no-minimum"})
+	c.expect("STOPPED")
+
+	if info, _ := s.streamManager.GetStreamInfo("no-minimum"); info.WriteCount != 10 || info.Size != 10 {
+		t.Errorf("%d writes of %d bytes, want every one-byte frame written", info.WriteCount, info.Size)
+	}
+}
+
+func TestStartFlushesPreviousStreamsShortFrames(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMinChunkSize(1024)
+	c := s.dial(t)
+	data := testPayload(3, 2000)
+
+	// A new START without STOP: the bytes buffered for a stay with a
+	c.start("pending-a")
+	c.sendBinary([]byte("AAAA"))
+	c.start("pending-b")
+	c.sendBinary(data)
+	c.send(WebSocketMessage{Type: "STOP", StreamId: "pending-b"})
+	c.expect("STOPPED")
+
+	if info, _ := s.streamManager.GetStreamInfo("pending-a"); info.Size != 4 {
+		t.Errorf("stream a holds %d bytes, want its 4 buffered bytes", info.Size)
+	}
+	c.get("pending-b", 0, 4096)
+	if got := c.expectBinary(); !bytes.Equal(got, data) {
+		t.Errorf("stream b holds %d bytes starting %q, want only its own 2000", len(got), got[:min(4, len(got))])
+	}
+}
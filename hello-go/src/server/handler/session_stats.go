@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// sessionCounters accumulate over the handler's lifetime
+type sessionCounters struct {
+	streamsStarted  atomic.Int64 // START accepted
+	getsServed      atomic.Int64 // GETs answered with data
+	bytesWritten    atomic.Int64 // Upload bytes written to streams
+	bytesRead       atomic.Int64 // Bytes sent in GET responses
+	peakConnections atomic.Int64
+}
+
+// SessionStats summarizes the server's activity since it started
+type SessionStats struct {
+	Uptime          time.Duration
+	StreamsStarted  int64
+	GetsServed      int64
+	BytesWritten    int64
+	BytesRead       int64
+	PeakConnections int64
+	StreamsDeleted  int64 // Removed from the registry, e.g. by cleanup
+	StreamsActive   int   // Still registered
+}
+
+// recordConnections raises the peak connection count to current if higher
+func (c *sessionCounters) recordConnections(current int) {
+	for {
+		peak := c.peakConnections.Load()
+		if int64(current) <= peak || c.peakConnections.CompareAndSwap(peak, int64(current)) {
+			return
+		}
+	}
+}
+
+// SessionStats returns the counters collected since the handler was created
+func (h *WebSocketMessageHandler) SessionStats() SessionStats {
+	return SessionStats{
+		Uptime:          time.Since(h.startedAt),
+		StreamsStarted:  h.session.streamsStarted.Load(),
+		GetsServed:      h.session.getsServed.Load(),
+		BytesWritten:    h.session.bytesWritten.Load(),
+		BytesRead:       h.session.bytesRead.Load(),
+		PeakConnections: h.session.peakConnections.Load(),
+		StreamsDeleted:  h.streamManager.DeletedStreams(),
+		StreamsActive:   len(h.streamManager.ListActiveStreams()),
+	}
+}
+
+// LogSessionSummary logs SessionStats, e.g. on graceful shutdown
+func (h *WebSocketMessageHandler) LogSessionSummary() {
+	stats := h.SessionStats()
+	logger.Phase("Session Summary")
+	logger.Info(fmt.Sprintf("Uptime: %v", stats.Uptime.Round(time.Second)))
+	logger.Info(fmt.Sprintf("Streams started: %d (%d still registered, %d cleaned up)",
+		stats.StreamsStarted, stats.StreamsActive, stats.StreamsDeleted))
+	logger.Info(fmt.Sprintf("GETs served: %d", stats.GetsServed))
+	logger.Info(fmt.Sprintf("Bytes written: %d", stats.BytesWritten))
+	logger.Info(fmt.Sprintf("Bytes read: %d", stats.BytesRead))
+	logger.Info(fmt.Sprintf("Peak concurrent connections: %d", stats.PeakConnections))
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+func TestSessionSummary(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	reader := s.dial(t)
+
+	uploader.upload("session-a", []byte("0123456789"))
+	uploader.upload("session-b", []byte("abcde"))
+	// Two idle observers; a STATUS round trip makes sure each one is registered.
+	s.dial(t).status("session-a")
+	s.dial(t).status("session-a")
+	reader.get("session-a", 0, 10)
+	reader.expectBinary()
+	reader.get("session-b", 1, 3)
+	reader.expectBinary()
+	reader.send(WebSocketMessage{Type: "DELETE", StreamId: "session-b"})
+	reader.expect("DELETED")
+
+	var captured bytes.Buffer
+	logger.SetOutput(&captured)
+	s.handler.LogSessionSummary()
+	logger.SetOutput(os.Stdout)
+
+	summary := captured.String()
+	for _, want := range []string{
+		"=== Session Summary ===",
+		"Streams started: 2 (1 still registered, 1 cleaned up)",
+		"GETs served: 2",
+		"Bytes written: 15",
+		"Bytes read: 13",
+		"Peak concurrent connections: 4",
+		"Uptime: ",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary lacks %q:\n%s", want, summary)
+		}
+	}
+}
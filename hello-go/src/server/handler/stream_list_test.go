@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// list sends LIST and returns the stream IDs of the page and the total
+func (c *testClient) list(msg WebSocketMessage) ([]string, int) {
+	c.t.Helper()
+	msg.Type = "LIST"
+	c.send(msg)
+	result := c.expect("LIST_RESULT")
+	var ids []string
+	for _, summary := range result.Streams {
+		ids = append(ids, summary.StreamId)
+	}
+	if result.Total == nil {
+		c.t.Fatal("LIST_RESULT has no total")
+	}
+	return ids, *result.Total
+}
+
+func TestListPaging(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	for _, id := range []string{"list-c", "list-a", "list-d", "list-b"} {
+		c.upload(id, []byte(id))
+	}
+
+	offset := func(n int64) *int64 { return &n }
+	limit := func(n int) *int { return &n }
+	for _, tc := range []struct {
+		name string
+		msg  WebSocketMessage
+		want string
+	}{
+		{"all", WebSocketMessage{}, "list-a list-b list-c list-d"},
+		{"first page", WebSocketMessage{Limit: limit(2)}, "list-a list-b"},
+		{"second page", WebSocketMessage{Offset: offset(2), Limit: limit(2)}, "list-c list-d"},
+		{"short last page", WebSocketMessage{Offset: offset(3), Limit: limit(2)}, "list-d"},
+		{"past the end", WebSocketMessage{Offset: offset(10), Limit: limit(2)}, ""},
+		{"zero limit", WebSocketMessage{Limit: limit(0)}, ""},
+		{"huge limit", WebSocketMessage{Offset: offset(1), Limit: limit(math.MaxInt)}, "list-b list-c list-d"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ids, total := c.list(tc.msg)
+			if got := strings.Join(ids, " "); got != tc.want || total != 4 {
+				t.Errorf("page %q of %d, want %q of 4", got, total, tc.want)
+			}
+		})
+	}
+}
+
+func TestListSorting(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	c.upload("sort-b", []byte("three"))
+	c.upload("sort-c", []byte("1"))
+	c.upload("sort-a", []byte("twenty-two"))
+
+	for sortBy, want := range map[string]string{
+		"":          "sort-a sort-b sort-c",
+		"streamId":  "sort-a sort-b sort-c",
+		"size":      "sort-c sort-b sort-a",
+		"createdAt": "sort-b sort-c sort-a",
+	} {
+		if ids, _ := c.list(WebSocketMessage{SortBy: sortBy}); strings.Join(ids, " ") != want {
+			t.Errorf("sortBy %q lists %v, want %s", sortBy, ids, want)
+		}
+	}
+}
+
+func TestListRejectsBadRequests(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	c.upload("list-only", []byte("data"))
+
+	negativeOffset, negativeLimit := int64(-1), -1
+	for name, msg := range map[string]WebSocketMessage{
+		"Unknown sortBy": {Type: "LIST", SortBy: "color"},
+		"Invalid offset": {Type: "LIST", Offset: &negativeOffset},
+		"Invalid limit":  {Type: "LIST", Limit: &negativeLimit},
+	} {
+		c.send(msg)
+		if refused := c.expect("ERROR"); !strings.Contains(refused.Message, name) {
+			t.Errorf("refused with %q, want %q", refused.Message, name)
+		}
+	}
+
+	// The connection is still usable
+	if ids, total := c.list(WebSocketMessage{}); total != 1 || len(ids) != 1 {
+		t.Errorf("LIST after the refusals returned %v of %d", ids, total)
+	}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"github.com/gorilla/websocket"
+)
+
+// watchProgressStep is how many bytes a stream must grow by before its
+// watchers get another PROGRESS update
+const watchProgressStep = 1024 * 1024
+
+// streamWatchers tracks which connections WATCH which streams
+type streamWatchers struct {
+	watchers map[string]map[*websocket.Conn]bool // Stream ID to watching connections
+	notified map[string]int64                    // Stream ID to size in the last PROGRESS
+	mutex    sync.Mutex
+}
+
+func newStreamWatchers() *streamWatchers {
+	return &streamWatchers{
+		watchers: make(map[string]map[*websocket.Conn]bool),
+		notified: make(map[string]int64),
+	}
+}
+
+func (w *streamWatchers) add(streamID string, conn *websocket.Conn) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.watchers[streamID] == nil {
+		w.watchers[streamID] = make(map[*websocket.Conn]bool)
+	}
+	w.watchers[streamID][conn] = true
+}
+
+func (w *streamWatchers) remove(streamID string, conn *websocket.Conn) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.removeLocked(streamID, conn)
+}
+
+func (w *streamWatchers) removeLocked(streamID string, conn *websocket.Conn) {
+	delete(w.watchers[streamID], conn)
+	if len(w.watchers[streamID]) == 0 {
+		delete(w.watchers, streamID)
+		delete(w.notified, streamID)
+	}
+}
+
+// removeConnection drops conn from every stream it watches
+func (w *streamWatchers) removeConnection(conn *websocket.Conn) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for streamID, conns := range w.watchers {
+		if conns[conn] {
+			w.removeLocked(streamID, conn)
+		}
+	}
+}
+
+// due returns the connections to notify of streamID having size bytes: all
+// of them once size grew by watchProgressStep since the last update, or when
+// force is set; nil otherwise
+func (w *streamWatchers) due(streamID string, size int64, force bool) []*websocket.Conn {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	conns := w.watchers[streamID]
+	if len(conns) == 0 || (!force && size-w.notified[streamID] < watchProgressStep) {
+		return nil
+	}
+	w.notified[streamID] = size
+	due := make([]*websocket.Conn, 0, len(conns))
+	for conn := range conns {
+		due = append(due, conn)
+	}
+	return due
+}
+
+// handleWatch handles WATCH message (subscribe to another client's upload).
+// The reply is a PROGRESS snapshot; further ones follow as the stream grows
+// and when its status changes.
+func (h *WebSocketMessageHandler) handleWatch(conn *websocket.Conn, data *WebSocketMessage) error {
+	streamID := data.StreamId
+	if streamID == "" {
+		return h.reject(conn, "Missing streamId")
+	}
+
+	info, ok := h.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return h.reject(conn, fmt.Sprintf("Stream not found: %s", streamID))
+	}
+	h.watchers.add(streamID, conn)
+	logger.Debug(fmt.Sprintf("Connection %s watching stream %s", conn.RemoteAddr(), streamID))
+	return h.sendJSON(conn, NewProgressMessage(info))
+}
+
+// handleUnwatch handles UNWATCH message; unwatching a stream that is not
+// watched succeeds too
+func (h *WebSocketMessageHandler) handleUnwatch(conn *websocket.Conn, data *WebSocketMessage) error {
+	streamID := data.StreamId
+	if streamID == "" {
+		return h.reject(conn, "Missing streamId")
+	}
+
+	h.watchers.remove(streamID, conn)
+	return h.sendJSON(conn, &WebSocketMessage{Type: "UNWATCHED", StreamId: streamID})
+}
+
+// notifyWatchers sends PROGRESS to the watchers of streamID once it grew
+// enough since the last update, or always with force (status changes)
+func (h *WebSocketMessageHandler) notifyWatchers(streamID string, force bool) {
+	info, ok := h.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return
+	}
+	for _, conn := range h.watchers.due(streamID, info.Size, force) {
+		if err := h.sendJSON(conn, NewProgressMessage(info)); err != nil {
+			logger.Debug(fmt.Sprintf("Dropping watcher of stream %s: %v", streamID, err))
+			h.watchers.remove(streamID, conn)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+// expectProgress reads a PROGRESS for streamID and returns its size and status
+func (c *testClient) expectProgress(streamID string) (int64, string) {
+	c.t.Helper()
+	progress := c.expect("PROGRESS")
+	if progress.StreamId != streamID || progress.Size == nil {
+		c.t.Fatalf("PROGRESS %+v, want one for %s with a size", progress, streamID)
+	}
+	return *progress.Size, progress.Status
+}
+
+func TestWatchUpload(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	watcher := s.dial(t)
+
+	uploader.start("watched")
+	watcher.send(WebSocketMessage{Type: "WATCH", StreamId: "watched"})
+	if size, status := watcher.expectProgress("watched"); size != 0 || status != string(memory.StatusUploading) {
+		t.Fatalf("WATCH snapshot: %d bytes %s, want 0 bytes UPLOADING", size, status)
+	}
+
+	// One update per watchProgressStep of growth, not one per frame
+	frame := make([]byte, 64*1024)
+	for sent := 0; sent < watchProgressStep+len(frame); sent += len(frame) {
+		uploader.sendBinary(frame)
+	}
+	if size, _ := watcher.expectProgress("watched"); size < watchProgressStep {
+		t.Fatalf("progress update at %d bytes, want at least %d", size, watchProgressStep)
+	}
+
+	// The status change is sent at once
+	uploader.send(WebSocketMessage{Type: "STOP", StreamId: "watched"})
+	uploader.expect("STOPPED")
+	if size, status := watcher.expectProgress("watched"); size != int64(watchProgressStep+len(frame)) || status != string(memory.StatusReady) {
+		t.Fatalf("final progress: %d bytes %s, want %d bytes READY", size, status, watchProgressStep+len(frame))
+	}
+}
+
+func TestUnwatch(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	watcher := s.dial(t)
+
+	uploader.start("unwatched")
+	watcher.send(WebSocketMessage{Type: "WATCH", StreamId: "unwatched"})
+	watcher.expectProgress("unwatched")
+	watcher.send(WebSocketMessage{Type: "UNWATCH", StreamId: "unwatched"})
+	watcher.expect("UNWATCHED")
+
+	uploader.sendBinary(make([]byte, watchProgressStep+1))
+	uploader.send(WebSocketMessage{Type: "STOP", StreamId: "unwatched"})
+	uploader.expect("STOPPED")
+
+	// Nothing was queued for the watcher ahead of this reply
+	watcher.status("unwatched")
+
+	// Unwatching again, or a stream never watched, succeeds
+	watcher.send(WebSocketMessage{Type: "UNWATCH", StreamId: "unwatched"})
+	watcher.expect("UNWATCHED")
+}
+
+func TestWatchMissingStream(t *testing.T) {
+	s := newTestServer(t)
+	watcher := s.dial(t)
+	watcher.send(WebSocketMessage{Type: "WATCH", StreamId: "missing"})
+	watcher.expect("ERROR")
+}
+
+func TestWatcherDisconnectIsForgotten(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	watcher := s.dial(t)
+
+	uploader.start("abandoned-watch")
+	watcher.send(WebSocketMessage{Type: "WATCH", StreamId: "abandoned-watch"})
+	watcher.expectProgress("abandoned-watch")
+	watcher.conn.Close()
+	s.waitDisconnect(t)
+
+	s.handler.watchers.mutex.Lock()
+	watched := len(s.handler.watchers.watchers)
+	s.handler.watchers.mutex.Unlock()
+	if watched != 0 {
+		t.Fatalf("%d streams still watched after the watcher disconnected", watched)
+	}
+	uploader.send(WebSocketMessage{Type: "STOP", StreamId: "abandoned-watch"})
+	uploader.expect("STOPPED")
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
+)
+
+// errUploadIncomplete ends the span of an upload whose client went away
+// before STOP
+var errUploadIncomplete = errors.New("upload incomplete")
+
+// uploadSpans holds the OpenTelemetry span of each upload in progress, from
+// START until the stream is finalized or abandoned. It stays empty while
+// tracing is disabled.
+type uploadSpans struct {
+	spans map[string]*telemetry.Span // Stream ID to its upload span
+	mutex sync.Mutex
+}
+
+func newUploadSpans() *uploadSpans {
+	return &uploadSpans{spans: make(map[string]*telemetry.Span)}
+}
+
+func (u *uploadSpans) start(streamID string) {
+	_, span := telemetry.Start(context.Background(), "audio_server.upload")
+	if span == nil {
+		return
+	}
+	span.SetStreamID(streamID)
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.spans[streamID] = span
+}
+
+func (u *uploadSpans) take(streamID string) *telemetry.Span {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	span := u.spans[streamID]
+	delete(u.spans, streamID)
+	return span
+}
+
+// endUploadSpan ends the upload span of streamID, if any, recording the bytes
+// the stream holds; err marks the upload failed
+func (h *WebSocketMessageHandler) endUploadSpan(streamID string, err error) {
+	span := h.uploadSpans.take(streamID)
+	if span == nil {
+		return
+	}
+	if info, ok := h.streamManager.GetStreamInfo(streamID); ok {
+		span.SetBytes(info.Size)
+	}
+	span.End(err)
+}
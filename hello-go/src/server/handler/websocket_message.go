@@ -1,5 +1,20 @@
 package handler
 
+import (
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+// Protocol version negotiation rules:
+//   - Clients send their version in START; an absent version is treated as MinProtocolVersion.
+//   - The server rejects START from clients newer than ProtocolVersion or older than MinProtocolVersion.
+//   - The server advertises ProtocolVersion in STARTED so clients can refuse incompatible servers.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
 // WebSocketMessage represents a WebSocket control message.
 // Used for JSON serialization/deserialization of all control messages.
 type WebSocketMessage struct {
@@ -8,6 +23,119 @@ type WebSocketMessage struct {
 	Offset   *int64 `json:"offset,omitempty"`
 	Length   *int   `json:"length,omitempty"`
 	Message  string `json:"message,omitempty"`
+	Code     string `json:"code,omitempty"` // Machine-readable ERROR reason
+	Version  int    `json:"version,omitempty"`
+	Size     *int64 `json:"size,omitempty"` // Declared total upload size in START
+
+	// LIMIT_EXCEEDED and BUSY errors: the limit that refused the request
+	LimitName  string `json:"limitName,omitempty"`
+	LimitValue *int64 `json:"limitValue,omitempty"`
+
+	ChunkChecksum string `json:"chunkChecksum,omitempty"` // START: per-chunk checksum framing, e.g. "crc32"
+	Sequenced     bool   `json:"sequenced,omitempty"`     // START: binary frames carry a sequence number prefix
+
+	// START: SHA-256 hex digest of the content about to be uploaded; if a
+	// READY stream already has it, the server answers ALREADY_EXISTS instead
+	// of STARTED and the client skips the upload
+	Sha256 string `json:"sha256,omitempty"`
+
+	// START: deflate level 1-9 for this stream's GET responses when
+	// per-message compression was negotiated; omitted uses the default
+	CompressionLevel *int `json:"compressionLevel,omitempty"`
+
+	// START: format to transcode the stream to on finalize; GET: read that
+	// transcoded variant instead of the stream as uploaded
+	TranscodeTo string `json:"transcodeTo,omitempty"`
+	Variant     string `json:"variant,omitempty"`
+
+	// GET envelope: requested with Envelope, answered with a DATA message
+	// stating Length and Final ahead of the binary frame
+	Envelope bool `json:"envelope,omitempty"`
+	Final    bool `json:"final,omitempty"`
+
+	// LIST request paging/sorting and response fields
+	Limit   *int            `json:"limit,omitempty"`
+	SortBy  string          `json:"sortBy,omitempty"`
+	Streams []StreamSummary `json:"streams,omitempty"`
+	Total   *int            `json:"total,omitempty"`
+
+	// HASHES request block size and response SHA-256 hex digests, one per block
+	BlockSize *int     `json:"blockSize,omitempty"`
+	Hashes    []string `json:"hashes,omitempty"`
+
+	Status string `json:"status,omitempty"` // STATUS and PROGRESS: stream status, e.g. READY
+
+	// STOPPED and STATUS: digest of the finalized stream and its algorithm, e.g. "sha256"
+	Digest          string `json:"digest,omitempty"`
+	DigestAlgorithm string `json:"digestAlgorithm,omitempty"`
+
+	Variants []VariantSummary `json:"variants,omitempty"` // STATUS: transcoded variants ready for GET
+
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Stats        *ServerStats  `json:"stats,omitempty"`
+}
+
+// Capabilities describes the features and limits a server has enabled
+type Capabilities struct {
+	ProtocolVersion     int      `json:"protocolVersion"`
+	MinProtocolVersion  int      `json:"minProtocolVersion"`
+	MessageTypes        []string `json:"messageTypes"`
+	ChecksumAlgorithms  []string `json:"checksumAlgorithms"`
+	Compression         bool     `json:"compression"`
+	LiveReads           bool     `json:"liveReads"` // GET may read streams that are still uploading
+	StrictFrames        bool     `json:"strictFrames"`
+	FairScheduling      bool     `json:"fairScheduling"`
+	DeclaredSizeLimit   bool     `json:"declaredSizeLimit"` // START size is enforced
+	MaxMessageSize      int64    `json:"maxMessageSize"`    // 0 means unlimited
+	MaxUploadDurationMs int64    `json:"maxUploadDurationMs"`
+	MinChunkSize        int      `json:"minChunkSize"` // Shorter frames are coalesced; 0 means no minimum
+	GetEnvelope         bool     `json:"getEnvelope"`  // GET accepts envelope and answers with DATA first
+	Dedup               bool     `json:"dedup"`        // START sha256 may be answered with ALREADY_EXISTS
+
+	AllowedFormats  []string `json:"allowedFormats,omitempty"` // Upload formats accepted; omitted when all are
+	SequencedFrames bool     `json:"sequencedFrames"`          // START sequenced is supported
+	ReorderWindow   int      `json:"reorderWindow"`            // Early sequenced frames held; 0 requires send order
+	FrameDedup      bool     `json:"frameDedup"`               // Resent sequenced frames are ignored
+	Transcoder      string   `json:"transcoder"`               // START transcodeTo support: passthrough or ffmpeg
+
+	MaxStreamsPerConnection int `json:"maxStreamsPerConnection"` // Unfinished streams per connection; 0 means unlimited
+	MaxInflightGets         int `json:"maxInflightGets"`         // GETs queued or in flight per connection; 0 serves them inline, one at a time
+}
+
+// ServerStats is the SERVER_STATS response: aggregate server state
+type ServerStats struct {
+	UptimeMs          int64            `json:"uptimeMs"`
+	StreamCount       int              `json:"streamCount"`   // Registered streams in any state
+	ActiveStreams     int              `json:"activeStreams"` // Streams still uploading
+	TotalBytesStored  int64            `json:"totalBytesStored"`
+	Connections       int              `json:"connections"`
+	ReapedConnections int64            `json:"reapedConnections"` // Closed by the idle reaper
+	MemoryPool        memory.PoolStats `json:"memoryPool"`
+	PoolUtilization   float64          `json:"poolUtilization"` // Fraction of pooled buffers in use
+}
+
+// StreamSummary describes one stream in a LIST response
+type StreamSummary struct {
+	StreamId       string    `json:"streamId"`
+	Status         string    `json:"status"`
+	Size           int64     `json:"size"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastAccessedAt time.Time `json:"lastAccessedAt"`
+	ReadCount      int64     `json:"readCount"`
+	WriteCount     int64     `json:"writeCount"`
+}
+
+// NewStreamSummary converts stream metadata to its wire form
+func NewStreamSummary(info memory.StreamInfo) StreamSummary {
+	return StreamSummary{
+		StreamId:       info.StreamID,
+		Status:         string(info.Status),
+		Size:           info.Size,
+		CreatedAt:      info.CreatedAt,
+		LastAccessedAt: info.LastAccessedAt,
+		ReadCount:      info.ReadCount,
+		WriteCount:     info.WriteCount,
+	}
 }
 
 // NewStartedMessage creates a STARTED response message
@@ -16,6 +144,32 @@ func NewStartedMessage(streamId, message string) *WebSocketMessage {
 		Type:     "STARTED",
 		StreamId: streamId,
 		Message:  message,
+		Version:  ProtocolVersion,
+	}
+}
+
+// NewAlreadyExistsMessage creates an ALREADY_EXISTS response to START,
+// naming the stream that already holds the content
+func NewAlreadyExistsMessage(info memory.StreamInfo) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:            "ALREADY_EXISTS",
+		StreamId:        info.StreamID,
+		Message:         "Stream with identical content already exists",
+		Version:         ProtocolVersion,
+		Size:            &info.Size,
+		Digest:          info.Digest,
+		DigestAlgorithm: info.DigestAlgorithm,
+	}
+}
+
+// NewProgressMessage creates a PROGRESS message for a watched stream:
+// its size so far and status
+func NewProgressMessage(info memory.StreamInfo) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:     "PROGRESS",
+		StreamId: info.StreamID,
+		Size:     &info.Size,
+		Status:   string(info.Status),
 	}
 }
 
@@ -28,6 +182,62 @@ func NewStoppedMessage(streamId, message string) *WebSocketMessage {
 	}
 }
 
+// NewDeletedMessage creates a DELETED response message
+func NewDeletedMessage(streamId, message string) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:     "DELETED",
+		StreamId: streamId,
+		Message:  message,
+	}
+}
+
+// Error codes carried in ERROR messages
+const (
+	ErrCodeOutOfRange   = "OUT_OF_RANGE"  // Offset is at or past the end of a finalized stream; Size holds its length
+	ErrCodeNotReady     = "NOT_READY"     // Offset is not yet written on a stream still uploading; retry later
+	ErrCodeDraining     = "DRAINING"      // Server is shutting down and rejects new streams
+	ErrCodeInvalidRange = "INVALID_RANGE" // GET offset or length is negative
+
+	ErrCodeLimitExceeded = "LIMIT_EXCEEDED" // A configured limit refused the request; LimitName and LimitValue hold it
+
+	ErrCodeFinalizeFailed = "FINALIZE_FAILED" // STOP could not make the data durable (e.g. disk full); the stream is in ERROR
+
+	ErrCodeWriteTimeout = "WRITE_TIMEOUT" // A cache write stalled past the server's deadline; the stream is in ERROR and the connection is closed
+
+	ErrCodeFormatNotAllowed = "FORMAT_NOT_ALLOWED" // The stream's detected format is not in --allowed-formats; the stream is in ERROR
+
+	ErrCodeVariantNotFound = "VARIANT_NOT_FOUND" // GET asked for a variant the stream does not have (not requested, not finalized or transcoding failed)
+)
+
+// NewCodedErrorMessage creates an ERROR response message with a reason code
+func NewCodedErrorMessage(code, message string) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:    "ERROR",
+		Code:    code,
+		Message: message,
+	}
+}
+
+// NewLimitErrorMessage creates an ERROR response for a request refused by a
+// configured limit, stating the limit
+func NewLimitErrorMessage(code, message, limitName string, limit int64) *WebSocketMessage {
+	response := NewCodedErrorMessage(code, message)
+	response.LimitName = limitName
+	response.LimitValue = &limit
+	return response
+}
+
+// NewDataEnvelopeMessage creates the DATA message sent ahead of a GET's binary frame
+func NewDataEnvelopeMessage(streamID string, offset int64, length int, final bool) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:     "DATA",
+		StreamId: streamID,
+		Offset:   &offset,
+		Length:   &length,
+		Final:    final,
+	}
+}
+
 // NewErrorMessage creates an ERROR response message
 func NewErrorMessage(message string) *WebSocketMessage {
 	return &WebSocketMessage{
@@ -35,3 +245,67 @@ func NewErrorMessage(message string) *WebSocketMessage {
 		Message: message,
 	}
 }
+
+// NewListResultMessage creates a LIST_RESULT response message
+func NewListResultMessage(streams []StreamSummary, total int) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:    "LIST_RESULT",
+		Streams: streams,
+		Total:   &total,
+	}
+}
+
+// NewHashesMessage creates a HASHES response message
+func NewHashesMessage(streamID string, size int64, blockSize int, hashes []string) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:      "HASHES",
+		StreamId:  streamID,
+		Size:      &size,
+		BlockSize: &blockSize,
+		Hashes:    hashes,
+	}
+}
+
+// NewStatusMessage creates a STATUS response message
+func NewStatusMessage(info memory.StreamInfo) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:            "STATUS",
+		StreamId:        info.StreamID,
+		Status:          string(info.Status),
+		Size:            &info.Size,
+		Digest:          info.Digest,
+		DigestAlgorithm: info.DigestAlgorithm,
+		Variants:        variantSummaries(info.Variants),
+	}
+}
+
+// VariantSummary describes one transcoded variant in a STATUS response
+type VariantSummary struct {
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+}
+
+// variantSummaries lists a stream's variants for a STATUS response
+func variantSummaries(variants []memory.StreamVariant) []VariantSummary {
+	var summaries []VariantSummary
+	for _, variant := range variants {
+		summaries = append(summaries, VariantSummary{Format: variant.Format, Size: variant.Size})
+	}
+	return summaries
+}
+
+// NewServerStatsMessage creates a SERVER_STATS response message
+func NewServerStatsMessage(stats *ServerStats) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:  "SERVER_STATS",
+		Stats: stats,
+	}
+}
+
+// NewCapabilitiesMessage creates a CAPABILITIES response message
+func NewCapabilitiesMessage(capabilities *Capabilities) *WebSocketMessage {
+	return &WebSocketMessage{
+		Type:         "CAPABILITIES",
+		Capabilities: capabilities,
+	}
+}
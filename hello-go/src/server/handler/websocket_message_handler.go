@@ -1,12 +1,20 @@
 package handler
 
 import (
+	"compress/flate"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
 	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	"github.com/feuyeux/hello-mmap/hello-go/src/telemetry"
 	"github.com/gorilla/websocket"
 )
 
@@ -16,6 +24,28 @@ type WebSocketMessageHandler struct {
 	memoryPool    *memory.MemoryPoolManager
 	clients       map[*websocket.Conn]string
 	clientsMutex  *sync.RWMutex
+	scheduler     *FairScheduler // Optional; nil writes GET responses inline
+	strictFrames  bool           // Reject empty binary frames instead of ignoring them
+
+	connections     map[*websocket.Conn]*connectionState // Guarded by clientsMutex
+	maxInflightGets int                                  // 0 serves GETs inline on the read loop
+	draining        atomic.Bool                          // Reject new streams while set
+	accessLog       *AccessLogger                        // Optional; nil disables access logging
+	minChunkSize    int                                  // Frames below this are coalesced; 0 disables
+	startedAt       time.Time                            // Reported as uptime in SERVER_STATS
+	bandwidth       *BandwidthLimiter                    // Optional server-wide cap; nil is unlimited
+	compression     bool                                 // Per-message compression is negotiated with clients
+	reaped          atomic.Int64                         // Connections closed by the idle reaper
+	session         sessionCounters                      // Lifetime totals for the shutdown summary
+	watchers        *streamWatchers                      // Connections receiving PROGRESS for streams they WATCH
+	uploadSpans     *uploadSpans                         // Tracing spans of uploads in progress
+	allowedFormats  map[string]bool                      // Formats accepted at the first write; nil allows all
+	reorderWindow   int                                  // Early sequenced frames held per upload; 0 requires send order
+	dedupFrames     bool                                 // Ignore resent sequenced frames instead of failing the stream
+	transcoder      string                               // Name of the stream manager's transcoder, for CAPABILITIES
+	maxConnStreams  int                                  // Unfinished streams one connection may have started; 0 is unlimited
+	liveReads       bool                                 // GET may read streams that are still uploading
+	maxMessageSize  int64                                // Read limit set on each connection; 0 is unlimited
 }
 
 // NewWebSocketMessageHandler creates a new message handler
@@ -25,7 +55,159 @@ func NewWebSocketMessageHandler(streamMgr *memory.StreamManager, memPool *memory
 		memoryPool:    memPool,
 		clients:       clients,
 		clientsMutex:  mutex,
+		connections:   make(map[*websocket.Conn]*connectionState),
+		startedAt:     time.Now(),
+		watchers:      newStreamWatchers(),
+		transcoder:    "passthrough",
+		uploadSpans:   newUploadSpans(),
+		liveReads:     true,
+	}
+}
+
+// defaultCompressionLevel is gorilla/websocket's own default deflate level
+const defaultCompressionLevel = flate.BestSpeed
+
+// SetCompression reports per-message compression in CAPABILITIES and lets
+// START pick a per-stream level; the upgrader must negotiate it as well
+func (h *WebSocketMessageHandler) SetCompression(enable bool) {
+	h.compression = enable
+}
+
+// SetFairScheduler routes GET responses through a fair scheduler
+func (h *WebSocketMessageHandler) SetFairScheduler(scheduler *FairScheduler) {
+	h.scheduler = scheduler
+}
+
+// SetStrictFrames makes empty binary frames an error instead of a no-op
+func (h *WebSocketMessageHandler) SetStrictFrames(strict bool) {
+	h.strictFrames = strict
+}
+
+// SetMaxInflightGets serves GETs on a per-connection worker, allowing at most
+// max in flight per connection; further GETs are rejected with BUSY
+func (h *WebSocketMessageHandler) SetMaxInflightGets(max int) {
+	h.maxInflightGets = max
+}
+
+// SetMinChunkSize coalesces binary frames smaller than size bytes into one
+// write, so a client with a tiny chunk size does not cause a write per frame.
+// Buffered bytes are written once enough accumulate or on STOP.
+func (h *WebSocketMessageHandler) SetMinChunkSize(size int) {
+	h.minChunkSize = size
+}
+
+// SetReorderWindow lets a sequenced upload's frames arrive out of order:
+// up to window early frames are held until the missing one arrives. With 0
+// any out-of-order frame fails the stream.
+func (h *WebSocketMessageHandler) SetReorderWindow(window int) {
+	h.reorderWindow = window
+}
+
+// SetDedupFrames makes sequenced uploads idempotent under client resends: a
+// frame whose sequence number was already applied (or is already held) is
+// ignored instead of failing the stream with OUT_OF_ORDER
+func (h *WebSocketMessageHandler) SetDedupFrames(dedup bool) {
+	h.dedupFrames = dedup
+}
+
+// SetTranscoderName reports which transcoder serves START transcodeTo in
+// CAPABILITIES; the stream manager does the transcoding
+func (h *WebSocketMessageHandler) SetTranscoderName(name string) {
+	h.transcoder = name
+}
+
+// SetMaxStreamsPerConnection caps the streams one connection may have
+// uploading at once, including ones it started again over without STOP;
+// further STARTs are refused with LIMIT_EXCEEDED until one finishes
+func (h *WebSocketMessageHandler) SetMaxStreamsPerConnection(max int) {
+	h.maxConnStreams = max
+}
+
+// SetLiveReads controls whether GET may read a stream that is still
+// uploading; without live reads such GETs are answered NOT_READY until STOP
+func (h *WebSocketMessageHandler) SetLiveReads(live bool) {
+	h.liveReads = live
+}
+
+// SetMaxMessageSize limits the size of messages read from each new
+// connection; a larger message closes the connection. 0 is unlimited.
+func (h *WebSocketMessageHandler) SetMaxMessageSize(size int64) {
+	h.maxMessageSize = size
+}
+
+// Drain makes START fail with a DRAINING error; existing streams continue
+func (h *WebSocketMessageHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// ActiveUploads returns the number of connections with a stream still uploading
+func (h *WebSocketMessageHandler) ActiveUploads() int {
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+
+	active := 0
+	for _, streamID := range h.clients {
+		if streamID != "" {
+			active++
+		}
+	}
+	return active
+}
+
+// Capabilities reports the features and limits enabled on this server
+func (h *WebSocketMessageHandler) Capabilities() *Capabilities {
+	return &Capabilities{
+		ProtocolVersion:     ProtocolVersion,
+		MinProtocolVersion:  MinProtocolVersion,
+		MessageTypes:        []string{"START", "STOP", "GET", "LIST", "CAPABILITIES", "SERVER_STATS", "HASHES", "STATUS", "DELETE", "WATCH", "UNWATCH"},
+		ChecksumAlgorithms:  []string{ChunkChecksumCRC32},
+		Compression:         h.compression,
+		LiveReads:           h.liveReads,
+		StrictFrames:        h.strictFrames,
+		FairScheduling:      h.scheduler != nil,
+		DeclaredSizeLimit:   true, // Enforced by the stream manager on every write
+		MaxMessageSize:      h.maxMessageSize,
+		MaxUploadDurationMs: h.streamManager.MaxUploadDuration().Milliseconds(),
+		MinChunkSize:        h.minChunkSize,
+		GetEnvelope:         true, // Served on every GET path, including the fair scheduler
+		Dedup:               h.streamManager.DedupEnabled(),
+		AllowedFormats:      h.allowedFormatList(),
+		SequencedFrames:     true,
+		ReorderWindow:       h.reorderWindow,
+		FrameDedup:          h.dedupFrames,
+		Transcoder:          h.transcoder,
+
+		MaxStreamsPerConnection: h.maxConnStreams,
+		MaxInflightGets:         h.maxInflightGets,
+	}
+}
+
+// ServerStats aggregates stream, connection and memory pool state
+func (h *WebSocketMessageHandler) ServerStats() *ServerStats {
+	stats := &ServerStats{
+		UptimeMs: time.Since(h.startedAt).Milliseconds(),
+	}
+
+	for _, info := range h.streamManager.SnapshotStreams() {
+		stats.StreamCount++
+		stats.TotalBytesStored += info.Size
+		if info.Status == memory.StatusUploading {
+			stats.ActiveStreams++
+		}
+	}
+
+	h.clientsMutex.RLock()
+	stats.Connections = len(h.connections)
+	h.clientsMutex.RUnlock()
+	stats.ReapedConnections = h.reaped.Load()
+
+	if h.memoryPool != nil {
+		stats.MemoryPool = h.memoryPool.Stats()
+		if total := stats.MemoryPool.Total; total > 0 {
+			stats.PoolUtilization = float64(total-stats.MemoryPool.Available) / float64(total)
+		}
 	}
+	return stats
 }
 
 // HandleTextMessage handles text (JSON) messages
@@ -33,94 +215,455 @@ func (h *WebSocketMessageHandler) HandleTextMessage(conn *websocket.Conn, messag
 	var data WebSocketMessage
 	if err := json.Unmarshal(message, &data); err != nil {
 		logger.Debug(fmt.Sprintf("Invalid JSON message: %v", err))
-		h.sendError(conn, "Invalid JSON format")
+		h.logAccess(conn, "INVALID", "", 0, h.reject(conn, "Invalid JSON format"))
 		return
 	}
 
 	msgType := data.Type
 	if msgType == "" {
-		h.sendError(conn, "Missing message type")
+		h.logAccess(conn, "INVALID", data.StreamId, 0, h.reject(conn, "Missing message type"))
 		return
 	}
 
+	var err error
 	switch msgType {
 	case "START":
-		h.handleStart(conn, &data)
+		err = h.handleStart(conn, &data)
 	case "STOP":
-		h.handleStop(conn, &data)
+		err = h.handleStop(conn, &data)
 	case "GET":
+		// Logged once served, which may happen on the connection's GET worker
 		h.handleGet(conn, &data)
+		return
+	case "LIST":
+		err = h.handleList(conn, &data)
+	case "CAPABILITIES":
+		err = h.sendJSON(conn, NewCapabilitiesMessage(h.Capabilities()))
+	case "HASHES":
+		err = h.handleHashes(conn, &data)
+	case "STATUS":
+		err = h.handleStatus(conn, &data)
+	case "DELETE":
+		err = h.handleDelete(conn, &data)
+	case "WATCH":
+		err = h.handleWatch(conn, &data)
+	case "UNWATCH":
+		err = h.handleUnwatch(conn, &data)
+	case "SERVER_STATS":
+		err = h.sendJSON(conn, NewServerStatsMessage(h.ServerStats()))
 	default:
 		logger.Debug(fmt.Sprintf("Unknown message type: %s", msgType))
-		h.sendError(conn, fmt.Sprintf("Unknown message type: %s", msgType))
+		err = h.reject(conn, fmt.Sprintf("Unknown message type: %s", msgType))
 	}
+	h.logAccess(conn, msgType, data.StreamId, 0, err)
 }
 
-// HandleBinaryMessage handles binary audio data
+// HandleBinaryMessage handles binary audio data.
+// A zero-length frame is ignored and leaves the stream offset unchanged;
+// in strict mode it is answered with an ERROR instead.
 func (h *WebSocketMessageHandler) HandleBinaryMessage(conn *websocket.Conn, data []byte, streamID string) {
 	if streamID == "" {
 		logger.Debug("Received binary data but no active stream for client")
 		return
 	}
 
+	if len(data) == 0 {
+		logger.Debug(fmt.Sprintf("Received empty binary frame for stream %s", streamID))
+		if h.strictFrames {
+			err := h.reject(conn, fmt.Sprintf("Empty binary frame rejected for stream: %s", streamID))
+			h.logAccess(conn, "DATA", streamID, 0, err)
+		}
+		return
+	}
+
 	logger.Debug(fmt.Sprintf("Received %d bytes of binary data for stream %s", len(data), streamID))
 
-	// Write to stream
-	h.streamManager.WriteChunk(streamID, data)
+	// Sequenced frames are written in send order, not arrival order
+	state := h.connectionState(conn)
+	if state != nil && state.sequencer != nil {
+		frames, next, err := state.sequencer.accept(data, h.streamManager.NextFrame(streamID))
+		if errors.Is(err, errDuplicateFrame) && h.dedupFrames {
+			// A resend of a frame already applied or held changes nothing
+			logger.Debug(fmt.Sprintf("Ignoring frame for stream %s: %v", streamID, err))
+			h.logAccess(conn, "DATA", streamID, int64(len(data)), nil)
+			return
+		}
+		if err != nil {
+			err = h.failUpload(conn, streamID, NewCodedErrorMessage(ErrCodeOutOfOrder,
+				fmt.Sprintf("Frame order broken for stream %s: %v", streamID, err)))
+			h.logAccess(conn, "DATA", streamID, int64(len(data)), err)
+			return
+		}
+		if len(frames) == 0 {
+			logger.Debug(fmt.Sprintf("Holding early frame for stream %s", streamID))
+			h.logAccess(conn, "DATA", streamID, int64(len(data)), nil)
+			return
+		}
+		for _, frame := range frames {
+			if !h.writeFrame(conn, state, streamID, frame) {
+				return
+			}
+		}
+		h.streamManager.SetNextFrame(streamID, next)
+		return
+	}
+	h.writeFrame(conn, state, streamID, data)
+}
+
+// writeFrame verifies, coalesces and writes one frame of streamID, reporting
+// whether the upload can go on
+func (h *WebSocketMessageHandler) writeFrame(conn *websocket.Conn, state *connectionState, streamID string, data []byte) bool {
+	// A corrupted chunk fails the whole stream
+	if state != nil && state.chunkChecksum {
+		payload, err := verifyChunkChecksum(data)
+		if err != nil {
+			err = h.failUpload(conn, streamID, NewCodedErrorMessage(ErrCodeChecksumMismatch,
+				fmt.Sprintf("Chunk checksum failed for stream %s: %v", streamID, err)))
+			h.logAccess(conn, "DATA", streamID, int64(len(data)), err)
+			return false
+		}
+		data = payload
+	}
+
+	chunk := h.coalesce(state, data)
+	if chunk == nil {
+		logger.Debug(fmt.Sprintf("Coalescing short frame for stream %s (%d bytes)", streamID, len(data)))
+		h.logAccess(conn, "DATA", streamID, int64(len(data)), nil)
+		return true
+	}
+
+	// The first write decides whether the stream's format is accepted
+	if err := h.checkFormat(streamID, chunk); err != nil {
+		err = h.failUpload(conn, streamID, NewCodedErrorMessage(ErrCodeFormatNotAllowed, err.Error()))
+		h.logAccess(conn, "DATA", streamID, int64(len(data)), err)
+		return false
+	}
+
+	// Write to stream; on failure stop accepting frames for it on this connection
+	err := h.streamManager.WriteChunk(streamID, chunk)
+	if err != nil {
+		h.clientsMutex.Lock()
+		h.clients[conn] = ""
+		h.clientsMutex.Unlock()
+		err = h.rejectWrite(conn, err)
+		h.notifyWatchers(streamID, true)
+		h.endUploadSpan(streamID, err)
+	} else {
+		h.session.bytesWritten.Add(int64(len(chunk)))
+		h.notifyWatchers(streamID, false)
+	}
+	h.logAccess(conn, "DATA", streamID, int64(len(data)), err)
+	return err == nil
+}
+
+// failUpload moves streamID to ERROR, stops accepting its frames on conn and
+// sends response, returning it as an error
+func (h *WebSocketMessageHandler) failUpload(conn *websocket.Conn, streamID string, response *WebSocketMessage) error {
+	h.streamManager.FailStream(streamID)
+	h.clientsMutex.Lock()
+	h.clients[conn] = ""
+	h.clientsMutex.Unlock()
+	h.notifyWatchers(streamID, true)
+	err := h.rejectCoded(conn, response)
+	h.endUploadSpan(streamID, err)
+	return err
+}
+
+// HandleDisconnect unregisters a client and marks any stream it was still
+// uploading as incomplete
+func (h *WebSocketMessageHandler) HandleDisconnect(conn *websocket.Conn) {
+	h.clientsMutex.Lock()
+	streamID, state := h.forgetConnection(conn)
+	h.clientsMutex.Unlock()
+	h.releaseConnection(conn, streamID, state)
+}
+
+// Close tears down handler state on shutdown the way HandleDisconnect does
+// for each connection: buffered bytes are written, streams still uploading
+// are marked incomplete, GET workers are stopped and all clients are forgotten.
+func (h *WebSocketMessageHandler) Close() {
+	type connection struct {
+		conn     *websocket.Conn
+		streamID string
+		state    *connectionState
+	}
+	h.clientsMutex.Lock()
+	var closing []connection
+	for conn := range h.clients {
+		streamID, state := h.forgetConnection(conn)
+		closing = append(closing, connection{conn, streamID, state})
+	}
+	for conn := range h.connections {
+		streamID, state := h.forgetConnection(conn)
+		closing = append(closing, connection{conn, streamID, state})
+	}
+	h.clientsMutex.Unlock()
+
+	incomplete := 0
+	for _, c := range closing {
+		incomplete += h.releaseConnection(c.conn, c.streamID, c.state)
+	}
+	logger.Info(fmt.Sprintf("Message handler closed, %d unfinished uploads marked incomplete", incomplete))
+}
+
+// forgetConnection unregisters conn and stops its GET worker, returning the
+// stream it was uploading and its state. The caller holds clientsMutex, under
+// which handleGet queues, so no GET is queued on the closed channel.
+func (h *WebSocketMessageHandler) forgetConnection(conn *websocket.Conn) (string, *connectionState) {
+	streamID := h.clients[conn]
+	state := h.connections[conn]
+	delete(h.clients, conn)
+	delete(h.connections, conn)
+	if state != nil && state.gets != nil {
+		close(state.gets)
+	}
+	return streamID, state
+}
+
+// releaseConnection cleans up after a forgotten connection: its reads are
+// cancelled, buffered bytes are written and every stream it left unfinished
+// is marked incomplete. It returns how many streams were marked.
+func (h *WebSocketMessageHandler) releaseConnection(conn *websocket.Conn, streamID string, state *connectionState) int {
+	h.watchers.removeConnection(conn)
+	if state != nil {
+		// Stop reads still in progress for this connection's GETs
+		state.cancel()
+	}
+
+	incomplete := 0
+	if streamID != "" {
+		// Keep whatever was received before the stream is marked incomplete
+		if err := h.flushPending(state, streamID); err != nil {
+			logger.Debug(fmt.Sprintf("Dropped buffered bytes for stream %s: %v", streamID, err))
+		}
+		if h.streamManager.MarkIncomplete(streamID) {
+			incomplete++
+		}
+		h.notifyWatchers(streamID, true)
+		h.endUploadSpan(streamID, errUploadIncomplete)
+	}
+
+	// Streams the client started over without STOP were abandoned too
+	if state != nil {
+		for started := range state.streams {
+			if started != streamID && h.streamManager.MarkIncomplete(started) {
+				incomplete++
+				h.notifyWatchers(started, true)
+				h.endUploadSpan(started, errUploadIncomplete)
+			}
+		}
+	}
+	return incomplete
 }
 
 // handleStart handles START message (create new stream)
-func (h *WebSocketMessageHandler) handleStart(conn *websocket.Conn, data *WebSocketMessage) {
+func (h *WebSocketMessageHandler) handleStart(conn *websocket.Conn, data *WebSocketMessage) error {
 	streamID := data.StreamId
 	if streamID == "" {
-		h.sendError(conn, "Missing streamId")
-		return
+		return h.reject(conn, "Missing streamId")
+	}
+
+	if h.draining.Load() {
+		return h.rejectCoded(conn, NewCodedErrorMessage(ErrCodeDraining, "Server is draining and not accepting new streams"))
+	}
+
+	clientVersion := data.Version
+	if clientVersion == 0 {
+		clientVersion = MinProtocolVersion
+	}
+	if clientVersion < MinProtocolVersion || clientVersion > ProtocolVersion {
+		return h.reject(conn, fmt.Sprintf("Unsupported protocol version %d (server supports %d-%d)",
+			clientVersion, MinProtocolVersion, ProtocolVersion))
+	}
+
+	if data.Size != nil && *data.Size < 0 {
+		return h.reject(conn, fmt.Sprintf("Invalid declared size: %d", *data.Size))
+	}
+
+	if data.ChunkChecksum != "" && data.ChunkChecksum != ChunkChecksumCRC32 {
+		return h.reject(conn, fmt.Sprintf("Unsupported chunk checksum: %s", data.ChunkChecksum))
+	}
+
+	if data.TranscodeTo != "" && !isKnownFormat(data.TranscodeTo) {
+		return h.reject(conn, fmt.Sprintf("Unsupported transcodeTo: %s (expected one of %s)", data.TranscodeTo, strings.Join(knownFormats, ", ")))
+	}
+
+	if level := data.CompressionLevel; level != nil && (*level < flate.BestSpeed || *level > flate.BestCompression) {
+		return h.reject(conn, fmt.Sprintf("Invalid compressionLevel: %d (expected %d-%d)", *level, flate.BestSpeed, flate.BestCompression))
+	}
+
+	// Content the server already holds need not be uploaded again
+	if data.Sha256 != "" {
+		if info, ok := h.streamManager.FindBySHA256(data.Sha256); ok {
+			logger.Info(fmt.Sprintf("Upload of %s deduplicated to existing stream %s", streamID, info.StreamID))
+			return h.sendJSON(conn, NewAlreadyExistsMessage(info))
+		}
+	}
+
+	// Streams left unfinished count against the connection's cap
+	if pending := h.pendingStreams(conn); h.maxConnStreams > 0 && pending >= h.maxConnStreams {
+		return h.rejectCoded(conn, NewLimitErrorMessage(ErrCodeLimitExceeded,
+			fmt.Sprintf("Connection already has %d unfinished streams (max %d); STOP one before starting another", pending, h.maxConnStreams),
+			"maxStreamsPerConnection", int64(h.maxConnStreams)))
 	}
 
 	// Create stream
 	if h.streamManager.CreateStream(streamID) {
+		if data.Size != nil {
+			h.streamManager.SetDeclaredSize(streamID, *data.Size)
+		}
+		if data.CompressionLevel != nil {
+			h.streamManager.SetCompressionLevel(streamID, *data.CompressionLevel)
+		}
+		if data.TranscodeTo != "" {
+			h.streamManager.SetTranscodeTarget(streamID, data.TranscodeTo)
+		}
+
+		// Short frames still buffered belong to the stream started before this one
+		h.clientsMutex.RLock()
+		previous := h.clients[conn]
+		h.clientsMutex.RUnlock()
+		if previous != "" {
+			if err := h.flushPending(h.connectionState(conn), previous); err != nil {
+				logger.Warn(fmt.Sprintf("Dropped buffered bytes for stream %s: %v", previous, err))
+			}
+		}
+
 		// Register this client with the stream
 		h.clientsMutex.Lock()
 		h.clients[conn] = streamID
+		if state := h.connections[conn]; state != nil {
+			state.streams[streamID] = struct{}{}
+		}
 		h.clientsMutex.Unlock()
+		if state := h.connectionState(conn); state != nil {
+			state.chunkChecksum = data.ChunkChecksum == ChunkChecksumCRC32
+			state.sequencer = nil
+			if data.Sequenced {
+				state.sequencer = newFrameSequencer(h.reorderWindow)
+			}
+		}
 
+		h.session.streamsStarted.Add(1)
+		h.uploadSpans.start(streamID)
 		response := NewStartedMessage(streamID, "Stream started successfully")
-		h.sendJSON(conn, response)
+		if err := h.sendJSON(conn, response); err != nil {
+			return err
+		}
 		logger.Debug(fmt.Sprintf("Stream started: %s", streamID))
-	} else {
-		h.sendError(conn, fmt.Sprintf("Failed to create stream: %s", streamID))
+		return nil
 	}
+	return h.reject(conn, fmt.Sprintf("Failed to create stream: %s", streamID))
 }
 
 // handleStop handles STOP message (finalize stream)
-func (h *WebSocketMessageHandler) handleStop(conn *websocket.Conn, data *WebSocketMessage) {
+func (h *WebSocketMessageHandler) handleStop(conn *websocket.Conn, data *WebSocketMessage) error {
 	streamID := data.StreamId
 	if streamID == "" {
-		h.sendError(conn, "Missing streamId")
-		return
+		return h.reject(conn, "Missing streamId")
 	}
 
-	// Finalize stream
-	if h.streamManager.FinalizeStream(streamID) {
-		response := NewStoppedMessage(streamID, "Stream finalized successfully")
-		h.sendJSON(conn, response)
-		logger.Debug(fmt.Sprintf("Stream finalized: %s", streamID))
+	// The last chunk may still be buffered below the minimum chunk size
+	h.clientsMutex.RLock()
+	uploading := h.clients[conn] == streamID
+	h.clientsMutex.RUnlock()
+	if uploading {
+		state := h.connectionState(conn)
+		if state != nil && state.sequencer != nil {
+			if state.sequencer.waiting() {
+				return h.failUpload(conn, streamID, NewCodedErrorMessage(ErrCodeOutOfOrder,
+					fmt.Sprintf("Frame %d of stream %s never arrived", h.streamManager.NextFrame(streamID), streamID)))
+			}
+		}
+		if err := h.flushPending(state, streamID); errors.Is(err, errFormatNotAllowed) {
+			return h.failUpload(conn, streamID, NewCodedErrorMessage(ErrCodeFormatNotAllowed, err.Error()))
+		} else if err != nil {
+			return h.rejectWrite(conn, err)
+		}
+	}
 
-		// Unregister stream from client
+	// Finalize stream
+	err := h.streamManager.FinalizeStream(streamID)
+	if errors.Is(err, memory.ErrFinalizeFailed) {
+		// The stream is now in ERROR; it no longer belongs to this upload
 		h.clientsMutex.Lock()
 		h.clients[conn] = ""
 		h.clientsMutex.Unlock()
-	} else {
-		h.sendError(conn, fmt.Sprintf("Failed to finalize stream: %s", streamID))
+		h.notifyWatchers(streamID, true)
+		err = h.rejectCoded(conn, NewCodedErrorMessage(ErrCodeFinalizeFailed,
+			fmt.Sprintf("Failed to finalize stream %s: %v", streamID, err)))
+		h.endUploadSpan(streamID, err)
+		return err
+	}
+	if err == nil {
+		// Unregister stream from client first, so a failed reply below
+		// does not mark the finalized stream incomplete
+		h.clientsMutex.Lock()
+		h.clients[conn] = ""
+		h.clientsMutex.Unlock()
+		h.notifyWatchers(streamID, true)
+		h.endUploadSpan(streamID, nil)
+
+		response := NewStoppedMessage(streamID, "Stream finalized successfully")
+		if info, ok := h.streamManager.GetStreamInfo(streamID); ok {
+			response.Digest = info.Digest
+			response.DigestAlgorithm = info.DigestAlgorithm
+		}
+		if err := h.sendJSON(conn, response); err != nil {
+			return err
+		}
+		logger.Debug(fmt.Sprintf("Stream finalized: %s", streamID))
+		return nil
 	}
+	return h.reject(conn, fmt.Sprintf("Failed to finalize stream: %s", streamID))
 }
 
 // handleGet handles GET message (read stream data)
 func (h *WebSocketMessageHandler) handleGet(conn *websocket.Conn, data *WebSocketMessage) {
+	h.clientsMutex.RLock()
+	state := h.connections[conn]
+	if state == nil || state.gets == nil {
+		h.clientsMutex.RUnlock()
+		h.serveGet(conn, data)
+		return
+	}
+
+	// Queued under clientsMutex so the worker's channel cannot be closed meanwhile
+	queued := false
+	select {
+	case state.gets <- data:
+		queued = true
+	default:
+	}
+	h.clientsMutex.RUnlock()
+
+	if !queued {
+		err := h.rejectCoded(conn, NewLimitErrorMessage(ErrCodeBusy,
+			fmt.Sprintf("Too many GETs in flight (max %d)", h.maxInflightGets), "maxInflightGets", int64(h.maxInflightGets)))
+		h.logAccess(conn, "GET", data.StreamId, 0, err)
+	}
+}
+
+// serveGet serves one GET and records it in the access log
+func (h *WebSocketMessageHandler) serveGet(conn *websocket.Conn, data *WebSocketMessage) {
+	_, span := telemetry.Start(context.Background(), "audio_server.get")
+	span.SetStreamID(data.StreamId)
+	sent, err := h.sendRange(conn, data)
+	span.SetBytes(int64(sent))
+	span.End(err)
+	h.session.bytesRead.Add(int64(sent))
+	if err == nil {
+		h.session.getsServed.Add(1)
+	}
+	h.logAccess(conn, "GET", data.StreamId, int64(sent), err)
+}
+
+// sendRange reads the requested range and sends it to the client,
+// returning the number of bytes sent
+func (h *WebSocketMessageHandler) sendRange(conn *websocket.Conn, data *WebSocketMessage) (int, error) {
 	streamID := data.StreamId
 	if streamID == "" {
-		h.sendError(conn, "Missing streamId")
-		return
+		return 0, h.reject(conn, "Missing streamId")
 	}
 
 	offset := int64(0)
@@ -132,37 +675,337 @@ func (h *WebSocketMessageHandler) handleGet(conn *websocket.Conn, data *WebSocke
 	if data.Length != nil {
 		length = *data.Length
 	}
+	if offset < 0 || length < 0 {
+		return 0, h.rejectCoded(conn, NewCodedErrorMessage(ErrCodeInvalidRange,
+			fmt.Sprintf("Invalid range for stream %s: offset %d, length %d", streamID, offset, length)))
+	}
 
-	// Read data from stream
-	chunkData := h.streamManager.ReadChunk(streamID, offset, length)
+	// Distinguish a permanent range error from data that is not written yet
+	info, ok := h.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return 0, h.reject(conn, fmt.Sprintf("Stream not found: %s", streamID))
+	}
+	if data.Variant != "" {
+		// A variant is a finished file of its own size
+		variant, ok := info.Variant(data.Variant)
+		if !ok {
+			return 0, h.rejectCoded(conn, NewCodedErrorMessage(ErrCodeVariantNotFound,
+				fmt.Sprintf("Stream %s has no %s variant", streamID, data.Variant)))
+		}
+		info.Size = variant.Size
+	}
+	if !h.liveReads && info.Status == memory.StatusUploading {
+		response := NewCodedErrorMessage(ErrCodeNotReady,
+			fmt.Sprintf("Stream %s is still uploading and live reads are disabled", streamID))
+		response.Size = &info.Size
+		return 0, h.rejectCoded(conn, response)
+	}
+	if offset >= info.Size {
+		switch info.Status {
+		case memory.StatusReady:
+			response := NewCodedErrorMessage(ErrCodeOutOfRange,
+				fmt.Sprintf("Offset %d is beyond end of stream %s (size %d)", offset, streamID, info.Size))
+			response.Size = &info.Size
+			return 0, h.rejectCoded(conn, response)
+		case memory.StatusUploading:
+			response := NewCodedErrorMessage(ErrCodeNotReady,
+				fmt.Sprintf("Offset %d not yet available for stream %s (size %d so far)", offset, streamID, info.Size))
+			response.Size = &info.Size
+			return 0, h.rejectCoded(conn, response)
+		}
+	}
+
+	if h.compression {
+		h.setCompressionLevel(conn, info.CompressionLevel)
+	}
+
+	// A zero-length GET succeeds with an empty frame rather than reading nothing
+	if length == 0 {
+		if data.Envelope {
+			return 0, h.sendEnveloped(conn, NewDataEnvelopeMessage(streamID, offset, 0, false), []byte{})
+		}
+		return 0, h.sendBinary(conn, []byte{})
+	}
+
+	// Read data from stream, giving up if the client goes away meanwhile
+	ctx := h.connectionContext(conn)
+	var chunkData []byte
+	if data.Variant != "" {
+		var err error
+		if chunkData, err = h.streamManager.ReadVariant(streamID, data.Variant, offset, length); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to read %s variant of stream %s: %v", data.Variant, streamID, err))
+		}
+	} else {
+		chunkData = h.streamManager.ReadChunkContext(ctx, streamID, offset, length)
+	}
+	if ctx.Err() != nil {
+		return 0, fmt.Errorf("read for stream %s at offset %d abandoned: client disconnected", streamID, offset)
+	}
 
 	if len(chunkData) > 0 {
-		// Send binary data
-		if err := conn.WriteMessage(websocket.BinaryMessage, chunkData); err != nil {
-			logger.Error(fmt.Sprintf("Error sending binary data: %v", err))
+		// Send binary data, preceded by its envelope when the client asked for one
+		if data.Envelope {
+			final := info.Status == memory.StatusReady && offset+int64(len(chunkData)) >= info.Size
+			envelope := NewDataEnvelopeMessage(streamID, offset, len(chunkData), final)
+			if err := h.sendEnveloped(conn, envelope, chunkData); err != nil {
+				return 0, err
+			}
+		} else if err := h.sendBinary(conn, chunkData); err != nil {
+			return 0, err
 		}
 		logger.Debug(fmt.Sprintf("Sent %d bytes for stream %s at offset %d", len(chunkData), streamID, offset))
+		return len(chunkData), nil
+	}
+	return 0, h.reject(conn, fmt.Sprintf("Failed to read from stream: %s", streamID))
+}
+
+// handleStatus handles STATUS message (status and current size of one stream)
+func (h *WebSocketMessageHandler) handleStatus(conn *websocket.Conn, data *WebSocketMessage) error {
+	streamID := data.StreamId
+	if streamID == "" {
+		return h.reject(conn, "Missing streamId")
+	}
+
+	info, ok := h.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		return h.reject(conn, fmt.Sprintf("Stream not found: %s", streamID))
+	}
+	return h.sendJSON(conn, NewStatusMessage(info))
+}
+
+// handleDelete handles DELETE message (remove a stream and its cached data).
+// Deleting a stream that does not exist succeeds too, so cleanup can be retried.
+func (h *WebSocketMessageHandler) handleDelete(conn *websocket.Conn, data *WebSocketMessage) error {
+	streamID := data.StreamId
+	if streamID == "" {
+		return h.reject(conn, "Missing streamId")
+	}
+
+	if !h.streamManager.DeleteStream(streamID) {
+		return h.sendJSON(conn, NewDeletedMessage(streamID, "Stream already deleted"))
+	}
+	logger.Info(fmt.Sprintf("Deleted stream: %s", streamID))
+	return h.sendJSON(conn, NewDeletedMessage(streamID, "Stream deleted"))
+}
+
+// listSorters orders stream snapshots for LIST sortBy values
+var listSorters = map[string]func(a, b memory.StreamInfo) bool{
+	"streamId":     func(a, b memory.StreamInfo) bool { return a.StreamID < b.StreamID },
+	"size":         func(a, b memory.StreamInfo) bool { return a.Size < b.Size },
+	"createdAt":    func(a, b memory.StreamInfo) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"lastAccessed": func(a, b memory.StreamInfo) bool { return a.LastAccessedAt.Before(b.LastAccessedAt) },
+}
+
+// handleList handles LIST message (page through registered streams)
+func (h *WebSocketMessageHandler) handleList(conn *websocket.Conn, data *WebSocketMessage) error {
+	sortBy := data.SortBy
+	if sortBy == "" {
+		sortBy = "streamId"
+	}
+	less, ok := listSorters[sortBy]
+	if !ok {
+		return h.reject(conn, fmt.Sprintf("Unknown sortBy: %s (expected streamId, size, createdAt or lastAccessed)", sortBy))
+	}
+
+	offset := 0
+	if data.Offset != nil {
+		if *data.Offset < 0 {
+			return h.reject(conn, fmt.Sprintf("Invalid offset: %d", *data.Offset))
+		}
+		offset = int(*data.Offset)
+	}
+	limit := -1
+	if data.Limit != nil {
+		if *data.Limit < 0 {
+			return h.reject(conn, fmt.Sprintf("Invalid limit: %d", *data.Limit))
+		}
+		limit = *data.Limit
+	}
+
+	infos := h.streamManager.SnapshotStreams()
+	sort.SliceStable(infos, func(i, j int) bool {
+		if less(infos[i], infos[j]) {
+			return true
+		}
+		if less(infos[j], infos[i]) {
+			return false
+		}
+		return infos[i].StreamID < infos[j].StreamID
+	})
+
+	total := len(infos)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit >= 0 && limit < total-offset { // offset+limit could overflow
+		end = offset + limit
+	}
+
+	summaries := make([]StreamSummary, 0, end-offset)
+	for _, info := range infos[offset:end] {
+		summaries = append(summaries, NewStreamSummary(info))
+	}
+
+	if err := h.sendJSON(conn, NewListResultMessage(summaries, total)); err != nil {
+		return err
+	}
+	logger.Debug(fmt.Sprintf("Listed %d of %d streams (offset %d, sortBy %s)", len(summaries), total, offset, sortBy))
+	return nil
+}
+
+// setCompressionLevel applies a stream's deflate level to the next messages
+// written to conn; 0 restores the default. It has no effect unless
+// per-message compression was negotiated on conn.
+func (h *WebSocketMessageHandler) setCompressionLevel(conn *websocket.Conn, level int) {
+	if level == 0 {
+		level = defaultCompressionLevel
+	}
+	unlock := h.lockWrites(conn)
+	defer unlock()
+	if err := conn.SetCompressionLevel(level); err != nil {
+		logger.Debug(fmt.Sprintf("Failed to set compression level %d: %v", level, err))
+	}
+}
+
+// lockWrites serializes writes to conn and returns the matching unlock
+func (h *WebSocketMessageHandler) lockWrites(conn *websocket.Conn) func() {
+	state := h.connectionState(conn)
+	if state == nil {
+		return func() {}
+	}
+	state.writeMutex.Lock()
+	return state.writeMutex.Unlock
+}
+
+// sendBinary sends a binary message, through the fair scheduler when enabled.
+// A failed write drops the connection.
+func (h *WebSocketMessageHandler) sendBinary(conn *websocket.Conn, data []byte) error {
+	h.bandwidth.Wait(len(data))
+
+	unlock := h.lockWrites(conn)
+	var err error
+	if h.scheduler != nil {
+		err = h.scheduler.Send(conn, data)
 	} else {
-		h.sendError(conn, fmt.Sprintf("Failed to read from stream: %s", streamID))
+		err = conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+	unlock()
+
+	if err != nil {
+		h.dropConnection(conn, err)
+		return fmt.Errorf("failed to send binary data: %w", err)
 	}
+	return nil
 }
 
-// sendJSON sends a JSON message to the client
-func (h *WebSocketMessageHandler) sendJSON(conn *websocket.Conn, data *WebSocketMessage) {
+// sendEnveloped sends a DATA envelope and its binary frame back to back,
+// holding the write lock so nothing is interleaved between them
+func (h *WebSocketMessageHandler) sendEnveloped(conn *websocket.Conn, envelope *WebSocketMessage, data []byte) error {
+	message, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", envelope.Type, err)
+	}
+
+	h.bandwidth.Wait(len(data))
+	unlock := h.lockWrites(conn)
+	err = conn.WriteMessage(websocket.TextMessage, message)
+	if err == nil {
+		if h.scheduler != nil {
+			err = h.scheduler.Send(conn, data)
+		} else {
+			err = conn.WriteMessage(websocket.BinaryMessage, data)
+		}
+	}
+	unlock()
+
+	if err != nil {
+		h.dropConnection(conn, err)
+		return fmt.Errorf("failed to send enveloped data: %w", err)
+	}
+	return nil
+}
+
+// sendJSON sends a JSON message to the client. A failed write drops the connection.
+func (h *WebSocketMessageHandler) sendJSON(conn *websocket.Conn, data *WebSocketMessage) error {
 	message, err := json.Marshal(data)
 	if err != nil {
 		logger.Debug(fmt.Sprintf("Error marshaling JSON: %v", err))
-		return
+		return fmt.Errorf("failed to marshal %s message: %w", data.Type, err)
 	}
 
-	if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-		logger.Debug(fmt.Sprintf("Error sending message: %v", err))
+	unlock := h.lockWrites(conn)
+	err = conn.WriteMessage(websocket.TextMessage, message)
+	unlock()
+
+	if err != nil {
+		h.dropConnection(conn, err)
+		return fmt.Errorf("failed to send %s message: %w", data.Type, err)
 	}
+	return nil
 }
 
 // sendError sends an error message to the client
-func (h *WebSocketMessageHandler) sendError(conn *websocket.Conn, message string) {
+func (h *WebSocketMessageHandler) sendError(conn *websocket.Conn, message string) error {
 	response := NewErrorMessage(message)
-	h.sendJSON(conn, response)
+	if err := h.sendJSON(conn, response); err != nil {
+		return err
+	}
 	logger.Debug(fmt.Sprintf("Sent error to client: %s", message))
+	return nil
+}
+
+// dropConnection reacts to a failed write: the client is unregistered at
+// once, any stream it was uploading is marked incomplete, and the connection
+// is closed so its read loop ends and HandleDisconnect releases the rest.
+func (h *WebSocketMessageHandler) dropConnection(conn *websocket.Conn, cause error) {
+	h.clientsMutex.Lock()
+	streamID, registered := h.clients[conn]
+	state := h.connections[conn]
+	delete(h.clients, conn)
+	h.clientsMutex.Unlock()
+
+	if !registered {
+		return // Already dropped
+	}
+	if state != nil {
+		state.cancel()
+	}
+	logger.Warn(fmt.Sprintf("Dropping connection %s after write failure: %v", conn.RemoteAddr(), cause))
+
+	if streamID != "" {
+		h.streamManager.MarkIncomplete(streamID)
+		h.endUploadSpan(streamID, errUploadIncomplete)
+	}
+	conn.Close()
+}
+
+// reject sends an ERROR to the client and returns it as an error
+func (h *WebSocketMessageHandler) reject(conn *websocket.Conn, message string) error {
+	h.sendError(conn, message)
+	return errors.New(message)
+}
+
+// rejectWrite reports a failed stream write, as LIMIT_EXCEEDED with the limit
+// when a configured limit refused it
+func (h *WebSocketMessageHandler) rejectWrite(conn *websocket.Conn, err error) error {
+	message := fmt.Sprintf("Failed to write to stream: %v", err)
+	if errors.Is(err, memory.ErrWriteTimeout) {
+		// The read loop must not wait on a stalled disk again; closing the
+		// connection ends it and marks the upload incomplete
+		err := h.rejectCoded(conn, NewCodedErrorMessage(ErrCodeWriteTimeout, message))
+		conn.Close()
+		return err
+	}
+	var limitErr *memory.LimitError
+	if errors.As(err, &limitErr) {
+		return h.rejectCoded(conn, NewLimitErrorMessage(ErrCodeLimitExceeded, message, limitErr.Name, limitErr.Limit))
+	}
+	return h.reject(conn, message)
+}
+
+// rejectCoded sends a coded ERROR response and returns it as an error
+func (h *WebSocketMessageHandler) rejectCoded(conn *websocket.Conn, response *WebSocketMessage) error {
+	h.sendJSON(conn, response)
+	return fmt.Errorf("%s: %s", response.Code, response.Message)
 }
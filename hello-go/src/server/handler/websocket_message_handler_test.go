@@ -0,0 +1,477 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+)
+
+func TestLimitErrorsStateTheLimit(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+
+	declared := int64(5)
+	client.send(WebSocketMessage{Type: "START", StreamId: "declared-limit", Size: &declared})
+	client.expect("STARTED")
+	client.sendBinary([]byte("123456"))
+	refused := client.expectError(ErrCodeLimitExceeded)
+	if refused.LimitName != "declaredSize" || refused.LimitValue == nil || *refused.LimitValue != declared {
+		t.Fatalf("limit = %q %v, want declaredSize %d", refused.LimitName, refused.LimitValue, declared)
+	}
+	if !strings.Contains(refused.Message, "declared size 5") {
+		t.Errorf("message %q does not state the declared size", refused.Message)
+	}
+
+	s.streamManager.SetMaxUploadDuration(time.Millisecond)
+	client.start("duration-limit")
+	time.Sleep(5 * time.Millisecond)
+	client.sendBinary([]byte("late"))
+	refused = client.expectError(ErrCodeLimitExceeded)
+	if refused.LimitName != "maxUploadDurationMs" || refused.LimitValue == nil || *refused.LimitValue != 1 {
+		t.Fatalf("limit = %q %v, want maxUploadDurationMs 1", refused.LimitName, refused.LimitValue)
+	}
+	if !strings.Contains(refused.Message, "limit 1 ms") {
+		t.Errorf("message %q does not state the duration limit", refused.Message)
+	}
+}
+
+func TestCloseMarksUploadsIncomplete(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	finished := s.dial(t)
+	idle := s.dial(t)
+
+	uploader.start("close-abandoned") // Left unfinished by the next START
+	uploader.start("close-uploading")
+	uploader.sendBinary([]byte("partial"))
+	uploader.status("close-uploading") // The frame has been written once STATUS answers
+	finished.upload("close-ready", []byte("complete"))
+	idle.status("close-ready")
+
+	s.handler.Close()
+
+	s.clientsMutex.RLock()
+	clients, connections := len(s.clients), len(s.handler.connections)
+	s.clientsMutex.RUnlock()
+	if clients != 0 || connections != 0 {
+		t.Fatalf("after Close: %d clients and %d connections, want none", clients, connections)
+	}
+	if info, _ := s.streamManager.GetStreamInfo("close-uploading"); info.Status != memory.StatusIncomplete {
+		t.Errorf("uploading stream is %s after Close, want INCOMPLETE", info.Status)
+	}
+	if info, _ := s.streamManager.GetStreamInfo("close-abandoned"); info.Status != memory.StatusIncomplete {
+		t.Errorf("abandoned stream is %s after Close, want INCOMPLETE", info.Status)
+	}
+	if info, _ := s.streamManager.GetStreamInfo("close-ready"); info.Status != memory.StatusReady {
+		t.Errorf("finished stream is %s after Close, want READY", info.Status)
+	}
+}
+
+func TestCloseFlushesAndStopsGetWorkers(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMinChunkSize(1024)
+	s.handler.SetMaxInflightGets(2)
+	client := s.dial(t)
+
+	client.start("close-buffered")
+	client.sendBinary([]byte("short"))
+	client.status("close-buffered") // The frame has been buffered once STATUS answers
+
+	s.clientsMutex.RLock()
+	var workers []chan *WebSocketMessage
+	for _, state := range s.handler.connections {
+		workers = append(workers, state.gets)
+	}
+	s.clientsMutex.RUnlock()
+
+	s.handler.Close()
+
+	for _, gets := range workers {
+		select {
+		case _, open := <-gets:
+			if open {
+				t.Fatal("a GET was queued after Close")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("GET worker channel still open after Close")
+		}
+	}
+	info, _ := s.streamManager.GetStreamInfo("close-buffered")
+	if info.Status != memory.StatusIncomplete || info.Size != int64(len("short")) {
+		t.Errorf("buffered stream is %s with %d bytes after Close, want INCOMPLETE with %d", info.Status, info.Size, len("short"))
+	}
+}
+
+func TestStreamCapPerConnection(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMaxStreamsPerConnection(2)
+	client := s.dial(t)
+
+	client.start("cap-1")
+	client.start("cap-2") // cap-1 is left unfinished and still counts
+	client.send(WebSocketMessage{Type: "START", StreamId: "cap-3"})
+	refused := client.expectError(ErrCodeLimitExceeded)
+	if refused.LimitName != "maxStreamsPerConnection" || refused.LimitValue == nil || *refused.LimitValue != 2 {
+		t.Fatalf("limit = %q %v, want maxStreamsPerConnection 2", refused.LimitName, refused.LimitValue)
+	}
+	if _, ok := s.streamManager.GetStreamInfo("cap-3"); ok {
+		t.Fatal("the refused stream was created")
+	}
+
+	// The cap is per connection
+	s.dial(t).start("cap-other")
+
+	// Finishing a stream frees a slot
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "cap-2"})
+	client.expect("STOPPED")
+	client.start("cap-3")
+}
+
+func TestDeleteTwice(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+	client.upload("delete-twice", []byte("to be deleted"))
+	cachePath := s.streamManager.GetStream("delete-twice").CachePath
+
+	client.send(WebSocketMessage{Type: "DELETE", StreamId: "delete-twice"})
+	if deleted := client.expect("DELETED"); deleted.StreamId != "delete-twice" || deleted.Message != "Stream deleted" {
+		t.Fatalf("first DELETE answered %+v", deleted)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Fatalf("cache file still present after DELETE: %v", err)
+	}
+
+	// Deleting again succeeds, so cleanup can be retried
+	client.send(WebSocketMessage{Type: "DELETE", StreamId: "delete-twice"})
+	if deleted := client.expect("DELETED"); deleted.Message != "Stream already deleted" {
+		t.Fatalf("second DELETE answered %+v", deleted)
+	}
+	if count := s.streamManager.DeletedStreams(); count != 1 {
+		t.Fatalf("%d deletions counted, want 1", count)
+	}
+}
+
+func TestUploadSameContentTwice(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+	data := []byte("identical content")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	client.send(WebSocketMessage{Type: "START", StreamId: "dedup-first", Sha256: checksum})
+	client.expect("STARTED")
+	client.sendBinary(data)
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "dedup-first"})
+	client.expect("STOPPED")
+
+	client.send(WebSocketMessage{Type: "START", StreamId: "dedup-second", Sha256: checksum})
+	existing := client.expect("ALREADY_EXISTS")
+	if existing.StreamId != "dedup-first" || existing.Size == nil || *existing.Size != int64(len(data)) || existing.Digest != checksum {
+		t.Fatalf("ALREADY_EXISTS %+v, want dedup-first with %d bytes and digest %s", existing, len(data), checksum)
+	}
+	if _, ok := s.streamManager.GetStreamInfo("dedup-second"); ok {
+		t.Fatal("the deduplicated stream was created")
+	}
+
+	// Other content, or content whose stream was deleted, is uploaded
+	other := sha256.Sum256([]byte("other content"))
+	client.send(WebSocketMessage{Type: "START", StreamId: "dedup-other", Sha256: hex.EncodeToString(other[:])})
+	client.expect("STARTED")
+	client.send(WebSocketMessage{Type: "DELETE", StreamId: "dedup-first"})
+	client.expect("DELETED")
+	client.send(WebSocketMessage{Type: "START", StreamId: "dedup-again", Sha256: checksum})
+	client.expect("STARTED")
+}
+
+func TestGetRangeLength(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+	client.upload("range-length", []byte("0123456789"))
+
+	// A zero-length GET gets an empty frame
+	client.get("range-length", 3, 0)
+	if data := client.expectBinary(); len(data) != 0 {
+		t.Fatalf("zero-length GET returned %q", data)
+	}
+	offset, length := int64(3), 0
+	client.send(WebSocketMessage{Type: "GET", StreamId: "range-length", Offset: &offset, Length: &length, Envelope: true})
+	if envelope := client.expect("DATA"); envelope.Length == nil || *envelope.Length != 0 || *envelope.Offset != 3 || envelope.Final {
+		t.Fatalf("zero-length envelope %+v, want offset 3, length 0, not final", envelope)
+	}
+	if data := client.expectBinary(); len(data) != 0 {
+		t.Fatalf("zero-length enveloped GET returned %q", data)
+	}
+
+	// Negative values are refused
+	client.get("range-length", 0, -1)
+	if refused := client.expectError(ErrCodeInvalidRange); !strings.Contains(refused.Message, "length -1") {
+		t.Fatalf("message %q does not name the length", refused.Message)
+	}
+	client.get("range-length", -5, 4)
+	if refused := client.expectError(ErrCodeInvalidRange); !strings.Contains(refused.Message, "offset -5") {
+		t.Fatalf("message %q does not name the offset", refused.Message)
+	}
+
+	// The connection still serves GETs afterwards
+	client.get("range-length", 2, 3)
+	if data := client.expectBinary(); string(data) != "234" {
+		t.Fatalf("GET after the refusals returned %q", data)
+	}
+}
+
+func TestGetBeyondEndOfStream(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+	client.upload("range-end", []byte("0123456789"))
+
+	// At or past the end of a finalized stream is permanent and states the size
+	for _, offset := range []int64{10, 11, 1 << 40} {
+		client.get("range-end", offset, 4)
+		refused := client.expectError(ErrCodeOutOfRange)
+		if refused.Size == nil || *refused.Size != 10 {
+			t.Errorf("OUT_OF_RANGE for offset %d carries size %v, want 10", offset, refused.Size)
+		}
+	}
+
+	// The last byte is still in range
+	client.get("range-end", 9, 4)
+	if data := client.expectBinary(); string(data) != "9" {
+		t.Errorf("GET of the last byte returned %q", data)
+	}
+
+	// On a stream still uploading the same offsets are only not ready yet
+	client.start("range-live")
+	client.sendBinary([]byte("01234"))
+	for _, offset := range []int64{5, 6} {
+		client.get("range-live", offset, 4)
+		refused := client.expectError(ErrCodeNotReady)
+		if refused.Size == nil || *refused.Size != 5 {
+			t.Errorf("NOT_READY for offset %d carries size %v, want 5", offset, refused.Size)
+		}
+	}
+}
+
+func TestWriteBeyondDeclaredSize(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+	size := int64(10)
+
+	// Exactly the declared size is accepted, one more byte is not
+	client.send(WebSocketMessage{Type: "START", StreamId: "declared", Size: &size})
+	client.expect("STARTED")
+	client.sendBinary([]byte("0123456"))
+	client.sendBinary([]byte("789"))
+	client.sendBinary([]byte("!"))
+	refused := client.expectError(ErrCodeLimitExceeded)
+	if refused.LimitName != "declaredSize" || refused.LimitValue == nil || *refused.LimitValue != size {
+		t.Errorf("ERROR names limit %s=%v, want declaredSize=10", refused.LimitName, refused.LimitValue)
+	}
+	if info, _ := s.streamManager.GetStreamInfo("declared"); info.Size != size {
+		t.Errorf("stream holds %d bytes, want the declared %d", info.Size, size)
+	}
+
+	// A single frame overshooting the declared size is refused whole
+	client.send(WebSocketMessage{Type: "START", StreamId: "declared-frame", Size: &size})
+	client.expect("STARTED")
+	client.sendBinary([]byte("0123456789!"))
+	client.expectError(ErrCodeLimitExceeded)
+	if info, _ := s.streamManager.GetStreamInfo("declared-frame"); info.Size != 0 {
+		t.Errorf("stream holds %d bytes of a refused frame", info.Size)
+	}
+
+	// Without a declared size nothing is enforced
+	client.upload("undeclared", []byte("0123456789!"))
+	if info, _ := s.streamManager.GetStreamInfo("undeclared"); info.Size != 11 {
+		t.Errorf("undeclared stream holds %d bytes, want 11", info.Size)
+	}
+}
+
+func TestMaxUploadDuration(t *testing.T) {
+	s := newTestServer(t)
+	s.streamManager.SetMaxUploadDuration(time.Minute)
+	client := s.dial(t)
+
+	client.start("slow-upload")
+	client.sendBinary([]byte("in time"))
+	client.status("slow-upload") // The frame is written once STATUS answers
+
+	// Backdate the stream as if it had trickled bytes for two minutes
+	stream := s.streamManager.GetStream("slow-upload")
+	stream.Mu.Lock()
+	stream.CreatedAt = time.Now().Add(-2 * time.Minute)
+	stream.Mu.Unlock()
+
+	client.sendBinary([]byte("too late"))
+	refused := client.expectError(ErrCodeLimitExceeded)
+	if refused.LimitName != "maxUploadDurationMs" || refused.LimitValue == nil || *refused.LimitValue != 60000 {
+		t.Errorf("ERROR names limit %s=%v, want maxUploadDurationMs=60000", refused.LimitName, refused.LimitValue)
+	}
+	if msg := client.status("slow-upload"); msg.Status != string(memory.StatusError) || msg.Size == nil || *msg.Size != 7 {
+		t.Errorf("stream is %s with %v bytes, want ERROR with the 7 bytes sent in time", msg.Status, msg.Size)
+	}
+
+	// A stream younger than the limit is unaffected
+	client.upload("quick-upload", []byte("in time"))
+}
+
+func TestEmptyBinaryFrame(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			s := newTestServer(t)
+			s.handler.SetStrictFrames(strict)
+			client := s.dial(t)
+
+			client.start("empty-frame")
+			client.sendBinary([]byte("01234"))
+			client.sendBinary([]byte{})
+			if strict {
+				if refused := client.expectError(""); !strings.Contains(refused.Message, "Empty binary frame") {
+					t.Errorf("strict mode refused with %q", refused.Message)
+				}
+			}
+			msg := client.status("empty-frame")
+			if msg.Size == nil || *msg.Size != 5 {
+				t.Fatalf("offset is %v after an empty frame, want 5", msg.Size)
+			}
+			if info, _ := s.streamManager.GetStreamInfo("empty-frame"); info.WriteCount != 1 {
+				t.Errorf("%d writes, want the empty frame not written", info.WriteCount)
+			}
+
+			// The upload goes on where it was
+			client.sendBinary([]byte("56789"))
+			client.send(WebSocketMessage{Type: "STOP", StreamId: "empty-frame"})
+			client.expect("STOPPED")
+			client.get("empty-frame", 0, 10)
+			if data := client.expectBinary(); string(data) != "0123456789" {
+				t.Errorf("stream holds %q", data)
+			}
+		})
+	}
+}
+
+func TestDisconnectMidUploadMarksStreamIncomplete(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.dial(t)
+	reader := s.dial(t)
+
+	uploader.upload("finished-first", []byte("complete"))
+	uploader.start("truncated")
+	uploader.sendBinary([]byte("only part of it"))
+	uploader.status("truncated") // The frame is written once STATUS answers
+	uploader.conn.Close()
+	s.waitDisconnect(t)
+
+	if msg := reader.status("truncated"); msg.Status != string(memory.StatusIncomplete) {
+		t.Errorf("STATUS of the truncated upload is %s, want INCOMPLETE", msg.Status)
+	}
+	reader.send(WebSocketMessage{Type: "LIST"})
+	statuses := map[string]string{}
+	for _, summary := range reader.expect("LIST_RESULT").Streams {
+		statuses[summary.StreamId] = summary.Status
+	}
+	if statuses["truncated"] != string(memory.StatusIncomplete) || statuses["finished-first"] != string(memory.StatusReady) {
+		t.Errorf("LIST statuses %v, want truncated INCOMPLETE and finished-first READY", statuses)
+	}
+
+	// The partial data is not served as if it were the whole file
+	reader.get("truncated", 0, 4)
+	reader.expect("ERROR")
+	reader.get("finished-first", 0, 8)
+	if data := reader.expectBinary(); string(data) != "complete" {
+		t.Errorf("finished stream returned %q", data)
+	}
+}
+
+func TestProtocolVersionMismatch(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+
+	// Supported versions, and none at all, are accepted; STARTED states the server's
+	for i, version := range []int{0, MinProtocolVersion, ProtocolVersion} {
+		streamID := fmt.Sprintf("version-%d-%d", i, version)
+		client.send(WebSocketMessage{Type: "START", StreamId: streamID, Version: version})
+		if started := client.expect("STARTED"); started.Version != ProtocolVersion {
+			t.Errorf("STARTED for client version %d advertises %d, want %d", version, started.Version, ProtocolVersion)
+		}
+		client.send(WebSocketMessage{Type: "STOP", StreamId: streamID})
+		client.expect("STOPPED")
+	}
+
+	// Newer or older clients are refused before a stream is created
+	for _, version := range []int{ProtocolVersion + 1, -1} {
+		streamID := fmt.Sprintf("version-%d", version)
+		client.send(WebSocketMessage{Type: "START", StreamId: streamID, Version: version})
+		if refused := client.expect("ERROR"); !strings.Contains(refused.Message, "Unsupported protocol version") {
+			t.Errorf("client version %d refused with %q", version, refused.Message)
+		}
+		if _, exists := s.streamManager.GetStreamInfo(streamID); exists {
+			t.Errorf("stream for client version %d was created", version)
+		}
+	}
+}
+
+func TestCapabilitiesReflectSettings(t *testing.T) {
+	s := newTestServer(t)
+	client := s.dial(t)
+	client.send(WebSocketMessage{Type: "CAPABILITIES"})
+	defaults := client.expect("CAPABILITIES").Capabilities
+	if !defaults.LiveReads || !defaults.Dedup || defaults.MaxMessageSize != 0 || defaults.MaxInflightGets != 0 || defaults.MinChunkSize != 0 {
+		t.Fatalf("default capabilities = %+v", *defaults)
+	}
+
+	s = newTestServer(t)
+	s.handler.SetLiveReads(false)
+	s.handler.SetMaxMessageSize(64 * 1024)
+	s.handler.SetMaxInflightGets(4)
+	s.handler.SetMinChunkSize(512)
+	s.streamManager.SetDigestAlgorithm(memory.DigestTreeSHA256) // Tree digests are not indexed for dedup
+	client = s.dial(t)
+	client.send(WebSocketMessage{Type: "CAPABILITIES"})
+	configured := client.expect("CAPABILITIES").Capabilities
+	if configured.LiveReads || configured.Dedup || configured.MaxMessageSize != 64*1024 || configured.MaxInflightGets != 4 || configured.MinChunkSize != 512 {
+		t.Fatalf("configured capabilities = %+v", *configured)
+	}
+}
+
+func TestLiveReadsDisabled(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetLiveReads(false)
+	client := s.dial(t)
+
+	client.start("live-off")
+	client.sendBinary([]byte("partial"))
+	client.status("live-off") // The frame has been written once STATUS answers
+	client.get("live-off", 0, 4)
+	refused := client.expectError(ErrCodeNotReady)
+	if refused.Size == nil || *refused.Size != int64(len("partial")) {
+		t.Errorf("NOT_READY size = %v, want %d", refused.Size, len("partial"))
+	}
+
+	client.send(WebSocketMessage{Type: "STOP", StreamId: "live-off"})
+	client.expect("STOPPED")
+	client.get("live-off", 0, 4)
+	if _, data := client.next(); string(data) != "part" {
+		t.Fatalf("GET after STOP = %q, want %q", data, "part")
+	}
+}
+
+func TestMaxMessageSizeClosesConnection(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetMaxMessageSize(1024)
+	client := s.dial(t)
+
+	client.start("too-big")
+	client.sendBinary(make([]byte, 1024)) // At the limit
+	client.status("too-big")
+	client.sendBinary(make([]byte, 1025))
+	s.waitDisconnect(t)
+	if info, _ := s.streamManager.GetStreamInfo("too-big"); info.Size != 1024 {
+		t.Errorf("stream holds %d bytes, want only the 1024 within the limit", info.Size)
+	}
+}
@@ -0,0 +1,55 @@
+package memory
+
+import "bytes"
+
+// Audio formats recognized from a stream's leading bytes
+const (
+	FormatWAV  = "wav"
+	FormatMP3  = "mp3"
+	FormatOGG  = "ogg"
+	FormatFLAC = "flac"
+)
+
+// audioFormatSniffLength is enough of a stream to recognize every format
+const audioFormatSniffLength = 12
+
+// DetectAudioFormat recognizes an audio container from the first bytes of a
+// stream, returning "" when the format is unknown
+func DetectAudioFormat(header []byte) string {
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return FormatWAV
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return FormatOGG
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return FormatMP3
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return FormatMP3 // MPEG audio frame sync without an ID3 tag
+	default:
+		return ""
+	}
+}
+
+// AudioContentType returns the MIME type for a detected format,
+// application/octet-stream when it is unknown
+func AudioContentType(format string) string {
+	switch format {
+	case FormatWAV:
+		return "audio/wav"
+	case FormatMP3:
+		return "audio/mpeg"
+	case FormatOGG:
+		return "audio/ogg"
+	case FormatFLAC:
+		return "audio/flac"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// DetectStreamFormat reads the start of a stream and detects its format
+func (sm *StreamManager) DetectStreamFormat(streamID string) string {
+	return DetectAudioFormat(sm.ReadChunk(streamID, 0, audioFormatSniffLength))
+}
@@ -0,0 +1,60 @@
+package memory
+
+import "testing"
+
+func TestDetectAudioFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"wav", "RIFF\x24\x00\x00\x00WAVEfmt ", FormatWAV},
+		{"riff without WAVE", "RIFF\x24\x00\x00\x00AVI LIST", ""},
+		{"short riff", "RIFF", ""},
+		{"ogg", "OggS\x00\x02", FormatOGG},
+		{"flac", "fLaC\x00\x00\x00\x22", FormatFLAC},
+		{"mp3 with ID3", "ID3\x04\x00", FormatMP3},
+		{"mp3 frame sync", "\xff\xfb\x90\x64", FormatMP3},
+		{"unknown", "plain bytes", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectAudioFormat([]byte(tt.header)); got != tt.want {
+				t.Errorf("DetectAudioFormat(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioContentType(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatWAV, "audio/wav"},
+		{FormatMP3, "audio/mpeg"},
+		{FormatOGG, "audio/ogg"},
+		{FormatFLAC, "audio/flac"},
+		{"", "application/octet-stream"},
+		{"aiff", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := AudioContentType(tt.format); got != tt.want {
+			t.Errorf("AudioContentType(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestDetectStreamFormat(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	if err := uploadStream(sm, "detect-flac", []byte("fLaC\x00\x00\x00\x22frames"), 2); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if got := sm.DetectStreamFormat("detect-flac"); got != FormatFLAC {
+		t.Fatalf("DetectStreamFormat = %q, want %q", got, FormatFLAC)
+	}
+	if got := sm.DetectStreamFormat("missing"); got != "" {
+		t.Fatalf("DetectStreamFormat of a missing stream = %q, want none", got)
+	}
+}
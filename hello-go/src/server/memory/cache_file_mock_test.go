@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"os"
+	"testing"
+)
+
+// mockCacheFile wraps a real cache file; each hook that is set replaces
+// the corresponding method
+type mockCacheFile struct {
+	CacheFile
+	writeAt func(file CacheFile, p []byte, off int64) (int, error)
+	readAt  func(file CacheFile, p []byte, off int64) (int, error)
+	sync    func(file CacheFile) error
+}
+
+func (m *mockCacheFile) WriteAt(p []byte, off int64) (int, error) {
+	if m.writeAt != nil {
+		return m.writeAt(m.CacheFile, p, off)
+	}
+	return m.CacheFile.WriteAt(p, off)
+}
+
+func (m *mockCacheFile) ReadAt(p []byte, off int64) (int, error) {
+	if m.readAt != nil {
+		return m.readAt(m.CacheFile, p, off)
+	}
+	return m.CacheFile.ReadAt(p, off)
+}
+
+func (m *mockCacheFile) Sync() error {
+	if m.sync != nil {
+		return m.sync(m.CacheFile)
+	}
+	return m.CacheFile.Sync()
+}
+
+// useMockCacheFiles makes every cache file opened until the test ends a
+// copy of mock wrapping the real file
+func useMockCacheFiles(t *testing.T, mock mockCacheFile) {
+	t.Helper()
+	previous := openCacheFile
+	openCacheFile = func(path string, flag int, perm os.FileMode) (CacheFile, error) {
+		file, err := previous(path, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		wrapped := mock
+		wrapped.CacheFile = file
+		return &wrapped, nil
+	}
+	t.Cleanup(func() { openCacheFile = previous })
+}
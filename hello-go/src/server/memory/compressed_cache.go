@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CompressedBlockSize is the uncompressed length of each gzip member of a
+// compressed cache file; a read decompresses at most the members it covers
+const CompressedBlockSize = 256 * 1024
+
+// CompressedCache is a finalized stream stored gzip-compressed at rest as
+// consecutive gzip members, one per CompressedBlockSize of data. The file is
+// a valid multi-member gzip file; the member offsets are indexed in memory
+// so random-access reads start at the nearest block boundary.
+type CompressedCache struct {
+	path    string
+	size    int64   // Uncompressed size
+	offsets []int64 // File offset of each member, followed by the file length
+}
+
+// compressCache writes size bytes read through read to path as a compressed cache
+func compressCache(read readRange, size int64, path string) (*CompressedCache, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &CompressedCache{path: path, size: size}
+	err = func() error {
+		counter := &countingWriter{w: file}
+		gz := gzip.NewWriter(counter)
+		for offset := int64(0); offset < size; offset += CompressedBlockSize {
+			block, err := read(offset, int(min(CompressedBlockSize, size-offset)))
+			if err != nil {
+				return fmt.Errorf("failed to read at offset %d: %w", offset, err)
+			}
+			cache.offsets = append(cache.offsets, counter.n)
+			gz.Reset(counter)
+			if _, err := gz.Write(block); err != nil {
+				return err
+			}
+			if err := gz.Close(); err != nil {
+				return err
+			}
+		}
+		cache.offsets = append(cache.offsets, counter.n)
+		return file.Sync()
+	}()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Read returns up to length bytes of the uncompressed stream starting at offset
+func (c *CompressedCache) Read(offset int64, length int) ([]byte, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+	if offset >= c.size || length <= 0 {
+		return []byte{}, nil
+	}
+	end := min(offset+int64(length), c.size)
+
+	file, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, 0, end-offset)
+	for b := offset / CompressedBlockSize; b*CompressedBlockSize < end; b++ {
+		member := io.NewSectionReader(file, c.offsets[b], c.offsets[b+1]-c.offsets[b])
+		gz, err := gzip.NewReader(member)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", b, err)
+		}
+		block, err := io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", b, err)
+		}
+
+		blockStart := b * CompressedBlockSize
+		from := max(offset, blockStart) - blockStart
+		to := min(end, blockStart+int64(len(block))) - blockStart
+		data = append(data, block[from:to]...)
+	}
+	return data, nil
+}
+
+// CompressedSize returns the size of the compressed file
+func (c *CompressedCache) CompressedSize() int64 {
+	return c.offsets[len(c.offsets)-1]
+}
+
+// Delete removes the compressed file
+func (c *CompressedCache) Delete() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
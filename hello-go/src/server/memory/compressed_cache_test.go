@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFrom serves reads from data like a cache file
+func readFrom(data []byte) readRange {
+	return func(offset int64, length int) ([]byte, error) {
+		return data[offset:min(offset+int64(length), int64(len(data)))], nil
+	}
+}
+
+func newCompressedCache(t *testing.T, data []byte) *CompressedCache {
+	t.Helper()
+	cache, err := compressCache(readFrom(data), int64(len(data)), filepath.Join(t.TempDir(), "stream.gz"))
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	return cache
+}
+
+func TestCompressedCacheRoundTrip(t *testing.T) {
+	data := streamPayload(1234, 2*CompressedBlockSize+CompressedBlockSize/2)
+	cache := newCompressedCache(t, data)
+
+	got, err := cache.Read(0, len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Read of the whole stream = %d bytes, %v; want the %d written", len(got), err, len(data))
+	}
+
+	// The file is an ordinary multi-member gzip file
+	file, err := os.Open(cache.path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if got, err := io.ReadAll(gz); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("gunzip = %d bytes, %v; want the %d written", len(got), err, len(data))
+	}
+	if stat, _ := file.Stat(); stat.Size() != cache.CompressedSize() {
+		t.Fatalf("CompressedSize = %d, file holds %d", cache.CompressedSize(), stat.Size())
+	}
+}
+
+func TestCompressedCacheRandomAccess(t *testing.T) {
+	const block = CompressedBlockSize
+	data := streamPayload(1234, 3*block+100)
+	size := int64(len(data))
+	cache := newCompressedCache(t, data)
+
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+		want   []byte
+	}{
+		{"first byte", 0, 1, data[:1]},
+		{"last byte of a block", block - 1, 1, data[block-1 : block]},
+		{"first byte of a block", block, 1, data[block : block+1]},
+		{"across one boundary", block - 10, 20, data[block-10 : block+10]},
+		{"whole middle block", block, block, data[block : 2*block]},
+		{"across three blocks", 10, 2 * block, data[10 : 2*block+10]},
+		{"short last block", 3 * block, 100, data[3*block:]},
+		{"past the end", size - 5, 100, data[size-5:]},
+		{"at the end", size, 10, []byte{}},
+		{"zero length", 100, 0, []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cache.Read(tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("Read(%d, %d): %v", tt.offset, tt.length, err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Read(%d, %d) = %d bytes, want %d bytes of the stream", tt.offset, tt.length, len(got), len(tt.want))
+			}
+		})
+	}
+
+	if _, err := cache.Read(-1, 10); err == nil {
+		t.Fatal("Read at a negative offset succeeded")
+	}
+}
+
+func TestCompressAtRest(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetCompressAtRest(true)
+
+	data := bytes.Repeat([]byte("compressible audio "), CompressedBlockSize/10)
+	if err := uploadStream(sm, "at-rest", data, 65536); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	stream := sm.GetStream("at-rest")
+	if stream.Compressed == nil || stream.Compressed.CompressedSize() >= int64(len(data)) {
+		t.Fatalf("finalized stream is not stored compressed")
+	}
+	if got := sm.ReadChunk("at-rest", CompressedBlockSize-7, 50); !bytes.Equal(got, data[CompressedBlockSize-7:CompressedBlockSize+43]) {
+		t.Fatalf("read across a block boundary = %q", got)
+	}
+	if got := sm.ReadChunk("at-rest", 0, len(data)); !bytes.Equal(got, data) {
+		t.Fatalf("read back %d bytes that differ from the %d written", len(got), len(data))
+	}
+}
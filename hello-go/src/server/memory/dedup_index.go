@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"strings"
+	"sync"
+)
+
+// dedupIndex maps the SHA-256 digest of finalized streams to their IDs, so an
+// upload of content the server already holds can be skipped
+type dedupIndex struct {
+	streams map[string]string // Lowercase hex SHA-256 to stream ID
+	mutex   sync.Mutex
+}
+
+func newDedupIndex() *dedupIndex {
+	return &dedupIndex{streams: make(map[string]string)}
+}
+
+// add records a finalized stream's digest; only whole-file SHA-256 digests
+// are indexed, as that is what clients can compute up front
+func (d *dedupIndex) add(digest, algorithm, streamID string) {
+	if digest == "" || algorithm != DigestSHA256 {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.streams[strings.ToLower(digest)] = streamID
+}
+
+// remove drops a deleted stream's entry, unless the digest now maps to another stream
+func (d *dedupIndex) remove(digest, streamID string) {
+	key := strings.ToLower(digest)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.streams[key] == streamID {
+		delete(d.streams, key)
+	}
+}
+
+func (d *dedupIndex) lookup(digest string) (string, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	streamID, ok := d.streams[strings.ToLower(digest)]
+	return streamID, ok
+}
+
+// DedupEnabled reports whether finalized streams are indexed for START
+// dedup, which needs plain SHA-256 digests
+func (sm *StreamManager) DedupEnabled() bool {
+	return sm.digestAlgorithm == DigestSHA256
+}
+
+// FindBySHA256 returns the READY stream whose content has the given SHA-256
+// hex digest, if the server holds one
+func (sm *StreamManager) FindBySHA256(digest string) (StreamInfo, bool) {
+	streamID, ok := sm.dedup.lookup(digest)
+	if !ok {
+		return StreamInfo{}, false
+	}
+	info, ok := sm.GetStreamInfo(streamID)
+	if !ok || info.Status != StatusReady {
+		return StreamInfo{}, false
+	}
+	return info, true
+}
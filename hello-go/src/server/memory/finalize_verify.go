@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrReadBackMismatch fails the finalize of a stream whose file on disk does
+// not hash to what was written
+var ErrReadBackMismatch = errors.New("read-back digest mismatch")
+
+// SetVerifyOnFinalize hashes every chunk as it is written and, on finalize,
+// re-reads the whole file and compares digests before the stream becomes
+// READY. A mismatch (storage corruption) moves the stream to ERROR instead.
+func (sm *StreamManager) SetVerifyOnFinalize(verify bool) {
+	sm.verifyOnFinalize = verify
+}
+
+// verifyReadBack compares the stream's incremental write digest with a
+// digest of the finalized file (caller holds stream.Mu)
+func verifyReadBack(stream *StreamContext) error {
+	written := hex.EncodeToString(stream.writeDigest.Sum(nil))
+	readBack, err := computeDigest(stream.MmapFile.Read, stream.TotalSize, DigestSHA256)
+	if err != nil {
+		return fmt.Errorf("failed to read back %d bytes: %w", stream.TotalSize, err)
+	}
+	if readBack != written {
+		return fmt.Errorf("%w: wrote %s, read back %s", ErrReadBackMismatch, written, readBack)
+	}
+	return nil
+}
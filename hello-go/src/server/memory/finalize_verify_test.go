@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyOnFinalize(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetVerifyOnFinalize(true)
+
+	data := streamPayload(1249, 200000)
+	if err := uploadStream(sm, "verify-ok", data, 8192); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if info, _ := sm.GetStreamInfo("verify-ok"); info.Status != StatusReady {
+		t.Fatalf("stream is %s, want READY", info.Status)
+	}
+}
+
+func TestVerifyOnFinalizeDetectsCorruption(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetVerifyOnFinalize(true)
+
+	// Storage returns one flipped bit in whatever covers offset 1000
+	useMockCacheFiles(t, mockCacheFile{
+		readAt: func(file CacheFile, p []byte, off int64) (int, error) {
+			n, err := file.ReadAt(p, off)
+			if corrupt := 1000 - off; corrupt >= 0 && corrupt < int64(n) {
+				p[corrupt] ^= 0x01
+			}
+			return n, err
+		},
+	})
+
+	err := uploadStream(sm, "verify-corrupt", streamPayload(1249, 200000), 8192)
+	if !errors.Is(err, ErrReadBackMismatch) || !errors.Is(err, ErrFinalizeFailed) {
+		t.Fatalf("finalize returned %v, want ErrFinalizeFailed wrapping ErrReadBackMismatch", err)
+	}
+	if info, _ := sm.GetStreamInfo("verify-corrupt"); info.Status != StatusError {
+		t.Fatalf("stream is %s after a mismatch, want ERROR", info.Status)
+	}
+}
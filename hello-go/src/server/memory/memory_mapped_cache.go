@@ -2,6 +2,7 @@ package memory
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sync"
 )
@@ -15,12 +16,30 @@ const (
 	BatchOperationLimit   int   = 1000                       // Max batch operations
 )
 
+// MaxShortWriteRetries bounds consecutive WriteAt calls that make no progress
+const MaxShortWriteRetries = 3
+
+// CacheFile is the subset of *os.File used by MemoryMappedCache
+type CacheFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+	Stat() (os.FileInfo, error)
+}
+
+// openCacheFile opens the backing file; replaceable to inject a CacheFile
+var openCacheFile = func(path string, flag int, perm os.FileMode) (CacheFile, error) {
+	return os.OpenFile(path, flag, perm)
+}
+
 // MemoryMappedCache manages memory-mapped file operations
 // Note: For Windows compatibility, we use file I/O instead of platform-specific mmap
 // Thread-safe with RWMutex for concurrent access
 type MemoryMappedCache struct {
 	path   string
-	file   *os.File
+	file   CacheFile
 	size   int64
 	isOpen bool
 	mu     sync.RWMutex // Protects all fields
@@ -51,7 +70,7 @@ func (mmc *MemoryMappedCache) createInternal(initialSize int64) error {
 	}
 
 	// Create new file with read/write permissions
-	file, err := os.OpenFile(mmc.path, os.O_RDWR|os.O_CREATE, 0644)
+	file, err := openCacheFile(mmc.path, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
@@ -83,7 +102,7 @@ func (mmc *MemoryMappedCache) openInternal() error {
 		return fmt.Errorf("file does not exist: %s", mmc.path)
 	}
 
-	file, err := os.OpenFile(mmc.path, os.O_RDWR, 0644)
+	file, err := openCacheFile(mmc.path, os.O_RDWR, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
@@ -137,10 +156,10 @@ func (mmc *MemoryMappedCache) Write(offset int64, data []byte) (int, error) {
 		mmc.size = requiredSize
 	}
 
-	// Write to file at offset
-	n, err := mmc.file.WriteAt(data, offset)
+	// Write to file at offset, retrying the remainder after short writes
+	n, err := mmc.writeFull(offset, data)
 	if err != nil {
-		return 0, fmt.Errorf("failed to write data: %w", err)
+		return n, fmt.Errorf("failed to write data: %w", err)
 	}
 
 	if offset+int64(n) > mmc.size {
@@ -150,6 +169,28 @@ func (mmc *MemoryMappedCache) Write(offset int64, data []byte) (int, error) {
 	return n, nil
 }
 
+// writeFull writes all of data at offset (internal, no lock)
+func (mmc *MemoryMappedCache) writeFull(offset int64, data []byte) (int, error) {
+	written := 0
+	retries := 0
+	for written < len(data) {
+		n, err := mmc.file.WriteAt(data[written:], offset+int64(written))
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			retries++
+			if retries > MaxShortWriteRetries {
+				return written, io.ErrShortWrite
+			}
+			continue
+		}
+		retries = 0
+	}
+	return written, nil
+}
+
 // Read reads data from specified offset
 func (mmc *MemoryMappedCache) Read(offset int64, length int) ([]byte, error) {
 	mmc.mu.Lock()
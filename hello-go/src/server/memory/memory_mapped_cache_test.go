@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// shortWrites makes WriteAt write at most limit bytes per call, and nothing
+// on the calls for which stall returns true
+func shortWrites(limit int, stall func(call int) bool) func(CacheFile, []byte, int64) (int, error) {
+	call := 0
+	return func(file CacheFile, p []byte, off int64) (int, error) {
+		call++
+		if stall != nil && stall(call) {
+			return 0, nil
+		}
+		return file.WriteAt(p[:min(limit, len(p))], off)
+	}
+}
+
+func TestWriteCompletesAfterShortWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		stall func(call int) bool
+	}{
+		{"partial writes", nil},
+		// Stalls below the retry limit are retried
+		{"intermittent stalls", func(call int) bool { return call%2 == 0 }},
+		{"stalls up to the limit", func(call int) bool { return call > 1 && call <= 1+MaxShortWriteRetries }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMockCacheFiles(t, mockCacheFile{writeAt: shortWrites(7, tt.stall)})
+			path := filepath.Join(t.TempDir(), "short.dat")
+			cache := NewMemoryMappedCache(path)
+			defer cache.Close()
+
+			data := streamPayload(1177, 100)
+			n, err := cache.Write(0, data)
+			if err != nil || n != len(data) {
+				t.Fatalf("Write = %d, %v; want all %d bytes", n, err, len(data))
+			}
+			cache.Close()
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+				t.Fatalf("file holds %q, want %q", got, data)
+			}
+		})
+	}
+}
+
+func TestWriteGivesUpWhenNoProgress(t *testing.T) {
+	useMockCacheFiles(t, mockCacheFile{writeAt: shortWrites(7, func(call int) bool { return call > 2 })})
+	cache := NewMemoryMappedCache(filepath.Join(t.TempDir(), "stuck.dat"))
+	defer cache.Close()
+
+	n, err := cache.Write(0, make([]byte, 100))
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("Write returned %v, want io.ErrShortWrite", err)
+	}
+	if n != 14 {
+		t.Fatalf("Write reported %d bytes, want the 14 written before the stall", n)
+	}
+}
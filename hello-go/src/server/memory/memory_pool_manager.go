@@ -1,8 +1,11 @@
 package memory
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
 )
@@ -14,6 +17,36 @@ type MemoryPoolManager struct {
 	availableBuffers chan []byte
 	totalBuffers     int
 	mutex            sync.Mutex
+
+	targetBuffers int          // Buffers the pool currently aims to keep idle; guarded by mutex
+	minBuffers    int          // Shrink never drops below this many idle buffers
+	acquires      atomic.Int64 // AcquireBuffer calls, used to detect idle periods
+
+	// Buffers handed out and not yet released may not exceed poolSize plus
+	// maxOverflow; -1 allocates without bound. Guarded by mutex.
+	inUse          int
+	maxOverflow    int
+	acquireTimeout time.Duration // How long AcquireBuffer waits at the cap; 0 waits indefinitely
+	overflowAllocs atomic.Int64  // Buffers allocated because the pool was empty
+	timeouts       atomic.Int64  // AcquireBuffer calls that gave up at the cap
+}
+
+// ErrPoolExhausted is returned by AcquireBuffer when the buffer cap is reached
+// and no buffer was released within the acquire timeout
+var ErrPoolExhausted = errors.New("memory pool exhausted")
+
+// PoolStats is a snapshot of pool sizes for metrics
+type PoolStats struct {
+	BufferSize int `json:"bufferSize"`
+	Available  int `json:"available"` // Idle buffers in the pool
+	Total      int `json:"total"`     // Buffers allocated and not yet dropped
+	Target     int `json:"target"`    // Idle buffers the pool aims to keep
+	Capacity   int `json:"capacity"`  // Upper bound for Grow
+	InUse      int `json:"inUse"`     // Buffers acquired and not yet released
+	MaxInUse   int `json:"maxInUse"`  // Cap on InUse (pool plus overflow); 0 means unlimited
+
+	OverflowAllocations int64 `json:"overflowAllocations"` // Allocated because the pool was empty
+	AcquireTimeouts     int64 `json:"acquireTimeouts"`     // Acquires that failed at the cap
 }
 
 var (
@@ -24,38 +57,91 @@ var (
 // GetMemoryPoolManager returns the singleton instance
 func GetMemoryPoolManager(bufferSize, poolSize int) *MemoryPoolManager {
 	poolOnce.Do(func() {
-		poolInstance = &MemoryPoolManager{
-			bufferSize:       bufferSize,
-			poolSize:         poolSize,
-			availableBuffers: make(chan []byte, poolSize),
-			totalBuffers:     0,
-		}
-
-		// Pre-allocate buffers
-		for i := 0; i < poolSize; i++ {
-			buffer := make([]byte, bufferSize)
-			poolInstance.availableBuffers <- buffer
-			poolInstance.totalBuffers++
-		}
-
-		logger.Info(fmt.Sprintf("MemoryPoolManager initialized with %d buffers of %d bytes each", poolSize, bufferSize))
+		poolInstance = NewMemoryPoolManager(bufferSize, poolSize)
 	})
 	return poolInstance
 }
 
-// AcquireBuffer acquires a buffer from the pool
-func (mpm *MemoryPoolManager) AcquireBuffer() []byte {
+// NewMemoryPoolManager creates a pool of poolSize pre-allocated buffers,
+// independent of the singleton
+func NewMemoryPoolManager(bufferSize, poolSize int) *MemoryPoolManager {
+	mpm := &MemoryPoolManager{
+		bufferSize:       bufferSize,
+		poolSize:         poolSize,
+		availableBuffers: make(chan []byte, poolSize),
+		totalBuffers:     0,
+		targetBuffers:    poolSize,
+		minBuffers:       poolSize / 10,
+		maxOverflow:      -1,
+	}
+
+	// Pre-allocate buffers
+	for i := 0; i < poolSize; i++ {
+		buffer := make([]byte, bufferSize)
+		mpm.availableBuffers <- buffer
+		mpm.totalBuffers++
+	}
+
+	logger.Info(fmt.Sprintf("MemoryPoolManager initialized with %d buffers of %d bytes each", poolSize, bufferSize))
+	return mpm
+}
+
+// SetMaxOverflow caps the buffers in use at the pool size plus maxOverflow;
+// at the cap AcquireBuffer waits up to timeout (0 waits indefinitely) for a
+// release instead of allocating. A negative maxOverflow removes the cap.
+func (mpm *MemoryPoolManager) SetMaxOverflow(maxOverflow int, timeout time.Duration) {
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+	mpm.maxOverflow = maxOverflow
+	mpm.acquireTimeout = timeout
+}
+
+// AcquireBuffer acquires a buffer from the pool, allocating a new one when the
+// pool is empty. At the buffer cap it waits for a release instead and returns
+// ErrPoolExhausted if none comes within the acquire timeout.
+func (mpm *MemoryPoolManager) AcquireBuffer() ([]byte, error) {
+	mpm.acquires.Add(1)
 	select {
 	case buffer := <-mpm.availableBuffers:
-		return buffer
+		mpm.markInUse()
+		return buffer, nil
 	default:
+	}
+
+	mpm.mutex.Lock()
+	if mpm.maxOverflow < 0 || mpm.inUse < mpm.poolSize+mpm.maxOverflow {
 		// Pool exhausted, allocate new buffer
-		mpm.mutex.Lock()
 		buffer := make([]byte, mpm.bufferSize)
 		mpm.totalBuffers++
+		mpm.inUse++
 		mpm.mutex.Unlock()
-		return buffer
+		mpm.overflowAllocs.Add(1)
+		return buffer, nil
+	}
+	limit, timeout := mpm.poolSize+mpm.maxOverflow, mpm.acquireTimeout
+	mpm.mutex.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
 	}
+	select {
+	case buffer := <-mpm.availableBuffers:
+		mpm.markInUse()
+		return buffer, nil
+	case <-expired:
+		mpm.timeouts.Add(1)
+		return nil, fmt.Errorf("%w: %d buffers in use, none released within %v", ErrPoolExhausted, limit, timeout)
+	}
+}
+
+// markInUse counts a buffer taken from the pool as in use
+func (mpm *MemoryPoolManager) markInUse() {
+	mpm.mutex.Lock()
+	mpm.inUse++
+	mpm.mutex.Unlock()
 }
 
 // ReleaseBuffer releases a buffer back to the pool
@@ -70,12 +156,17 @@ func (mpm *MemoryPoolManager) ReleaseBuffer(buffer []byte) {
 		buffer[i] = 0
 	}
 
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+	mpm.inUse--
+
 	// Try to return to pool (non-blocking)
 	select {
 	case mpm.availableBuffers <- buffer:
 		// Successfully returned to pool
 	default:
 		// Pool is full, discard buffer
+		mpm.totalBuffers--
 	}
 }
 
@@ -90,3 +181,100 @@ func (mpm *MemoryPoolManager) GetTotalBuffers() int {
 	defer mpm.mutex.Unlock()
 	return mpm.totalBuffers
 }
+
+// SetMinBuffers sets the number of idle buffers Shrink keeps
+func (mpm *MemoryPoolManager) SetMinBuffers(min int) {
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+	if min > mpm.poolSize {
+		min = mpm.poolSize
+	}
+	mpm.minBuffers = min
+}
+
+// Shrink drops idle buffers down to the minimum and returns how many were
+// dropped. Buffers currently in use are unaffected.
+func (mpm *MemoryPoolManager) Shrink() int {
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+
+	dropped := 0
+	for len(mpm.availableBuffers) > mpm.minBuffers {
+		select {
+		case <-mpm.availableBuffers:
+			dropped++
+		default:
+		}
+	}
+	mpm.totalBuffers -= dropped
+	mpm.targetBuffers = mpm.minBuffers
+
+	if dropped > 0 {
+		logger.Info(fmt.Sprintf("MemoryPoolManager shrunk by %d buffers (%d idle)", dropped, len(mpm.availableBuffers)))
+	}
+	return dropped
+}
+
+// Grow pre-allocates up to n more idle buffers, bounded by the pool's
+// capacity, and returns how many were added
+func (mpm *MemoryPoolManager) Grow(n int) int {
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+
+	added := 0
+	for added < n {
+		select {
+		case mpm.availableBuffers <- make([]byte, mpm.bufferSize):
+			added++
+		default:
+			n = added // Pool is at capacity
+		}
+	}
+	mpm.totalBuffers += added
+	mpm.targetBuffers = min(mpm.targetBuffers+added, mpm.poolSize)
+
+	if added > 0 {
+		logger.Info(fmt.Sprintf("MemoryPoolManager grew by %d buffers (%d idle)", added, len(mpm.availableBuffers)))
+	}
+	return added
+}
+
+// StartIdleShrink checks the pool every interval and shrinks it when no
+// buffer was acquired since the previous check
+func (mpm *MemoryPoolManager) StartIdleShrink(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := mpm.acquires.Load()
+		for range ticker.C {
+			current := mpm.acquires.Load()
+			if current == last {
+				mpm.Shrink()
+			}
+			last = current
+		}
+	}()
+}
+
+// Stats returns the current pool sizes
+func (mpm *MemoryPoolManager) Stats() PoolStats {
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+	maxInUse := 0
+	if mpm.maxOverflow >= 0 {
+		maxInUse = mpm.poolSize + mpm.maxOverflow
+	}
+	return PoolStats{
+		BufferSize: mpm.bufferSize,
+		Available:  len(mpm.availableBuffers),
+		Total:      mpm.totalBuffers,
+		Target:     mpm.targetBuffers,
+		Capacity:   mpm.poolSize,
+		InUse:      mpm.inUse,
+		MaxInUse:   maxInUse,
+
+		OverflowAllocations: mpm.overflowAllocs.Load(),
+		AcquireTimeouts:     mpm.timeouts.Load(),
+	}
+}
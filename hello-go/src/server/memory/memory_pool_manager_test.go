@@ -0,0 +1,176 @@
+package memory
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checkPoolInvariants fails unless every allocated buffer is either idle or
+// in use and the pool stays within its bounds
+func checkPoolInvariants(t *testing.T, mpm *MemoryPoolManager, step string) {
+	t.Helper()
+	stats := mpm.Stats()
+	if stats.Total != stats.Available+stats.InUse {
+		t.Fatalf("%s: total %d != available %d + in use %d", step, stats.Total, stats.Available, stats.InUse)
+	}
+	if stats.Available > stats.Capacity {
+		t.Fatalf("%s: %d idle buffers exceed capacity %d", step, stats.Available, stats.Capacity)
+	}
+	if stats.Target < 0 || stats.Target > stats.Capacity {
+		t.Fatalf("%s: target %d outside [0, %d]", step, stats.Target, stats.Capacity)
+	}
+}
+
+func TestShrinkAndGrow(t *testing.T) {
+	mpm := NewMemoryPoolManager(1024, 10)
+	mpm.SetMinBuffers(2)
+	checkPoolInvariants(t, mpm, "new")
+
+	held := make([][]byte, 3)
+	for i := range held {
+		held[i], _ = mpm.AcquireBuffer()
+	}
+
+	if dropped := mpm.Shrink(); dropped != 5 {
+		t.Fatalf("Shrink dropped %d idle buffers, want 5 (7 idle, 2 kept)", dropped)
+	}
+	if stats := mpm.Stats(); stats.Available != 2 || stats.InUse != 3 || stats.Target != 2 {
+		t.Fatalf("after Shrink: %+v, want 2 idle, 3 in use, target 2", stats)
+	}
+	checkPoolInvariants(t, mpm, "shrink")
+	if dropped := mpm.Shrink(); dropped != 0 {
+		t.Fatalf("second Shrink dropped %d buffers", dropped)
+	}
+
+	// Buffers in use come back to the pool after a shrink
+	for _, buffer := range held {
+		mpm.ReleaseBuffer(buffer)
+	}
+	if stats := mpm.Stats(); stats.Available != 5 || stats.InUse != 0 {
+		t.Fatalf("after release: %+v, want 5 idle", stats)
+	}
+	checkPoolInvariants(t, mpm, "release")
+
+	if added := mpm.Grow(3); added != 3 {
+		t.Fatalf("Grow(3) added %d", added)
+	}
+	if added := mpm.Grow(100); added != 2 {
+		t.Fatalf("Grow(100) added %d, want the 2 left below capacity", added)
+	}
+	if stats := mpm.Stats(); stats.Available != 10 || stats.Total != 10 || stats.Target != 7 {
+		t.Fatalf("after Grow: %+v, want 10 idle of 10, target 7", stats)
+	}
+	checkPoolInvariants(t, mpm, "grow")
+}
+
+func TestSetMinBuffersIsBoundedByPoolSize(t *testing.T) {
+	mpm := NewMemoryPoolManager(1024, 4)
+	mpm.SetMinBuffers(100)
+	if dropped := mpm.Shrink(); dropped != 0 {
+		t.Fatalf("Shrink dropped %d buffers with the minimum above the pool size", dropped)
+	}
+	checkPoolInvariants(t, mpm, "shrink")
+}
+
+func TestPoolInvariantsUnderRandomOperations(t *testing.T) {
+	mpm := NewMemoryPoolManager(256, 8)
+	mpm.SetMinBuffers(1)
+	random := rand.New(rand.NewSource(1205))
+
+	var held [][]byte
+	for step := 0; step < 2000; step++ {
+		switch op := random.Intn(4); {
+		case op == 0 || (op == 1 && len(held) == 0):
+			buffer, err := mpm.AcquireBuffer()
+			if err != nil {
+				t.Fatalf("step %d: acquire: %v", step, err)
+			}
+			held = append(held, buffer)
+		case op == 1:
+			i := random.Intn(len(held))
+			mpm.ReleaseBuffer(held[i])
+			held = append(held[:i], held[i+1:]...)
+		case op == 2:
+			mpm.Shrink()
+		default:
+			mpm.Grow(random.Intn(5))
+		}
+		checkPoolInvariants(t, mpm, "random step")
+		if stats := mpm.Stats(); stats.InUse != len(held) {
+			t.Fatalf("step %d: %d in use, test holds %d", step, stats.InUse, len(held))
+		}
+	}
+}
+
+func TestPoolInvariantsUnderConcurrency(t *testing.T) {
+	mpm := NewMemoryPoolManager(256, 8)
+	mpm.SetMinBuffers(1)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				buffer, err := mpm.AcquireBuffer()
+				if err != nil {
+					t.Errorf("acquire: %v", err)
+					return
+				}
+				switch {
+				case g == 0 && i%10 == 0:
+					mpm.Shrink()
+				case g == 1 && i%10 == 0:
+					mpm.Grow(3)
+				}
+				mpm.ReleaseBuffer(buffer)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	checkPoolInvariants(t, mpm, "concurrent")
+	if stats := mpm.Stats(); stats.InUse != 0 {
+		t.Fatalf("%d buffers in use after every acquire was released", stats.InUse)
+	}
+}
+
+func TestAcquireTimesOutAtCap(t *testing.T) {
+	mpm := NewMemoryPoolManager(256, 2)
+	mpm.SetMaxOverflow(1, 50*time.Millisecond)
+
+	var held [][]byte
+	for i := 0; i < 3; i++ {
+		buffer, err := mpm.AcquireBuffer()
+		if err != nil {
+			t.Fatalf("acquire %d of 3 allowed: %v", i+1, err)
+		}
+		held = append(held, buffer)
+	}
+
+	start := time.Now()
+	_, err := mpm.AcquireBuffer()
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("acquire at the cap returned %v, want ErrPoolExhausted", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("acquire gave up after %v, want the 50ms timeout", elapsed)
+	}
+	stats := mpm.Stats()
+	if stats.AcquireTimeouts != 1 || stats.OverflowAllocations != 1 || stats.InUse != 3 || stats.MaxInUse != 3 {
+		t.Fatalf("stats %+v, want 1 timeout, 1 overflow allocation, 3 of 3 in use", stats)
+	}
+
+	// A release within the timeout satisfies a waiting acquire
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		mpm.ReleaseBuffer(held[0])
+	}()
+	if _, err := mpm.AcquireBuffer(); err != nil {
+		t.Fatalf("acquire with a release during the wait: %v", err)
+	}
+	checkPoolInvariants(t, mpm, "after waiting")
+}
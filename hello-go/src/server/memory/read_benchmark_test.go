@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// readBenchSize is the size of the finalized stream the read benchmarks use
+const readBenchSize = 64 << 20
+
+var (
+	readBenchChunkSizes  = []int{4096, 65536, 1 << 20}
+	readBenchConcurrency = []int{1, 4, 16}
+)
+
+// newReadBenchStream creates a finalized stream of size random bytes in a
+// temporary cache directory
+func newReadBenchStream(b *testing.B, size int) (*StreamManager, string) {
+	b.Helper()
+	sm := NewStreamManager(b.TempDir())
+	const streamID = "readbench"
+	if err := uploadStream(sm, streamID, streamPayload(1, size), 1<<20); err != nil {
+		b.Fatalf("create benchmark stream: %v", err)
+	}
+	return sm, streamID
+}
+
+// benchmarkReads runs b.N chunk-sized reads spread over a stream of size
+// bytes by conc goroutines; ns/op is wall time per read across all readers
+func benchmarkReads(b *testing.B, read func(offset int64, length int) []byte, size int, chunk int, conc int) {
+	b.ReportAllocs()
+	b.SetBytes(int64(chunk))
+	chunksInStream := int64(size / chunk)
+
+	var next atomic.Int64
+	var short atomic.Bool
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for g := 0; g < conc; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := next.Add(1) - 1
+				if i >= int64(b.N) {
+					return
+				}
+				if len(read((i%chunksInStream)*int64(chunk), chunk)) != chunk {
+					short.Store(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if short.Load() {
+		b.Fatalf("a read of %d bytes returned short data", chunk)
+	}
+}
+
+// BenchmarkReadChunk measures the server's ReadAt-based read path per chunk
+// size and number of concurrent readers
+func BenchmarkReadChunk(b *testing.B) {
+	sm, streamID := newReadBenchStream(b, readBenchSize)
+	read := func(offset int64, length int) []byte {
+		return sm.ReadChunk(streamID, offset, length)
+	}
+	for _, chunk := range readBenchChunkSizes {
+		for _, conc := range readBenchConcurrency {
+			b.Run(fmt.Sprintf("chunk=%d/conc=%d", chunk, conc), func(b *testing.B) {
+				benchmarkReads(b, read, readBenchSize, chunk, conc)
+			})
+		}
+	}
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package memory
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// BenchmarkReadChunkMmap reads the same finalized stream through a
+// read-only mapping, to compare with BenchmarkReadChunk
+func BenchmarkReadChunkMmap(b *testing.B) {
+	sm, streamID := newReadBenchStream(b, readBenchSize)
+	file, err := os.Open(sm.LocalCache().Path(streamID))
+	if err != nil {
+		b.Fatal(err)
+	}
+	mapping, err := syscall.Mmap(int(file.Fd()), 0, readBenchSize, syscall.PROT_READ, syscall.MAP_SHARED)
+	file.Close()
+	if err != nil {
+		b.Fatalf("mmap: %v", err)
+	}
+	defer syscall.Munmap(mapping)
+
+	read := func(offset int64, length int) []byte {
+		data := make([]byte, length)
+		copy(data, mapping[offset:])
+		return data
+	}
+	for _, chunk := range readBenchChunkSizes {
+		for _, conc := range readBenchConcurrency {
+			b.Run(fmt.Sprintf("chunk=%d/conc=%d", chunk, conc), func(b *testing.B) {
+				benchmarkReads(b, read, readBenchSize, chunk, conc)
+			})
+		}
+	}
+}
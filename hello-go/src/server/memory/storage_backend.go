@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StorageBackend holds finalized streams. Streams are always uploaded into the
+// local cache; after finalize StreamManager puts the cache file into the
+// backend and, for a remote backend, serves GETs from it as byte ranges.
+type StorageBackend interface {
+	// Put stores the finalized cache file at path as the object for streamID
+	Put(streamID string, path string) error
+	// Get reads up to length bytes of the object starting at offset;
+	// fewer bytes are returned at the end of the object
+	Get(streamID string, offset int64, length int) ([]byte, error)
+	// Delete removes the object; deleting a missing object is not an error
+	Delete(streamID string) error
+	// Stat returns the object's size in bytes
+	Stat(streamID string) (int64, error)
+}
+
+// ErrStorageNotImplemented is returned by backends that are only stubbed out
+var ErrStorageNotImplemented = errors.New("storage backend not implemented")
+
+// NewStorageBackend parses a --storage value: "local" keeps finalized streams
+// in the cache directories, "s3://bucket/prefix" offloads them to S3
+func NewStorageBackend(spec string, local *LocalStorage) (StorageBackend, error) {
+	switch {
+	case spec == "" || spec == "local":
+		return local, nil
+	case strings.HasPrefix(spec, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(spec, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid storage %q: missing bucket", spec)
+		}
+		return NewS3Storage(bucket, prefix), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q (expected local or s3://bucket/prefix)", spec)
+	}
+}
+
+// LocalStorage keeps streams as files striped across cache directories by
+// stream ID hash. It is the default backend and also the upload cache.
+type LocalStorage struct {
+	directories []string
+}
+
+// NewLocalStorage creates a local backend over the given directories
+func NewLocalStorage(directories []string) *LocalStorage {
+	return &LocalStorage{directories: directories}
+}
+
+// Path returns the cache file path for a stream
+func (s *LocalStorage) Path(streamID string) string {
+	return filepath.Join(s.dirFor(streamID), streamID+".cache")
+}
+
+// dirFor picks the directory for a stream by hashing its ID
+func (s *LocalStorage) dirFor(streamID string) string {
+	if len(s.directories) == 1 {
+		return s.directories[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(streamID))
+	return s.directories[h.Sum32()%uint32(len(s.directories))]
+}
+
+// Put moves the file into place; a cache file already at its path is kept as is
+func (s *LocalStorage) Put(streamID string, path string) error {
+	target := s.Path(streamID)
+	if path == target {
+		return nil
+	}
+	return os.Rename(path, target)
+}
+
+// Get reads a byte range of the stream's file
+func (s *LocalStorage) Get(streamID string, offset int64, length int) ([]byte, error) {
+	file, err := os.Open(s.Path(streamID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, length)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// Delete removes the stream's file
+func (s *LocalStorage) Delete(streamID string) error {
+	if err := os.Remove(s.Path(streamID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Stat returns the size of the stream's file
+func (s *LocalStorage) Stat(streamID string) (int64, error) {
+	info, err := os.Stat(s.Path(streamID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// S3Storage offloads finalized streams to an S3 bucket as objects named
+// prefix/streamID. It is a stub: every operation fails, so StreamManager keeps
+// serving streams from the local cache.
+type S3Storage struct {
+	bucket string
+	prefix string
+}
+
+// NewS3Storage creates an S3 backend for bucket, naming objects under prefix
+func NewS3Storage(bucket string, prefix string) *S3Storage {
+	return &S3Storage{bucket: bucket, prefix: strings.Trim(prefix, "/")}
+}
+
+// key returns the object key for a stream
+func (s *S3Storage) key(streamID string) string {
+	if s.prefix == "" {
+		return streamID
+	}
+	return s.prefix + "/" + streamID
+}
+
+// Put would upload the cache file with a multipart PutObject
+func (s *S3Storage) Put(streamID string, path string) error {
+	return fmt.Errorf("put s3://%s/%s: %w", s.bucket, s.key(streamID), ErrStorageNotImplemented)
+}
+
+// Get would issue a ranged GetObject (Range: bytes=offset-offset+length-1)
+func (s *S3Storage) Get(streamID string, offset int64, length int) ([]byte, error) {
+	return nil, fmt.Errorf("get s3://%s/%s: %w", s.bucket, s.key(streamID), ErrStorageNotImplemented)
+}
+
+// Delete would issue DeleteObject
+func (s *S3Storage) Delete(streamID string) error {
+	return fmt.Errorf("delete s3://%s/%s: %w", s.bucket, s.key(streamID), ErrStorageNotImplemented)
+}
+
+// Stat would issue HeadObject and return its Content-Length
+func (s *S3Storage) Stat(streamID string) (int64, error) {
+	return 0, fmt.Errorf("stat s3://%s/%s: %w", s.bucket, s.key(streamID), ErrStorageNotImplemented)
+}
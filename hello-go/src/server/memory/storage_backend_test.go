@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorageSpreadsStreamsEvenly(t *testing.T) {
+	storage := NewLocalStorage([]string{"/cache/a", "/cache/b"})
+	const streams = 10000
+
+	for _, format := range []string{"stream-%d", "stream-20261016-120000-%08x"} {
+		counts := map[string]int{}
+		for i := 0; i < streams; i++ {
+			streamID := fmt.Sprintf(format, i)
+			dir := storage.dirFor(streamID)
+			if again := storage.dirFor(streamID); again != dir {
+				t.Fatalf("%s maps to %s and then %s", streamID, dir, again)
+			}
+			counts[dir]++
+		}
+		for _, dir := range storage.directories {
+			if share := float64(counts[dir]) / streams; share < 0.45 || share > 0.55 {
+				t.Errorf("IDs like %q: %s holds %.1f%% of streams, want about half", format, dir, share*100)
+			}
+		}
+	}
+}
+
+func TestLocalStorageSingleDirectory(t *testing.T) {
+	storage := NewLocalStorage([]string{"/cache"})
+	if path := storage.Path("only"); path != filepath.Join("/cache", "only.cache") {
+		t.Fatalf("Path = %s", path)
+	}
+}
+
+func TestStreamManagerUsesEveryCacheDirectory(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	sm := NewStreamManager(dirs...)
+	for i := 0; i < 40; i++ {
+		if err := uploadStream(sm, fmt.Sprintf("spread-%d", i), []byte("data"), 4); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) < 10 {
+			t.Errorf("%s holds %d cache files (%v), want a share of the 40 streams", dir, len(entries), err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// ArchiveManifestName is the first entry of an export archive
+const ArchiveManifestName = "manifest.json"
+
+// archiveEntrySuffix names each stream's entry: <streamID>.cache
+const archiveEntrySuffix = ".cache"
+
+// archiveBlockSize is how much is read or written per call while archiving
+const archiveBlockSize = 1024 * 1024
+
+// ArchiveEntry describes one stream in an export archive's manifest
+type ArchiveEntry struct {
+	StreamID        string `json:"streamId"`
+	Size            int64  `json:"size"`
+	Digest          string `json:"digest"`
+	DigestAlgorithm string `json:"digestAlgorithm"`
+}
+
+// ArchiveManifest lists the streams of an export archive
+type ArchiveManifest struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	Streams   []ArchiveEntry `json:"streams"`
+}
+
+// ImportResult reports what ImportArchive did with each stream
+type ImportResult struct {
+	Imported []string          `json:"imported"`
+	Skipped  []string          `json:"skipped"` // Already registered
+	Failed   map[string]string `json:"failed"`  // Stream ID to reason
+}
+
+// ExportArchive writes every READY stream to w as a tar archive: a manifest
+// of IDs, sizes and digests followed by one <streamID>.cache entry per
+// stream. Streams still uploading or failed are skipped.
+func (sm *StreamManager) ExportArchive(w io.Writer) (int, error) {
+	manifest := ArchiveManifest{CreatedAt: time.Now().UTC(), Streams: []ArchiveEntry{}}
+	for _, info := range sm.SnapshotStreams() {
+		if info.Status != StatusReady {
+			continue
+		}
+		manifest.Streams = append(manifest.Streams, ArchiveEntry{
+			StreamID:        info.StreamID,
+			Size:            info.Size,
+			Digest:          info.Digest,
+			DigestAlgorithm: info.DigestAlgorithm,
+		})
+	}
+
+	tw := tar.NewWriter(w)
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := writeTarEntry(tw, ArchiveManifestName, body); err != nil {
+		return 0, err
+	}
+
+	for _, entry := range manifest.Streams {
+		header := &tar.Header{
+			Name:    entry.StreamID + archiveEntrySuffix,
+			Mode:    0644,
+			Size:    entry.Size,
+			ModTime: manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return 0, err
+		}
+		for offset := int64(0); offset < entry.Size; {
+			data := sm.ReadChunk(entry.StreamID, offset, int(min(archiveBlockSize, entry.Size-offset)))
+			if len(data) == 0 {
+				return 0, fmt.Errorf("failed to read stream %s at offset %d", entry.StreamID, offset)
+			}
+			if _, err := tw.Write(data); err != nil {
+				return 0, err
+			}
+			offset += int64(len(data))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	logger.Info(fmt.Sprintf("Exported %d streams", len(manifest.Streams)))
+	return len(manifest.Streams), nil
+}
+
+// ImportArchive registers the streams of an archive written by ExportArchive.
+// Each stream goes through the upload path (create, write, finalize), so it is
+// stored and digested like any other; a stream whose digest differs from the
+// manifest is deleted again. Streams already registered are left untouched.
+func (sm *StreamManager) ImportArchive(r io.Reader) (*ImportResult, error) {
+	result := &ImportResult{Imported: []string{}, Skipped: []string{}, Failed: map[string]string{}}
+	expected := map[string]ArchiveEntry{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("invalid archive: %w", err)
+		}
+
+		if header.Name == ArchiveManifestName {
+			var manifest ArchiveManifest
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return result, fmt.Errorf("invalid %s: %w", ArchiveManifestName, err)
+			}
+			for _, entry := range manifest.Streams {
+				expected[entry.StreamID] = entry
+			}
+			continue
+		}
+
+		streamID, ok := strings.CutSuffix(header.Name, archiveEntrySuffix)
+		if !ok || header.Typeflag != tar.TypeReg || streamID == "" || strings.ContainsAny(streamID, `/\`) || strings.Contains(streamID, "..") {
+			logger.Warn(fmt.Sprintf("Ignoring archive entry %q", header.Name))
+			continue
+		}
+		if sm.GetStream(streamID) != nil {
+			result.Skipped = append(result.Skipped, streamID)
+			continue
+		}
+
+		entry, listed := expected[streamID]
+		if err := sm.importStream(streamID, tr, entry, listed); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to import stream %s: %v", streamID, err))
+			result.Failed[streamID] = err.Error()
+			continue
+		}
+		result.Imported = append(result.Imported, streamID)
+	}
+
+	logger.Info(fmt.Sprintf("Imported %d streams (%d skipped, %d failed)",
+		len(result.Imported), len(result.Skipped), len(result.Failed)))
+	return result, nil
+}
+
+// importStream uploads one archive entry as a new stream and checks it
+// against its manifest entry, when listed
+func (sm *StreamManager) importStream(streamID string, data io.Reader, entry ArchiveEntry, listed bool) error {
+	if !sm.CreateStream(streamID) {
+		return fmt.Errorf("failed to create stream")
+	}
+
+	err := func() error {
+		block := make([]byte, archiveBlockSize)
+		for {
+			n, err := io.ReadFull(data, block)
+			if n > 0 {
+				if err := sm.WriteChunk(streamID, block[:n]); err != nil {
+					return err
+				}
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read archive entry: %w", err)
+			}
+		}
+		if err := sm.FinalizeStream(streamID); err != nil {
+			return err
+		}
+
+		info, _ := sm.GetStreamInfo(streamID)
+		if listed && info.Size != entry.Size {
+			return fmt.Errorf("size %d does not match manifest %d", info.Size, entry.Size)
+		}
+		if listed && info.DigestAlgorithm == entry.DigestAlgorithm && info.Digest != entry.Digest {
+			return fmt.Errorf("%s digest does not match manifest", entry.DigestAlgorithm)
+		}
+		return nil
+	}()
+	if err != nil {
+		sm.DeleteStream(streamID)
+	}
+	return err
+}
+
+// writeTarEntry writes a small in-memory file to an archive
+func writeTarEntry(tw *tar.Writer, name string, body []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: time.Now().UTC()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(body)
+	return err
+}
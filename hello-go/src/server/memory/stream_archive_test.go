@@ -0,0 +1,178 @@
+package memory
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// archiveFile is one entry of a hand-built archive
+type archiveFile struct {
+	name string
+	body []byte
+}
+
+// buildArchive writes files, in order, as a tar archive
+func buildArchive(t *testing.T, files ...archiveFile) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := writeTarEntry(tw, f.name, f.body); err != nil {
+			t.Fatalf("write %s: %v", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// manifestFile encodes a manifest listing entries
+func manifestFile(t *testing.T, entries ...ArchiveEntry) archiveFile {
+	t.Helper()
+	body, err := json.Marshal(ArchiveManifest{Streams: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archiveFile{ArchiveManifestName, body}
+}
+
+// readManifest returns the manifest at the start of an exported archive
+func readManifest(t *testing.T, archive []byte) ArchiveManifest {
+	t.Helper()
+	tr := tar.NewReader(bytes.NewReader(archive))
+	header, err := tr.Next()
+	if err != nil || header.Name != ArchiveManifestName {
+		t.Fatalf("first entry = %v, %v; want %s", header, err, ArchiveManifestName)
+	}
+	var manifest ArchiveManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	return manifest
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	source := NewStreamManager(t.TempDir())
+	payloads := map[string][]byte{
+		"archive-a": streamPayload(1, 3*archiveBlockSize/2), // Spans archive blocks
+		"archive-b": streamPayload(2, 100),
+	}
+	for streamID, data := range payloads {
+		if err := uploadStream(source, streamID, data, 64*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source.CreateStream("archive-uploading") // Not READY, so not exported
+
+	var archive bytes.Buffer
+	exported, err := source.ExportArchive(&archive)
+	if err != nil || exported != len(payloads) {
+		t.Fatalf("export = %d, %v; want %d streams", exported, err, len(payloads))
+	}
+	manifest := readManifest(t, archive.Bytes())
+	if len(manifest.Streams) != len(payloads) {
+		t.Fatalf("manifest lists %d streams, want %d", len(manifest.Streams), len(payloads))
+	}
+	for _, entry := range manifest.Streams {
+		info, _ := source.GetStreamInfo(entry.StreamID)
+		if entry.Size != info.Size || entry.Digest != info.Digest || entry.DigestAlgorithm != info.DigestAlgorithm {
+			t.Errorf("manifest entry %+v does not match stream %+v", entry, info)
+		}
+	}
+
+	target := NewStreamManager(t.TempDir())
+	result, err := target.ImportArchive(bytes.NewReader(archive.Bytes()))
+	if err != nil || len(result.Imported) != len(payloads) || len(result.Failed) != 0 {
+		t.Fatalf("import = %+v, %v", result, err)
+	}
+	for streamID, data := range payloads {
+		info, _ := target.GetStreamInfo(streamID)
+		want, _ := source.GetStreamInfo(streamID)
+		if info.Status != StatusReady || info.Digest != want.Digest {
+			t.Errorf("imported %s is %s with digest %q, want READY with %q", streamID, info.Status, info.Digest, want.Digest)
+		}
+		if got := target.ReadChunk(streamID, 0, len(data)); !bytes.Equal(got, data) {
+			t.Errorf("imported %s holds different bytes", streamID)
+		}
+	}
+
+	// Importing again leaves the registered streams alone
+	result, err = target.ImportArchive(bytes.NewReader(archive.Bytes()))
+	if err != nil || len(result.Skipped) != len(payloads) || len(result.Imported) != 0 {
+		t.Fatalf("second import = %+v, %v; want every stream skipped", result, err)
+	}
+}
+
+func TestImportRejectsManifestMismatch(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	good, bad := []byte("good content"), []byte("tampered content")
+	reference := NewStreamManager(t.TempDir())
+	if err := uploadStream(reference, "reference", good, len(good)); err != nil {
+		t.Fatal(err)
+	}
+	goodInfo, _ := reference.GetStreamInfo("reference")
+
+	archive := buildArchive(t,
+		manifestFile(t,
+			ArchiveEntry{StreamID: "import-good", Size: goodInfo.Size, Digest: goodInfo.Digest, DigestAlgorithm: goodInfo.DigestAlgorithm},
+			ArchiveEntry{StreamID: "import-digest", Size: int64(len(bad)), Digest: goodInfo.Digest, DigestAlgorithm: goodInfo.DigestAlgorithm},
+			ArchiveEntry{StreamID: "import-size", Size: goodInfo.Size + 1, Digest: goodInfo.Digest, DigestAlgorithm: goodInfo.DigestAlgorithm},
+		),
+		archiveFile{"import-good.cache", good},
+		archiveFile{"import-digest.cache", bad},
+		archiveFile{"import-size.cache", good},
+		archiveFile{"import-unlisted.cache", bad}, // Not in the manifest, so nothing to check
+	)
+
+	result, err := sm.ImportArchive(archive)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(result.Imported) != 2 {
+		t.Errorf("imported %v, want import-good and import-unlisted", result.Imported)
+	}
+	for _, streamID := range []string{"import-digest", "import-size"} {
+		if _, failed := result.Failed[streamID]; !failed {
+			t.Errorf("%s not reported failed: %+v", streamID, result)
+		}
+		if sm.GetStream(streamID) != nil {
+			t.Errorf("mismatching stream %s was kept", streamID)
+		}
+	}
+	if info, _ := sm.GetStreamInfo("import-good"); info.Status != StatusReady {
+		t.Errorf("matching stream is %s, want READY", info.Status)
+	}
+}
+
+func TestImportIgnoresUnsafeEntryNames(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	names := []string{"../escape.cache", "/absolute.cache", "nested/stream.cache", `back\slash.cache`, ".cache", "not-a-stream.txt"}
+	var files []archiveFile
+	for _, name := range names {
+		files = append(files, archiveFile{name, []byte("payload")})
+	}
+
+	result, err := sm.ImportArchive(buildArchive(t, files...))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(result.Imported)+len(result.Skipped)+len(result.Failed) != 0 {
+		t.Fatalf("unsafe entries were processed: %+v", result)
+	}
+	if streams := sm.SnapshotStreams(); len(streams) != 0 {
+		t.Fatalf("%d streams registered from unsafe entries", len(streams))
+	}
+}
+
+func TestImportRejectsCorruptArchive(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	if _, err := sm.ImportArchive(bytes.NewReader(bytes.Repeat([]byte{0xff}, 1024))); err == nil {
+		t.Fatal("import of a non-tar body succeeded")
+	}
+	if _, err := sm.ImportArchive(buildArchive(t, archiveFile{ArchiveManifestName, []byte("{not json")})); err == nil {
+		t.Fatal("import with an invalid manifest succeeded")
+	}
+}
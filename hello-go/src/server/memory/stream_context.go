@@ -1,7 +1,9 @@
 package memory
 
 import (
+	"hash"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,41 +11,64 @@ import (
 type StreamStatus string
 
 const (
-	StatusUploading StreamStatus = "UPLOADING"
-	StatusReady     StreamStatus = "READY"
-	StatusError     StreamStatus = "ERROR"
+	StatusUploading  StreamStatus = "UPLOADING"
+	StatusReady      StreamStatus = "READY"
+	StatusError      StreamStatus = "ERROR"
+	StatusIncomplete StreamStatus = "INCOMPLETE" // Uploader disconnected before STOP
 )
 
 // StreamContext contains metadata and state for a single stream
-// Thread-safe with Mutex for concurrent access
+// Thread-safe with Mutex for concurrent access; the access time and
+// counters are atomic so they can be touched without holding Mu
 type StreamContext struct {
-	StreamID       string
-	CachePath      string
-	MmapFile       *MemoryMappedCache
-	CurrentOffset  int64
-	TotalSize      int64
-	CreatedAt      time.Time
-	LastAccessedAt time.Time
-	Status         StreamStatus
-	Mu             sync.Mutex // Protects mutable fields
+	StreamID         string
+	CachePath        string
+	MmapFile         *MemoryMappedCache // nil once the stream is offloaded
+	Offloaded        bool               // Served from the storage backend, not the cache
+	Compressed       *CompressedCache   // Set once the finalized cache is stored gzip-compressed; MmapFile is then nil
+	CurrentOffset    int64
+	TotalSize        int64
+	DeclaredSize     int64  // Expected size from START; 0 when not declared
+	NextFrame        uint32 // Sequenced uploads: sequence number of the next frame to apply; lower ones were applied
+	CompressionLevel int    // Deflate level for GET responses from START; 0 uses the default
+	CreatedAt        time.Time
+	FinalizedAt      time.Time // When the stream became READY; zero before
+	Status           StreamStatus
+	Digest           string                   // Hex digest of the finalized stream
+	DigestAlgorithm  string                   // Algorithm of Digest, e.g. DigestSHA256
+	TranscodeTarget  string                   // Format to transcode to on finalize; empty for none
+	Variants         map[string]StreamVariant // Transcoded copies by format, made on finalize
+	Mu               sync.Mutex               // Protects mutable fields
+
+	writeDigest hash.Hash // SHA-256 of the bytes written, for read-back verification; nil when off
+
+	ReadCount    atomic.Int64 // Successful chunk reads
+	WriteCount   atomic.Int64 // Successful chunk writes
+	lastAccessed atomic.Int64 // Unix nanoseconds
 }
 
 // NewStreamContext creates a new stream context
 func NewStreamContext(streamID string) *StreamContext {
 	now := time.Now()
-	return &StreamContext{
-		StreamID:       streamID,
-		CachePath:      "",
-		MmapFile:       nil,
-		CurrentOffset:  0,
-		TotalSize:      0,
-		CreatedAt:      now,
-		LastAccessedAt: now,
-		Status:         StatusUploading,
+	context := &StreamContext{
+		StreamID:      streamID,
+		CachePath:     "",
+		MmapFile:      nil,
+		CurrentOffset: 0,
+		TotalSize:     0,
+		CreatedAt:     now,
+		Status:        StatusUploading,
 	}
+	context.lastAccessed.Store(now.UnixNano())
+	return context
 }
 
 // UpdateAccessTime updates the last accessed timestamp
 func (sc *StreamContext) UpdateAccessTime() {
-	sc.LastAccessedAt = time.Now()
+	sc.lastAccessed.Store(time.Now().UnixNano())
+}
+
+// LastAccessedAt returns the last accessed timestamp
+func (sc *StreamContext) LastAccessedAt() time.Time {
+	return time.Unix(0, sc.lastAccessed.Load())
 }
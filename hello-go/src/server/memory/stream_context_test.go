@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race: the access time and counters are updated from reads,
+// writes and lookups on several goroutines while others read them
+func TestStreamCountersUnderConcurrentAccess(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	const (
+		writes  = 200
+		readers = 4
+		reads   = 200
+	)
+	if !sm.CreateStream("counters") {
+		t.Fatal("create failed")
+	}
+	if err := sm.WriteChunk("counters", []byte("first chunk")); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < writes; i++ {
+			if err := sm.WriteChunk("counters", []byte("next chunk")); err != nil {
+				t.Errorf("write %d: %v", i, err)
+				return
+			}
+		}
+	}()
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < reads; i++ {
+				if len(sm.ReadChunk("counters", 0, 5)) != 5 {
+					t.Error("short read")
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < reads; i++ {
+			if stream := sm.GetStream("counters"); stream != nil {
+				stream.UpdateAccessTime()
+				_ = stream.LastAccessedAt()
+			}
+			sm.GetStreamInfo("counters")
+			sm.ListActiveStreams()
+		}
+	}()
+	wg.Wait()
+
+	info, _ := sm.GetStreamInfo("counters")
+	if info.WriteCount != writes {
+		t.Errorf("WriteCount = %d, want %d", info.WriteCount, writes)
+	}
+	if info.ReadCount != readers*reads {
+		t.Errorf("ReadCount = %d, want %d", info.ReadCount, readers*reads)
+	}
+	if info.LastAccessedAt.Before(before) {
+		t.Errorf("LastAccessedAt %v is older than the accesses that started at %v", info.LastAccessedAt, before)
+	}
+}
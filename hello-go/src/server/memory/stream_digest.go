@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+// Digest algorithms computed over a stream when it is finalized
+const (
+	// DigestSHA256 is plain SHA-256 over the whole stream (default, interoperable)
+	DigestSHA256 = "sha256"
+
+	// DigestTreeSHA256 splits the stream into TreeHashSegmentSize segments
+	// (the last may be shorter), hashes each with SHA-256, and takes the
+	// SHA-256 of the concatenated 32-byte segment digests in order. An empty
+	// stream has no segments, so its digest is SHA-256 of no input.
+	// Segments are independent, so they are hashed concurrently.
+	DigestTreeSHA256 = "tree-sha256"
+)
+
+// TreeHashSegmentSize is the segment length of DigestTreeSHA256
+const TreeHashSegmentSize = 8 * 1024 * 1024
+
+// digestReadSize is how much is read from the cache per call while hashing
+const digestReadSize = 1024 * 1024
+
+// readRange reads up to length bytes at offset, e.g. MemoryMappedCache.Read
+type readRange func(offset int64, length int) ([]byte, error)
+
+// computeDigest hashes size bytes read through read with the given algorithm
+func computeDigest(read readRange, size int64, algorithm string) (string, error) {
+	switch algorithm {
+	case DigestSHA256:
+		hasher := sha256.New()
+		if err := hashRange(hasher, read, 0, size); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	case DigestTreeSHA256:
+		return treeDigest(read, size)
+	default:
+		return "", fmt.Errorf("unknown digest algorithm %q", algorithm)
+	}
+}
+
+// treeDigest computes DigestTreeSHA256 with one worker per CPU
+func treeDigest(read readRange, size int64) (string, error) {
+	segments := int((size + TreeHashSegmentSize - 1) / TreeHashSegmentSize)
+	sums := make([][]byte, segments)
+	errs := make([]error, segments)
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > segments {
+		workers = segments
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := sha256.New()
+			for i := range next {
+				start := int64(i) * TreeHashSegmentSize
+				end := min(start+TreeHashSegmentSize, size)
+				hasher.Reset()
+				if errs[i] = hashRange(hasher, read, start, end); errs[i] == nil {
+					sums[i] = hasher.Sum(nil)
+				}
+			}
+		}()
+	}
+	for i := 0; i < segments; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	root := sha256.New()
+	for i, sum := range sums {
+		if errs[i] != nil {
+			return "", errs[i]
+		}
+		root.Write(sum)
+	}
+	return hex.EncodeToString(root.Sum(nil)), nil
+}
+
+// hashRange feeds bytes [start, end) into hasher
+func hashRange(hasher hash.Hash, read readRange, start, end int64) error {
+	for offset := start; offset < end; {
+		data, err := read(offset, int(min(digestReadSize, end-offset)))
+		if err != nil {
+			return fmt.Errorf("failed to read at offset %d: %w", offset, err)
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("unexpected end of stream at offset %d", offset)
+		}
+		hasher.Write(data)
+		offset += int64(len(data))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// expectedTreeDigest computes DigestTreeSHA256 of data serially
+func expectedTreeDigest(data []byte) string {
+	root := sha256.New()
+	for start := 0; start < len(data); start += TreeHashSegmentSize {
+		sum := sha256.Sum256(data[start:min(start+TreeHashSegmentSize, len(data))])
+		root.Write(sum[:])
+	}
+	return hex.EncodeToString(root.Sum(nil))
+}
+
+func TestComputeDigest(t *testing.T) {
+	data := streamPayload(1219, 3*TreeHashSegmentSize+TreeHashSegmentSize/2)
+	for _, size := range []int{0, 1, TreeHashSegmentSize - 1, TreeHashSegmentSize, TreeHashSegmentSize + 1, len(data)} {
+		prefix := data[:size]
+		read := readFrom(prefix)
+
+		plain, err := computeDigest(read, int64(size), DigestSHA256)
+		if sum := sha256.Sum256(prefix); err != nil || plain != hex.EncodeToString(sum[:]) {
+			t.Errorf("size %d: sha256 = %s, %v; want %x", size, plain, err, sum)
+		}
+		tree, err := computeDigest(read, int64(size), DigestTreeSHA256)
+		if want := expectedTreeDigest(prefix); err != nil || tree != want {
+			t.Errorf("size %d: tree-sha256 = %s, %v; want %s", size, tree, err, want)
+		}
+	}
+
+	if _, err := computeDigest(readFrom(data), 10, "md5"); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+// BenchmarkFinalizeDigest hashes a large finalized stream from its cache file
+// with each algorithm; tree-sha256 hashes segments on every CPU
+func BenchmarkFinalizeDigest(b *testing.B) {
+	const size = 256 << 20
+	sm, streamID := newReadBenchStream(b, size)
+	read := sm.GetStream(streamID).MmapFile.Read
+
+	for _, algorithm := range []string{DigestSHA256, DigestTreeSHA256} {
+		b.Run(algorithm, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := computeDigest(read, size, algorithm); err != nil {
+					b.Fatalf("digest: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -1,20 +1,59 @@
 package memory
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
 )
 
-// StreamManager manages active audio streams (singleton)
+// ErrFinalizeFailed reports that a stream's data could not be made durable
+// on finalize, e.g. because the disk is full
+var ErrFinalizeFailed = errors.New("finalize failed")
+
+// ErrWriteTimeout reports a cache write that did not complete within the
+// configured deadline, e.g. because the disk or network mount stalled
+var ErrWriteTimeout = errors.New("write timed out")
+
+// LimitError reports an operation refused by a configured limit; Limit is
+// the threshold in the unit named by Name
+type LimitError struct {
+	Name    string // e.g. "declaredSize" (bytes) or "maxUploadDurationMs"
+	Limit   int64
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// StreamManager manages active audio streams (singleton).
+// The registry is guarded by mutex and each stream's data by its own Mu, so
+// uploads to distinct streams proceed concurrently without interfering, while
+// writes to one stream are applied in the order they are received.
 type StreamManager struct {
-	cacheDirectory string
-	streams        map[string]*StreamContext
-	mutex          sync.RWMutex
+	cache             *LocalStorage  // Upload cache, striped across directories by stream ID hash
+	storage           StorageBackend // Where finalized streams live; the cache by default
+	maxUploadDuration time.Duration  // Zero means uploads may stay open indefinitely
+	repairWav         bool           // Rewrite WAV header sizes on finalize
+	digestAlgorithm   string         // Digest computed on finalize, DigestSHA256 by default
+	keepFailedCache   bool           // Keep the cache file of a stream that failed to finalize
+	selfVerify        bool           // Read every write back and compare it
+	compressAtRest    bool           // Store finalized cache files gzip-compressed
+	writeTimeout      time.Duration  // Zero lets a cache write block indefinitely
+	verifyOnFinalize  bool           // Hash writes and compare with a read-back on finalize
+	transcoder        Transcoder     // Makes the variants streams ask for on finalize
+	deleted           atomic.Int64   // Streams removed by DeleteStream
+	dedup             *dedupIndex    // SHA-256 of finalized streams, for START dedup
+	streams           map[string]*StreamContext
+	mutex             sync.RWMutex
 }
 
 var (
@@ -22,22 +61,100 @@ var (
 	streamOnce     sync.Once
 )
 
-// GetStreamManager returns singleton instance
-func GetStreamManager(cacheDir string) *StreamManager {
+// GetStreamManager returns singleton instance.
+// With several cache directories, streams are distributed across them.
+func GetStreamManager(cacheDirs ...string) *StreamManager {
 	streamOnce.Do(func() {
-		streamInstance = &StreamManager{
-			cacheDirectory: cacheDir,
-			streams:        make(map[string]*StreamContext),
-		}
+		streamInstance = NewStreamManager(cacheDirs...)
+	})
+	return streamInstance
+}
+
+// NewStreamManager creates a stream manager independent of the singleton,
+// e.g. for tests that need their own cache directories
+func NewStreamManager(cacheDirs ...string) *StreamManager {
+	if len(cacheDirs) == 0 {
+		cacheDirs = []string{"cache"}
+	}
+	cache := NewLocalStorage(cacheDirs)
+	sm := &StreamManager{
+		cache:           cache,
+		storage:         cache,
+		digestAlgorithm: DigestSHA256,
+		dedup:           newDedupIndex(),
+		transcoder:      PassthroughTranscoder{},
+		streams:         make(map[string]*StreamContext),
+	}
 
-		// Create cache directory
+	// Create cache directories
+	for _, cacheDir := range cacheDirs {
 		if err := os.MkdirAll(cacheDir, 0755); err != nil {
-			logger.Error(fmt.Sprintf("Failed to create cache directory: %v", err))
+			logger.Error(fmt.Sprintf("Failed to create cache directory %s: %v", cacheDir, err))
 		}
+	}
 
-		logger.Info(fmt.Sprintf("StreamManager initialized with cache directory: %s", cacheDir))
-	})
-	return streamInstance
+	logger.Info(fmt.Sprintf("StreamManager initialized with cache directories: %v", cacheDirs))
+	return sm
+}
+
+// SetMaxUploadDuration limits how long a stream may stay UPLOADING after creation
+func (sm *StreamManager) SetMaxUploadDuration(d time.Duration) {
+	sm.maxUploadDuration = d
+}
+
+// SetRepairWavHeaders makes FinalizeStream rewrite the RIFF and data chunk
+// sizes of WAV streams to match the bytes actually received
+func (sm *StreamManager) SetRepairWavHeaders(repair bool) {
+	sm.repairWav = repair
+}
+
+// SetKeepFailedCache keeps the partial cache file of a stream whose finalize
+// failed, for inspection; by default it is removed to free the disk
+func (sm *StreamManager) SetKeepFailedCache(keep bool) {
+	sm.keepFailedCache = keep
+}
+
+// SetSelfVerify reads every chunk back right after writing it and fails the
+// stream on a mismatch. It doubles cache I/O and is meant for development.
+func (sm *StreamManager) SetSelfVerify(verify bool) {
+	sm.selfVerify = verify
+}
+
+// SetWriteTimeout bounds each cache write of WriteChunk; a write that takes
+// longer moves the stream to ERROR. Zero disables the deadline.
+func (sm *StreamManager) SetWriteTimeout(timeout time.Duration) {
+	sm.writeTimeout = timeout
+}
+
+// SetCompressAtRest stores each finalized stream's cache file gzip-compressed
+// in blocks, trading CPU on every read for disk space
+func (sm *StreamManager) SetCompressAtRest(compress bool) {
+	sm.compressAtRest = compress
+}
+
+// SetDigestAlgorithm selects the digest FinalizeStream computes over each
+// stream: DigestSHA256, or DigestTreeSHA256 to hash segments in parallel
+func (sm *StreamManager) SetDigestAlgorithm(algorithm string) {
+	sm.digestAlgorithm = algorithm
+}
+
+// SetStorageBackend makes FinalizeStream offload streams to backend and serve
+// them from there. Passing nil restores the local cache.
+func (sm *StreamManager) SetStorageBackend(backend StorageBackend) {
+	if backend == nil {
+		backend = sm.cache
+	}
+	sm.storage = backend
+}
+
+// LocalCache returns the local cache backend
+func (sm *StreamManager) LocalCache() *LocalStorage {
+	return sm.cache
+}
+
+// MaxUploadDuration returns the configured upload time limit (0 when unlimited)
+func (sm *StreamManager) MaxUploadDuration() time.Duration {
+	return sm.maxUploadDuration
 }
 
 // CreateStream creates a new stream
@@ -64,6 +181,9 @@ func (sm *StreamManager) CreateStream(streamID string) bool {
 		return false
 	}
 	context.MmapFile = mmapFile
+	if sm.verifyOnFinalize {
+		context.writeDigest = sha256.New()
+	}
 
 	// Add to registry
 	sm.streams[streamID] = context
@@ -72,6 +192,61 @@ func (sm *StreamManager) CreateStream(streamID string) bool {
 	return true
 }
 
+// SetDeclaredSize records the total size the uploader announced for a stream;
+// writes beyond it are rejected
+func (sm *StreamManager) SetDeclaredSize(streamID string, size int64) bool {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return false
+	}
+
+	stream.Mu.Lock()
+	stream.DeclaredSize = size
+	stream.Mu.Unlock()
+	return true
+}
+
+// NextFrame returns the sequence number of the next frame a sequenced upload
+// should apply
+func (sm *StreamManager) NextFrame(streamID string) uint32 {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return 0
+	}
+
+	stream.Mu.Lock()
+	defer stream.Mu.Unlock()
+	return stream.NextFrame
+}
+
+// SetNextFrame records that the frames of a sequenced upload below next
+// have been applied
+func (sm *StreamManager) SetNextFrame(streamID string, next uint32) bool {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return false
+	}
+
+	stream.Mu.Lock()
+	stream.NextFrame = next
+	stream.Mu.Unlock()
+	return true
+}
+
+// SetCompressionLevel records the deflate level requested in START for
+// compressing the stream's GET responses
+func (sm *StreamManager) SetCompressionLevel(streamID string, level int) bool {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return false
+	}
+
+	stream.Mu.Lock()
+	stream.CompressionLevel = level
+	stream.Mu.Unlock()
+	return true
+}
+
 // GetStream retrieves a stream context
 func (sm *StreamManager) GetStream(streamID string) *StreamContext {
 	sm.mutex.RLock()
@@ -99,19 +274,36 @@ func (sm *StreamManager) DeleteStream(streamID string) bool {
 	if context.MmapFile != nil {
 		context.MmapFile.Close()
 	}
+	removeVariants(context)
+	if context.Compressed != nil {
+		if err := context.Compressed.Delete(); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to delete compressed cache for stream %s: %v", streamID, err))
+		}
+	}
 
-	// Remove cache file
-	if _, err := os.Stat(context.CachePath); err == nil {
-		os.Remove(context.CachePath)
+	// Remove the cache file, or the offloaded object
+	storage := StorageBackend(sm.cache)
+	if context.Offloaded {
+		storage = sm.storage
+	}
+	if err := storage.Delete(streamID); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to delete stored data for stream %s: %v", streamID, err))
 	}
 
 	// Remove from registry
+	sm.dedup.remove(context.Digest, streamID)
 	delete(sm.streams, streamID)
+	sm.deleted.Add(1)
 
 	logger.Debug(fmt.Sprintf("Deleted stream: %s", streamID))
 	return true
 }
 
+// DeletedStreams returns how many streams have been deleted since start
+func (sm *StreamManager) DeletedStreams() int64 {
+	return sm.deleted.Load()
+}
+
 // ListActiveStreams returns list of active stream IDs
 func (sm *StreamManager) ListActiveStreams() []string {
 	sm.mutex.RLock()
@@ -124,12 +316,89 @@ func (sm *StreamManager) ListActiveStreams() []string {
 	return streams
 }
 
-// WriteChunk writes data to a stream
-func (sm *StreamManager) WriteChunk(streamID string, data []byte) bool {
+// StreamInfo is a point-in-time copy of a stream's metadata
+type StreamInfo struct {
+	StreamID         string
+	Status           StreamStatus
+	Size             int64
+	CreatedAt        time.Time
+	FinalizedAt      time.Time // Zero until the stream is READY
+	LastAccessedAt   time.Time
+	ReadCount        int64
+	WriteCount       int64
+	CompressionLevel int    // Requested deflate level; 0 uses the default
+	Digest           string // Hex digest computed on finalize, empty before
+	DigestAlgorithm  string
+	Variants         []StreamVariant // Transcoded copies, ordered by format
+}
+
+// GetStreamInfo returns metadata for one stream
+func (sm *StreamManager) GetStreamInfo(streamID string) (StreamInfo, bool) {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return StreamInfo{}, false
+	}
+
+	stream.Mu.Lock()
+	defer stream.Mu.Unlock()
+	return StreamInfo{
+		StreamID:         stream.StreamID,
+		Status:           stream.Status,
+		Size:             stream.TotalSize,
+		CreatedAt:        stream.CreatedAt,
+		FinalizedAt:      stream.FinalizedAt,
+		LastAccessedAt:   stream.LastAccessedAt(),
+		ReadCount:        stream.ReadCount.Load(),
+		WriteCount:       stream.WriteCount.Load(),
+		CompressionLevel: stream.CompressionLevel,
+		Digest:           stream.Digest,
+		DigestAlgorithm:  stream.DigestAlgorithm,
+		Variants:         sortedVariants(stream),
+	}, true
+}
+
+// SnapshotStreams returns metadata for every registered stream
+func (sm *StreamManager) SnapshotStreams() []StreamInfo {
+	sm.mutex.RLock()
+	contexts := make([]*StreamContext, 0, len(sm.streams))
+	for _, context := range sm.streams {
+		contexts = append(contexts, context)
+	}
+	sm.mutex.RUnlock()
+
+	infos := make([]StreamInfo, 0, len(contexts))
+	for _, context := range contexts {
+		context.Mu.Lock()
+		infos = append(infos, StreamInfo{
+			StreamID:         context.StreamID,
+			Status:           context.Status,
+			Size:             context.TotalSize,
+			CreatedAt:        context.CreatedAt,
+			FinalizedAt:      context.FinalizedAt,
+			LastAccessedAt:   context.LastAccessedAt(),
+			ReadCount:        context.ReadCount.Load(),
+			WriteCount:       context.WriteCount.Load(),
+			CompressionLevel: context.CompressionLevel,
+			Digest:           context.Digest,
+			DigestAlgorithm:  context.DigestAlgorithm,
+		})
+		context.Mu.Unlock()
+	}
+	return infos
+}
+
+// WriteChunk writes data to a stream. Empty data is a successful no-op.
+// The returned error describes why the write was refused.
+func (sm *StreamManager) WriteChunk(streamID string, data []byte) error {
 	stream := sm.GetStream(streamID)
 	if stream == nil {
 		logger.Debug(fmt.Sprintf("Stream not found for write: %s", streamID))
-		return false
+		return fmt.Errorf("stream not found: %s", streamID)
+	}
+
+	if len(data) == 0 {
+		logger.Debug(fmt.Sprintf("Ignoring empty write to stream %s", streamID))
+		return nil
 	}
 
 	// Lock the stream context for thread-safe access
@@ -138,31 +407,110 @@ func (sm *StreamManager) WriteChunk(streamID string, data []byte) bool {
 
 	if stream.Status != StatusUploading {
 		logger.Debug(fmt.Sprintf("Stream %s is not in uploading state", streamID))
-		return false
+		return fmt.Errorf("stream %s is not uploading (status %s)", streamID, stream.Status)
+	}
+
+	if stream.DeclaredSize > 0 && stream.TotalSize+int64(len(data)) > stream.DeclaredSize {
+		logger.Warn(fmt.Sprintf("Rejected %d bytes for stream %s: would exceed declared size %d (current %d)",
+			len(data), streamID, stream.DeclaredSize, stream.TotalSize))
+		return &LimitError{Name: "declaredSize", Limit: stream.DeclaredSize,
+			Message: fmt.Sprintf("write of %d bytes to stream %s would exceed declared size %d (current %d)",
+				len(data), streamID, stream.DeclaredSize, stream.TotalSize)}
+	}
+
+	if sm.maxUploadDuration > 0 {
+		if elapsed := time.Since(stream.CreatedAt); elapsed > sm.maxUploadDuration {
+			stream.Status = StatusError
+			logger.Warn(fmt.Sprintf("Stream %s exceeded max upload duration (%v > %v)", streamID, elapsed.Round(time.Second), sm.maxUploadDuration))
+			return &LimitError{Name: "maxUploadDurationMs", Limit: sm.maxUploadDuration.Milliseconds(),
+				Message: fmt.Sprintf("stream %s exceeded max upload duration %v (limit %d ms)",
+					streamID, sm.maxUploadDuration, sm.maxUploadDuration.Milliseconds())}
+		}
 	}
 
 	// Write data to memory-mapped file
-	n, err := stream.MmapFile.Write(stream.CurrentOffset, data)
+	offset := stream.CurrentOffset
+	n, err := writeWithTimeout(sm.writeTimeout, func() (int, error) {
+		return stream.MmapFile.Write(offset, data)
+	})
+	if errors.Is(err, ErrWriteTimeout) {
+		stream.Status = StatusError
+		logger.Error(fmt.Sprintf("Write of %d bytes to stream %s at offset %d did not complete within %v",
+			len(data), streamID, offset, sm.writeTimeout))
+		return fmt.Errorf("write to stream %s failed: %w after %v", streamID, err, sm.writeTimeout)
+	}
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error writing to stream %s: %v", streamID, err))
-		return false
+		return fmt.Errorf("write to stream %s failed: %w", streamID, err)
+	}
+
+	if sm.selfVerify && n > 0 {
+		readBack, err := stream.MmapFile.Read(stream.CurrentOffset, n)
+		if err != nil || !bytes.Equal(readBack, data[:n]) {
+			stream.Status = StatusError
+			logger.Error(fmt.Sprintf("Self-verify failed for stream %s: %d bytes at offset %d read back differently (err: %v)",
+				streamID, n, stream.CurrentOffset, err))
+			return fmt.Errorf("self-verify of stream %s failed at offset %d", streamID, stream.CurrentOffset)
+		}
 	}
 
 	if n > 0 {
+		if stream.writeDigest != nil {
+			stream.writeDigest.Write(data[:n])
+		}
 		stream.CurrentOffset += int64(n)
 		stream.TotalSize += int64(n)
+		stream.WriteCount.Add(1)
 		stream.UpdateAccessTime()
 
 		logger.Debug(fmt.Sprintf("Wrote %d bytes to stream %s at offset %d", n, streamID, stream.CurrentOffset-int64(n)))
-		return true
+		return nil
 	}
 
 	logger.Debug(fmt.Sprintf("Failed to write data to stream %s", streamID))
-	return false
+	return fmt.Errorf("no data written to stream %s", streamID)
 }
 
+// writeWithTimeout runs write and gives up waiting for it after timeout,
+// returning ErrWriteTimeout; zero waits indefinitely. A write that timed out
+// keeps running in the background until the underlying I/O returns.
+func writeWithTimeout(timeout time.Duration, write func() (int, error)) (int, error) {
+	if timeout <= 0 {
+		return write()
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := write()
+		done <- result{n, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-timer.C:
+		return 0, ErrWriteTimeout
+	}
+}
+
+// readSliceSize bounds each read of a large chunk so a cancelled read stops
+// after at most this many more bytes
+const readSliceSize = 1024 * 1024
+
 // ReadChunk reads data from a stream
 func (sm *StreamManager) ReadChunk(streamID string, offset int64, length int) []byte {
+	return sm.ReadChunkContext(context.Background(), streamID, offset, length)
+}
+
+// ReadChunkContext reads data from a stream in slices, giving up with no data
+// once ctx is done, e.g. because the requesting client disconnected
+func (sm *StreamManager) ReadChunkContext(ctx context.Context, streamID string, offset int64, length int) []byte {
 	stream := sm.GetStream(streamID)
 	if stream == nil {
 		logger.Debug(fmt.Sprintf("Stream not found for read: %s", streamID))
@@ -173,24 +521,60 @@ func (sm *StreamManager) ReadChunk(streamID string, offset int64, length int) []
 	stream.Mu.Lock()
 	defer stream.Mu.Unlock()
 
-	// Read data from memory-mapped file
-	data, err := stream.MmapFile.Read(offset, length)
-	if err != nil {
-		logger.Error(fmt.Sprintf("Error reading from stream %s: %v", streamID, err))
+	if stream.Status == StatusIncomplete || stream.Status == StatusError {
+		logger.Debug(fmt.Sprintf("Refusing read from stream %s in %s state", streamID, stream.Status))
 		return []byte{}
 	}
 
+	// Read data from the memory-mapped file, or a range of the offloaded object
+	read := func(offset int64, length int) ([]byte, error) {
+		if stream.Offloaded {
+			return sm.storage.Get(streamID, offset, length)
+		}
+		if stream.Compressed != nil {
+			return stream.Compressed.Read(offset, length)
+		}
+		return stream.MmapFile.Read(offset, length)
+	}
+
+	var data []byte
+	for len(data) < length {
+		if err := ctx.Err(); err != nil {
+			logger.Debug(fmt.Sprintf("Read from stream %s at offset %d cancelled after %d bytes: %v", streamID, offset, len(data), err))
+			return []byte{}
+		}
+		want := min(readSliceSize, length-len(data))
+		slice, err := read(offset+int64(len(data)), want)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error reading from stream %s: %v", streamID, err))
+			return []byte{}
+		}
+		if data == nil && len(slice) == want && want == length {
+			data = slice // Whole chunk in one read
+			break
+		}
+		data = append(data, slice...)
+		if len(slice) < want {
+			break // End of data written so far
+		}
+	}
+
+	if len(data) > 0 {
+		stream.ReadCount.Add(1)
+	}
 	stream.UpdateAccessTime()
 	logger.Debug(fmt.Sprintf("Read %d bytes from stream %s at offset %d", len(data), streamID, offset))
 	return data
 }
 
-// FinalizeStream finalizes a stream
-func (sm *StreamManager) FinalizeStream(streamID string) bool {
+// FinalizeStream finalizes a stream. The returned error describes why it
+// could not be finalized; an I/O failure (e.g. ENOSPC on sync) wraps
+// ErrFinalizeFailed and moves the stream to ERROR.
+func (sm *StreamManager) FinalizeStream(streamID string) error {
 	stream := sm.GetStream(streamID)
 	if stream == nil {
 		logger.Debug(fmt.Sprintf("Stream not found for finalization: %s", streamID))
-		return false
+		return fmt.Errorf("stream not found: %s", streamID)
 	}
 
 	// Lock the stream context for thread-safe access
@@ -199,37 +583,187 @@ func (sm *StreamManager) FinalizeStream(streamID string) bool {
 
 	if stream.Status != StatusUploading {
 		logger.Debug(fmt.Sprintf("Stream %s is not in uploading state for finalization", streamID))
-		return false
+		return fmt.Errorf("stream %s is not uploading (status %s)", streamID, stream.Status)
+	}
+
+	// A WAV header written before the upload was cut short may claim the wrong sizes
+	if sm.repairWav {
+		repaired, err := sm.repairWavStream(stream)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to repair WAV header for stream %s: %v", streamID, err))
+		}
+		if repaired && stream.writeDigest != nil {
+			// The header no longer matches what was written
+			logger.Debug(fmt.Sprintf("Skipping read-back verification of stream %s: WAV header was rewritten", streamID))
+			stream.writeDigest = nil
+		}
 	}
 
 	// Finalize memory-mapped file
 	if err := stream.MmapFile.Finalize(stream.TotalSize); err != nil {
 		logger.Error(fmt.Sprintf("Failed to finalize memory-mapped file for stream %s: %v", streamID, err))
-		return false
+		sm.failFinalize(stream)
+		return fmt.Errorf("%w: stream %s: %v", ErrFinalizeFailed, streamID, err)
+	}
+
+	// Catch storage corruption before the stream is served
+	if stream.writeDigest != nil {
+		if err := verifyReadBack(stream); err != nil {
+			logger.Error(fmt.Sprintf("Read-back verification failed for stream %s: %v", streamID, err))
+			sm.failFinalize(stream)
+			return fmt.Errorf("%w: stream %s: %w", ErrFinalizeFailed, streamID, err)
+		}
+		stream.writeDigest = nil
+		logger.Debug(fmt.Sprintf("Read-back verification passed for stream %s", streamID))
+	}
+
+	start := time.Now()
+	digest, err := computeDigest(stream.MmapFile.Read, stream.TotalSize, sm.digestAlgorithm)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to compute %s digest for stream %s: %v", sm.digestAlgorithm, streamID, err))
+	} else {
+		stream.Digest = digest
+		stream.DigestAlgorithm = sm.digestAlgorithm
+		sm.dedup.add(digest, sm.digestAlgorithm, streamID)
+		logger.Debug(fmt.Sprintf("Computed %s digest of stream %s in %v", sm.digestAlgorithm, streamID, time.Since(start)))
 	}
 
 	stream.Status = StatusReady
+	stream.FinalizedAt = time.Now()
 	stream.UpdateAccessTime()
 
+	// Transcode from the cache file before it is offloaded or compressed
+	if stream.TranscodeTarget != "" {
+		sm.transcodeStream(stream)
+	}
+	if sm.storage != StorageBackend(sm.cache) {
+		sm.offloadStream(stream)
+	}
+	if sm.compressAtRest && !stream.Offloaded {
+		sm.compressStream(stream)
+	}
+
 	logger.Debug(fmt.Sprintf("Finalized stream: %s with %d bytes", streamID, stream.TotalSize))
+	return nil
+}
+
+// failFinalize moves a stream whose cache could not be finalized to ERROR and,
+// unless failed caches are kept for inspection, removes the partial file
+// (caller holds stream.Mu)
+func (sm *StreamManager) failFinalize(stream *StreamContext) {
+	stream.Status = StatusError
+	if sm.keepFailedCache {
+		logger.Warn(fmt.Sprintf("Keeping partial cache file %s of failed stream %s", stream.CachePath, stream.StreamID))
+		return
+	}
+
+	stream.MmapFile.Close()
+	stream.MmapFile = nil
+	if err := sm.cache.Delete(stream.StreamID); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to remove cache file of failed stream %s: %v", stream.StreamID, err))
+	}
+}
+
+// offloadStream puts a finalized stream into the storage backend and drops the
+// local cache file (caller holds stream.Mu). On failure the stream keeps being
+// served from the cache.
+func (sm *StreamManager) offloadStream(stream *StreamContext) {
+	if err := sm.storage.Put(stream.StreamID, stream.CachePath); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to offload stream %s, serving it from the local cache: %v", stream.StreamID, err))
+		return
+	}
+
+	stream.MmapFile.Close()
+	stream.MmapFile = nil
+	stream.Offloaded = true
+	if err := sm.cache.Delete(stream.StreamID); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to remove cache file for offloaded stream %s: %v", stream.StreamID, err))
+	}
+	logger.Debug(fmt.Sprintf("Offloaded stream %s (%d bytes) to storage", stream.StreamID, stream.TotalSize))
+}
+
+// compressStream replaces a finalized stream's cache file with a compressed
+// one (caller holds stream.Mu). The plain file is kept when compression
+// fails or does not save space, as with most already compressed audio.
+func (sm *StreamManager) compressStream(stream *StreamContext) {
+	start := time.Now()
+	compressed, err := compressCache(stream.MmapFile.Read, stream.TotalSize, stream.CachePath+".gz")
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to compress stream %s, keeping it uncompressed: %v", stream.StreamID, err))
+		return
+	}
+	if compressed.CompressedSize() >= stream.TotalSize {
+		logger.Debug(fmt.Sprintf("Stream %s does not compress (%d -> %d bytes), keeping it uncompressed",
+			stream.StreamID, stream.TotalSize, compressed.CompressedSize()))
+		compressed.Delete()
+		return
+	}
+
+	stream.MmapFile.Close()
+	stream.MmapFile = nil
+	stream.Compressed = compressed
+	if err := sm.cache.Delete(stream.StreamID); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to remove uncompressed cache file for stream %s: %v", stream.StreamID, err))
+	}
+	logger.Debug(fmt.Sprintf("Compressed stream %s from %d to %d bytes in %v",
+		stream.StreamID, stream.TotalSize, compressed.CompressedSize(), time.Since(start)))
+}
+
+// FailStream moves an UPLOADING stream to ERROR, e.g. after corrupted data,
+// so it is never served
+func (sm *StreamManager) FailStream(streamID string) bool {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return false
+	}
+
+	stream.Mu.Lock()
+	defer stream.Mu.Unlock()
+
+	if stream.Status != StatusUploading {
+		return false
+	}
+
+	stream.Status = StatusError
+	logger.Warn(fmt.Sprintf("Stream %s failed after %d bytes", streamID, stream.TotalSize))
+	return true
+}
+
+// MarkIncomplete flags an UPLOADING stream whose uploader went away before STOP,
+// so partial data is never served as a complete file
+func (sm *StreamManager) MarkIncomplete(streamID string) bool {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return false
+	}
+
+	stream.Mu.Lock()
+	defer stream.Mu.Unlock()
+
+	if stream.Status != StatusUploading {
+		return false
+	}
+
+	stream.Status = StatusIncomplete
+	logger.Warn(fmt.Sprintf("Stream %s marked incomplete after %d bytes (uploader disconnected before STOP)", streamID, stream.TotalSize))
 	return true
 }
 
 // CleanupOldStreams cleans up streams older than maxAgeHours
 func (sm *StreamManager) CleanupOldStreams(maxAgeHours int) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	now := time.Now()
 	cutoff := time.Duration(maxAgeHours) * time.Hour
 
+	// Collect under the read lock; DeleteStream takes the write lock itself
+	sm.mutex.RLock()
 	var toRemove []string
 	for streamID, context := range sm.streams {
-		age := now.Sub(context.LastAccessedAt)
+		age := now.Sub(context.LastAccessedAt())
 		if age > cutoff {
 			toRemove = append(toRemove, streamID)
 		}
 	}
+	sm.mutex.RUnlock()
 
 	for _, streamID := range toRemove {
 		logger.Debug(fmt.Sprintf("Cleaning up old stream: %s", streamID))
@@ -239,5 +773,5 @@ func (sm *StreamManager) CleanupOldStreams(maxAgeHours int) {
 
 // getCachePath returns cache file path for a stream
 func (sm *StreamManager) getCachePath(streamID string) string {
-	return filepath.Join(sm.cacheDirectory, streamID+".cache")
+	return sm.cache.Path(streamID)
 }
@@ -0,0 +1,246 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamPayload returns size deterministic bytes distinct for each seed
+func streamPayload(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// uploadStream creates streamID, writes data in chunks of chunkSize and finalizes it
+func uploadStream(sm *StreamManager, streamID string, data []byte, chunkSize int) error {
+	if !sm.CreateStream(streamID) {
+		return fmt.Errorf("create %s failed", streamID)
+	}
+	for offset := 0; offset < len(data); offset += chunkSize {
+		end := min(offset+chunkSize, len(data))
+		if err := sm.WriteChunk(streamID, data[offset:end]); err != nil {
+			return fmt.Errorf("write %s at %d: %w", streamID, offset, err)
+		}
+	}
+	return sm.FinalizeStream(streamID)
+}
+
+func TestConcurrentUploadsStayIsolated(t *testing.T) {
+	sm := NewStreamManager(t.TempDir(), t.TempDir())
+	const uploads = 16
+
+	var wg sync.WaitGroup
+	errs := make(chan error, uploads)
+	stop := make(chan struct{})
+
+	// Housekeeping runs alongside the uploads, as the server's cleanup does
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sm.CleanupOldStreams(24)
+				sm.ListActiveStreams()
+			}
+		}
+	}()
+
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			streamID := fmt.Sprintf("stress-%02d", i)
+			data := streamPayload(int64(i), 50000+i*7919)
+			if err := uploadStream(sm, streamID, data, 1000+i*37); err != nil {
+				errs <- err
+				return
+			}
+
+			info, ok := sm.GetStreamInfo(streamID)
+			if !ok || info.Status != StatusReady || info.Size != int64(len(data)) {
+				errs <- fmt.Errorf("%s: info %+v, want READY with %d bytes", streamID, info, len(data))
+				return
+			}
+			sum := sha256.Sum256(data)
+			if info.Digest != hex.EncodeToString(sum[:]) {
+				errs <- fmt.Errorf("%s: digest %s, want %x", streamID, info.Digest, sum)
+				return
+			}
+			if got := sm.ReadChunk(streamID, 0, len(data)); !bytes.Equal(got, data) {
+				errs <- fmt.Errorf("%s: read back %d bytes that differ from the %d written", streamID, len(got), len(data))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSelfVerifyPassesNormalUpload(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetSelfVerify(true)
+
+	data := streamPayload(1231, 300000)
+	if err := uploadStream(sm, "self-verify", data, 4096); err != nil {
+		t.Fatalf("upload with self-verify: %v", err)
+	}
+	info, ok := sm.GetStreamInfo("self-verify")
+	if !ok || info.Status != StatusReady || info.Size != int64(len(data)) {
+		t.Fatalf("info %+v, want READY with %d bytes", info, len(data))
+	}
+	if got := sm.ReadChunk("self-verify", 0, len(data)); !bytes.Equal(got, data) {
+		t.Fatalf("read back %d bytes that differ from the %d written", len(got), len(data))
+	}
+}
+
+func TestWriteTimeoutFailsStalledWrite(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetWriteTimeout(50 * time.Millisecond)
+
+	// The first write stalls until the test ends, like a hung disk
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	stalled := false
+	useMockCacheFiles(t, mockCacheFile{
+		writeAt: func(file CacheFile, p []byte, off int64) (int, error) {
+			if !stalled {
+				stalled = true
+				<-release
+				defer close(finished)
+			}
+			return file.WriteAt(p, off)
+		},
+	})
+	t.Cleanup(func() {
+		close(release)
+		<-finished
+	})
+
+	if !sm.CreateStream("stalled") {
+		t.Fatal("create failed")
+	}
+	start := time.Now()
+	err := sm.WriteChunk("stalled", []byte("never lands"))
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("stalled write returned %v, want ErrWriteTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stalled write returned after %v, want about the 50ms timeout", elapsed)
+	}
+
+	info, ok := sm.GetStreamInfo("stalled")
+	if !ok || info.Status != StatusError {
+		t.Fatalf("info %+v, want the stream in ERROR", info)
+	}
+	if err := sm.WriteChunk("stalled", []byte("more")); err == nil {
+		t.Fatal("write to a stream in ERROR succeeded")
+	}
+}
+
+func TestWriteTimeoutAllowsPromptWrites(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetWriteTimeout(time.Second)
+	useMockCacheFiles(t, mockCacheFile{
+		writeAt: func(file CacheFile, p []byte, off int64) (int, error) {
+			time.Sleep(time.Millisecond) // Slow, but well within the timeout
+			return file.WriteAt(p, off)
+		},
+	})
+
+	data := streamPayload(1238, 20000)
+	if err := uploadStream(sm, "prompt", data, 4096); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if info, _ := sm.GetStreamInfo("prompt"); info.Status != StatusReady || info.Size != int64(len(data)) {
+		t.Fatalf("info %+v, want READY with %d bytes", info, len(data))
+	}
+}
+
+func TestFinalizeSyncFailure(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep=%v", keep), func(t *testing.T) {
+			sm := NewStreamManager(t.TempDir())
+			sm.SetKeepFailedCache(keep)
+			useMockCacheFiles(t, mockCacheFile{
+				sync: func(CacheFile) error { return errors.New("injected sync failure") },
+			})
+
+			if !sm.CreateStream("sync-fail") {
+				t.Fatal("create failed")
+			}
+			if err := sm.WriteChunk("sync-fail", []byte("not durable")); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			cachePath := sm.GetStream("sync-fail").CachePath
+
+			err := sm.FinalizeStream("sync-fail")
+			if !errors.Is(err, ErrFinalizeFailed) {
+				t.Fatalf("finalize returned %v, want ErrFinalizeFailed", err)
+			}
+			if info, _ := sm.GetStreamInfo("sync-fail"); info.Status != StatusError {
+				t.Fatalf("stream is %s after a failed sync, want ERROR", info.Status)
+			}
+			if _, err := os.Stat(cachePath); keep != (err == nil) {
+				t.Fatalf("cache file stat error %v with keep=%v", err, keep)
+			}
+		})
+	}
+}
+
+func TestReadChunkStopsWhenClientDisconnects(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+
+	// The client goes away while the second slice of a large GET is being read
+	reads := 0
+	armed := false
+	useMockCacheFiles(t, mockCacheFile{
+		readAt: func(file CacheFile, p []byte, off int64) (int, error) {
+			if armed {
+				if reads++; reads == 2 {
+					disconnect()
+				}
+			}
+			return file.ReadAt(p, off)
+		},
+	})
+
+	const slices = 8
+	data := streamPayload(1, slices*readSliceSize)
+	if err := uploadStream(sm, "disconnect-mid-get", data, readSliceSize); err != nil {
+		t.Fatal(err)
+	}
+
+	armed = true
+	if got := sm.ReadChunkContext(ctx, "disconnect-mid-get", 0, len(data)); len(got) != 0 {
+		t.Errorf("cancelled read returned %d bytes, want none", len(got))
+	}
+	if reads != 2 {
+		t.Errorf("%d of %d slices read, want reading to stop after the disconnect", reads, slices)
+	}
+
+	// The same read runs to the end while the client stays connected
+	reads = 0
+	if got := sm.ReadChunkContext(context.Background(), "disconnect-mid-get", 0, len(data)); !bytes.Equal(got, data) {
+		t.Errorf("read returned %d bytes, want the whole %d-byte stream", len(got), len(data))
+	}
+	if reads != slices {
+		t.Errorf("%d slices read, want %d", reads, slices)
+	}
+}
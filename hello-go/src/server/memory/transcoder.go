@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// Transcoder converts a finalized stream into another audio format. Streams
+// whose START asked for a target format are transcoded on finalize and the
+// result is kept next to the cache file as a variant of the stream.
+type Transcoder interface {
+	// Transcode converts the file at src, detected as format from (empty when
+	// unknown), into format to, writing the result to dst
+	Transcode(src, dst, from, to string) error
+}
+
+// ErrTranscodeUnsupported is returned for conversions a transcoder cannot do
+var ErrTranscodeUnsupported = errors.New("transcode not supported")
+
+// NewTranscoder parses a --transcoder value: "passthrough" (the default) only
+// copies streams already in the target format, "ffmpeg" runs ffmpeg from PATH
+func NewTranscoder(spec string) (Transcoder, error) {
+	switch spec {
+	case "", "passthrough":
+		return PassthroughTranscoder{}, nil
+	case "ffmpeg":
+		return FFmpegTranscoder{Binary: "ffmpeg"}, nil
+	default:
+		return nil, fmt.Errorf("unknown transcoder %q (expected passthrough or ffmpeg)", spec)
+	}
+}
+
+// PassthroughTranscoder copies a stream that is already in the target
+// format and refuses real conversions
+type PassthroughTranscoder struct{}
+
+// Transcode copies src to dst when from and to match
+func (PassthroughTranscoder) Transcode(src, dst, from, to string) error {
+	if from != to {
+		return fmt.Errorf("%w: passthrough cannot convert %s to %s", ErrTranscodeUnsupported, formatName(from), to)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// FFmpegTranscoder converts with an ffmpeg binary
+type FFmpegTranscoder struct {
+	Binary string // Path or name of the ffmpeg executable
+}
+
+// Transcode runs ffmpeg, naming the target muxer explicitly since dst has
+// no audio file extension
+func (t FFmpegTranscoder) Transcode(src, dst, from, to string) error {
+	cmd := exec.Command(t.Binary, "-nostdin", "-loglevel", "error", "-y", "-i", src, "-f", to, dst)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg %s to %s failed: %v: %s", formatName(from), to, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// StreamVariant is a transcoded copy of a finalized stream
+type StreamVariant struct {
+	Format string // Target format, e.g. FormatWAV
+	Size   int64
+	Path   string
+}
+
+// SetTranscoder sets the transcoder used for streams that request a variant
+func (sm *StreamManager) SetTranscoder(transcoder Transcoder) {
+	sm.transcoder = transcoder
+}
+
+// SetTranscodeTarget makes FinalizeStream produce a variant of the stream in format
+func (sm *StreamManager) SetTranscodeTarget(streamID string, format string) bool {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return false
+	}
+
+	stream.Mu.Lock()
+	stream.TranscodeTarget = format
+	stream.Mu.Unlock()
+	return true
+}
+
+// transcodeStream produces the variant a finalized stream asked for (caller
+// holds stream.Mu). A failure is logged and leaves the stream without the
+// variant; the stream itself stays READY.
+func (sm *StreamManager) transcodeStream(stream *StreamContext) {
+	target := stream.TranscodeTarget
+	header, _ := stream.MmapFile.Read(0, audioFormatSniffLength)
+	from := DetectAudioFormat(header)
+
+	path := stream.CachePath + "." + target
+	if err := sm.transcoder.Transcode(stream.CachePath, path, from, target); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to transcode stream %s to %s: %v", stream.StreamID, target, err))
+		os.Remove(path)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to stat %s variant of stream %s: %v", target, stream.StreamID, err))
+		return
+	}
+
+	if stream.Variants == nil {
+		stream.Variants = make(map[string]StreamVariant)
+	}
+	stream.Variants[target] = StreamVariant{Format: target, Size: info.Size(), Path: path}
+	logger.Info(fmt.Sprintf("Transcoded stream %s from %s to %s (%d bytes)", stream.StreamID, formatName(from), target, info.Size()))
+}
+
+// ReadVariant reads up to length bytes of a stream's variant in format,
+// starting at offset; fewer bytes are returned at its end
+func (sm *StreamManager) ReadVariant(streamID string, format string, offset int64, length int) ([]byte, error) {
+	stream := sm.GetStream(streamID)
+	if stream == nil {
+		return nil, fmt.Errorf("stream not found: %s", streamID)
+	}
+	stream.Mu.Lock()
+	variant, ok := stream.Variants[format]
+	stream.Mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("stream %s has no %s variant", streamID, format)
+	}
+
+	file, err := os.Open(variant.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buffer := make([]byte, length)
+	n, err := file.ReadAt(buffer, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	stream.ReadCount.Add(1)
+	stream.UpdateAccessTime()
+	return buffer[:n], nil
+}
+
+// Variant returns the stream's variant in format, if it has one
+func (info StreamInfo) Variant(format string) (StreamVariant, bool) {
+	for _, variant := range info.Variants {
+		if variant.Format == format {
+			return variant, true
+		}
+	}
+	return StreamVariant{}, false
+}
+
+// removeVariants deletes the variant files of a stream being deleted
+func removeVariants(stream *StreamContext) {
+	for _, variant := range stream.Variants {
+		if err := os.Remove(variant.Path); err != nil && !os.IsNotExist(err) {
+			logger.Warn(fmt.Sprintf("Failed to delete %s variant of stream %s: %v", variant.Format, stream.StreamID, err))
+		}
+	}
+}
+
+// sortedVariants copies a stream's variants ordered by format (caller holds stream.Mu)
+func sortedVariants(stream *StreamContext) []StreamVariant {
+	if len(stream.Variants) == 0 {
+		return nil
+	}
+	variants := make([]StreamVariant, 0, len(stream.Variants))
+	for _, variant := range stream.Variants {
+		variants = append(variants, variant)
+	}
+	sort.Slice(variants, func(i, j int) bool { return variants[i].Format < variants[j].Format })
+	return variants
+}
+
+// formatName names a detected format for messages
+func formatName(format string) string {
+	if format == "" {
+		return "unknown format"
+	}
+	return format
+}
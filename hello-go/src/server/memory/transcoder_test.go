@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const flacStream = "fLaC\x00\x00\x00\x22frames of flac audio"
+
+func TestPassthroughTranscoder(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte(flacStream), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "same")
+	if err := (PassthroughTranscoder{}).Transcode(src, dst, FormatFLAC, FormatFLAC); err != nil {
+		t.Fatalf("flac to flac: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != flacStream {
+		t.Fatalf("copy holds %q, want the source", got)
+	}
+
+	for _, from := range []string{FormatWAV, ""} {
+		dst := filepath.Join(dir, "converted-"+from)
+		err := (PassthroughTranscoder{}).Transcode(src, dst, from, FormatFLAC)
+		if !errors.Is(err, ErrTranscodeUnsupported) {
+			t.Errorf("%q to flac returned %v, want ErrTranscodeUnsupported", from, err)
+		}
+		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+			t.Errorf("%q to flac left a destination file", from)
+		}
+	}
+}
+
+func TestNewTranscoder(t *testing.T) {
+	for spec, want := range map[string]Transcoder{
+		"":            PassthroughTranscoder{},
+		"passthrough": PassthroughTranscoder{},
+		"ffmpeg":      FFmpegTranscoder{Binary: "ffmpeg"},
+	} {
+		if got, err := NewTranscoder(spec); err != nil || got != want {
+			t.Errorf("NewTranscoder(%q) = %#v, %v; want %#v", spec, got, err, want)
+		}
+	}
+	if _, err := NewTranscoder("sox"); err == nil {
+		t.Error("unknown transcoder accepted")
+	}
+}
+
+func TestPassthroughVariantOnFinalize(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+
+	// Same format: the variant is a copy
+	sm.CreateStream("variant-flac")
+	sm.SetTranscodeTarget("variant-flac", FormatFLAC)
+	if err := sm.WriteChunk("variant-flac", []byte(flacStream)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := sm.FinalizeStream("variant-flac"); err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	info, _ := sm.GetStreamInfo("variant-flac")
+	variant, ok := info.Variant(FormatFLAC)
+	if !ok || variant.Size != int64(len(flacStream)) {
+		t.Fatalf("variants %+v, want a flac copy of %d bytes", info.Variants, len(flacStream))
+	}
+	if got, err := sm.ReadVariant("variant-flac", FormatFLAC, 4, 100); err != nil || !bytes.Equal(got, []byte(flacStream[4:])) {
+		t.Fatalf("ReadVariant = %q, %v", got, err)
+	}
+
+	// A real conversion fails, leaving the stream READY without a variant
+	sm.CreateStream("variant-wav")
+	sm.SetTranscodeTarget("variant-wav", FormatWAV)
+	if err := sm.WriteChunk("variant-wav", []byte(flacStream)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := sm.FinalizeStream("variant-wav"); err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	if info, _ := sm.GetStreamInfo("variant-wav"); info.Status != StatusReady || len(info.Variants) != 0 {
+		t.Fatalf("info %+v, want READY without variants", info)
+	}
+	if _, err := sm.ReadVariant("variant-wav", FormatWAV, 0, 10); err == nil {
+		t.Fatal("ReadVariant of a missing variant succeeded")
+	}
+
+	// Deleting the stream removes its variant
+	sm.DeleteStream("variant-flac")
+	if _, err := os.Stat(variant.Path); !os.IsNotExist(err) {
+		t.Fatalf("variant file still present after delete: %v", err)
+	}
+}
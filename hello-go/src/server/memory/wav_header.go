@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+)
+
+// wavHeaderWindow is how much of a stream is scanned for the RIFF and data
+// chunk headers; chunks such as LIST may precede data
+const wavHeaderWindow = 65536
+
+// repairWavHeader rewrites the RIFF and data chunk sizes in header so they
+// match a file of totalSize bytes. It reports whether header is a WAV file
+// and whether anything was changed.
+func repairWavHeader(header []byte, totalSize int64) (isWav bool, changed bool) {
+	if len(header) < 12 || !bytes.Equal(header[0:4], []byte("RIFF")) || !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return false, false
+	}
+	if totalSize > math.MaxUint32 {
+		return true, false // Sizes cannot be represented in a RIFF header
+	}
+
+	setSize := func(pos int, size int64) {
+		if size < 0 {
+			size = 0
+		}
+		if binary.LittleEndian.Uint32(header[pos:pos+4]) != uint32(size) {
+			binary.LittleEndian.PutUint32(header[pos:pos+4], uint32(size))
+			changed = true
+		}
+	}
+	setSize(4, totalSize-8)
+
+	// Walk the chunks after the RIFF header; data runs to the end of the file
+	pos := 12
+	for pos+8 <= len(header) {
+		chunkSize := int64(binary.LittleEndian.Uint32(header[pos+4 : pos+8]))
+		if bytes.Equal(header[pos:pos+4], []byte("data")) {
+			setSize(pos+4, totalSize-int64(pos+8))
+			break
+		}
+		pos += 8 + int(chunkSize+chunkSize%2)
+	}
+	return true, changed
+}
+
+// repairWavStream fixes the header of a WAV stream in place, reporting whether
+// it changed (caller holds stream.Mu)
+func (sm *StreamManager) repairWavStream(stream *StreamContext) (bool, error) {
+	header, err := stream.MmapFile.Read(0, wavHeaderWindow)
+	if err != nil {
+		return false, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	isWav, changed := repairWavHeader(header, stream.TotalSize)
+	if !isWav || !changed {
+		return false, nil
+	}
+	if _, err := stream.MmapFile.Write(0, header); err != nil {
+		return false, fmt.Errorf("failed to rewrite header: %w", err)
+	}
+	logger.Info(fmt.Sprintf("Rewrote WAV header sizes for stream %s to match %d bytes", stream.StreamID, stream.TotalSize))
+	return true, nil
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// wavFile builds a WAV file with samples bytes of data whose header claims
+// riffSize and dataSize; a non-empty list adds a LIST chunk before data
+func wavFile(samples int, riffSize, dataSize uint32, list string) []byte {
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, riffSize)
+	file.WriteString("WAVEfmt ")
+	binary.Write(&file, binary.LittleEndian, uint32(16))
+	file.Write(make([]byte, 16))
+	if list != "" {
+		file.WriteString("LIST")
+		binary.Write(&file, binary.LittleEndian, uint32(len(list)))
+		file.WriteString(list)
+		if len(list)%2 == 1 {
+			file.WriteByte(0) // Chunks are padded to an even length
+		}
+	}
+	file.WriteString("data")
+	binary.Write(&file, binary.LittleEndian, dataSize)
+	file.Write(bytes.Repeat([]byte{0x7f}, samples))
+	return file.Bytes()
+}
+
+// wavSizes returns the RIFF and data chunk sizes of a file built by wavFile
+func wavSizes(file []byte, list string) (riff, data uint32) {
+	dataHeader := 36
+	if list != "" {
+		dataHeader += 8 + len(list) + len(list)%2
+	}
+	return binary.LittleEndian.Uint32(file[4:8]), binary.LittleEndian.Uint32(file[dataHeader+4 : dataHeader+8])
+}
+
+func TestRepairWavHeader(t *testing.T) {
+	const samples = 100
+	tests := []struct {
+		name     string
+		riff     uint32
+		data     uint32
+		list     string
+		wantEdit bool
+	}{
+		{"correct sizes", 36 + samples, samples, "", false},
+		{"truncated upload", 36 + 1000, 1000, "", true},
+		{"streaming header", math.MaxUint32, math.MaxUint32, "", true},
+		{"zero sizes", 0, 0, "", true},
+		{"only data size wrong", 36 + samples, 7, "", true},
+		{"odd LIST chunk before data", 0, 0, "INFOx", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := wavFile(samples, tt.riff, tt.data, tt.list)
+			isWav, changed := repairWavHeader(file, int64(len(file)))
+			if !isWav || changed != tt.wantEdit {
+				t.Fatalf("repairWavHeader = %v, %v; want true, %v", isWav, changed, tt.wantEdit)
+			}
+			riff, data := wavSizes(file, tt.list)
+			if riff != uint32(len(file)-8) || data != samples {
+				t.Fatalf("repaired sizes RIFF %d, data %d; want %d and %d", riff, data, len(file)-8, samples)
+			}
+		})
+	}
+}
+
+func TestRepairWavHeaderLeavesOtherFiles(t *testing.T) {
+	for _, header := range [][]byte{[]byte("OggS\x00\x02pages"), []byte("RIFF"), []byte("RIFF\x00\x00\x00\x00AVI LIST")} {
+		original := bytes.Clone(header)
+		if isWav, changed := repairWavHeader(header, 1000); isWav || changed || !bytes.Equal(header, original) {
+			t.Errorf("repairWavHeader(%q) = %v, %v and changed the header", original, isWav, changed)
+		}
+	}
+
+	// Sizes past 4 GiB cannot be written, so the header is left alone
+	file := wavFile(10, 0, 0, "")
+	if isWav, changed := repairWavHeader(file, math.MaxUint32+1); !isWav || changed {
+		t.Errorf("oversized stream: repairWavHeader = %v, %v; want true, false", isWav, changed)
+	}
+}
+
+func TestFinalizeRepairsWavHeader(t *testing.T) {
+	sm := NewStreamManager(t.TempDir())
+	sm.SetRepairWavHeaders(true)
+
+	// The header was written for 1000 samples but the upload stopped at 100
+	file := wavFile(100, 36+1000, 1000, "")
+	if err := uploadStream(sm, "wav-repair", file, 16); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	stored := sm.ReadChunk("wav-repair", 0, len(file))
+	if riff, data := wavSizes(stored, ""); riff != uint32(len(file)-8) || data != 100 {
+		t.Fatalf("stored sizes RIFF %d, data %d; want %d and 100", riff, data, len(file)-8)
+	}
+	if !bytes.Equal(stored[44:], file[44:]) {
+		t.Fatal("repair changed the samples")
+	}
+}
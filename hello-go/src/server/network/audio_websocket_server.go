@@ -1,10 +1,21 @@
 package network
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/feuyeux/hello-mmap/hello-go/src/auth"
 	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
 	"github.com/feuyeux/hello-mmap/hello-go/src/server/handler"
 	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
@@ -17,15 +28,42 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for development
 	},
+	Error: upgradeError,
+}
+
+// upgradeError answers a request that could not be upgraded to a WebSocket.
+// Plain HTTP requests get 426 Upgrade Required; other failures (bad
+// handshake headers, rejected origin) keep gorilla's status. The reason is
+// logged with the headers that usually explain it.
+func upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		status = http.StatusUpgradeRequired
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+	}
+	logger.Warn(fmt.Sprintf("Rejected WebSocket upgrade from %s: %d %s: %v (Connection=%q Upgrade=%q Sec-WebSocket-Version=%q Origin=%q)",
+		r.RemoteAddr, status, http.StatusText(status), reason,
+		r.Header.Get("Connection"), r.Header.Get("Upgrade"), r.Header.Get("Sec-WebSocket-Version"), r.Header.Get("Origin")))
+	http.Error(w, fmt.Sprintf("WebSocket upgrade failed: %v", reason), status)
 }
 
 // AudioWebSocketServer handles WebSocket connections for audio streaming
 type AudioWebSocketServer struct {
 	port           int
+	bindAddress    string // Host or IP to listen on; empty means all interfaces
+	bandwidth      *handler.BandwidthLimiter
 	path           string
 	clients        map[*websocket.Conn]string // Maps client to stream ID
 	clientsMutex   *sync.RWMutex
 	messageHandler *handler.WebSocketMessageHandler
+	memoryPool     *memory.MemoryPoolManager
+	streamManager  *memory.StreamManager
+	adminToken     string         // Bearer token for /export and /import; empty disables them
+	authVerifier   *auth.Verifier // Checks the HMAC handshake of connections and HTTP downloads; nil accepts all
+	noDelay        bool           // TCP_NODELAY on accepted connections (Go's default is true)
+	compression    bool           // Negotiate per-message deflate (RFC 7692) with clients that offer it
+	draining       atomic.Bool
+	httpServer     *http.Server
 }
 
 // NewAudioWebSocketServer creates a new WebSocket server
@@ -39,37 +77,359 @@ func NewAudioWebSocketServer(port int, path string, streamMgr *memory.StreamMana
 		clients:        clients,
 		clientsMutex:   clientsMutex,
 		messageHandler: handler.NewWebSocketMessageHandler(streamMgr, memPool, clients, clientsMutex),
+		memoryPool:     memPool,
+		streamManager:  streamMgr,
+		noDelay:        true,
+	}
+}
+
+// MessageHandler returns the handler used for all connections
+func (ws *AudioWebSocketServer) MessageHandler() *handler.WebSocketMessageHandler {
+	return ws.messageHandler
+}
+
+// SetBindAddress restricts the listener to one host or interface address,
+// e.g. 127.0.0.1; empty listens on all interfaces
+func (ws *AudioWebSocketServer) SetBindAddress(address string) {
+	ws.bindAddress = address
+}
+
+// SetBandwidthLimiter caps the total upload and download rate across all
+// connections with one shared limiter
+func (ws *AudioWebSocketServer) SetBandwidthLimiter(limiter *handler.BandwidthLimiter) {
+	ws.bandwidth = limiter
+	ws.messageHandler.SetBandwidthLimiter(limiter)
+}
+
+// SetCompression negotiates per-message deflate with clients that offer it,
+// at the level each stream requested in START
+func (ws *AudioWebSocketServer) SetCompression(enable bool) {
+	ws.compression = enable
+	ws.messageHandler.SetCompression(enable)
+}
+
+// SetAdminToken enables the /export and /import endpoints for requests
+// carrying "Authorization: Bearer <token>"
+func (ws *AudioWebSocketServer) SetAdminToken(token string) {
+	ws.adminToken = token
+}
+
+// SetAuthVerifier requires every WebSocket connection and HTTP stream
+// download to carry an HMAC handshake that verifier accepts
+func (ws *AudioWebSocketServer) SetAuthVerifier(verifier *auth.Verifier) {
+	ws.authVerifier = verifier
+}
+
+// SetNoDelay controls TCP_NODELAY on accepted connections.
+// Disabling it enables Nagle's algorithm, which batches small writes for
+// throughput at the cost of added latency for small frames.
+func (ws *AudioWebSocketServer) SetNoDelay(noDelay bool) {
+	ws.noDelay = noDelay
+}
+
+// Drain stops accepting new connections and new streams while letting
+// existing uploads and downloads run to completion
+func (ws *AudioWebSocketServer) Drain() {
+	if ws.draining.Swap(true) {
+		return
 	}
+	ws.messageHandler.Drain()
+	logger.Info("Server draining: rejecting new connections and streams")
+}
+
+// IsDraining reports whether Drain has been called
+func (ws *AudioWebSocketServer) IsDraining() bool {
+	return ws.draining.Load()
+}
+
+// WaitForStreams blocks until no uploads are active or ctx is done
+func (ws *AudioWebSocketServer) WaitForStreams(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		active := ws.messageHandler.ActiveUploads()
+		if active == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d uploads still active: %w", active, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the HTTP listener and cleans up handler state
+func (ws *AudioWebSocketServer) Shutdown(ctx context.Context) error {
+	defer ws.messageHandler.Close()
+
+	if ws.httpServer == nil {
+		return nil
+	}
+	return ws.httpServer.Shutdown(ctx)
+}
+
+// routes returns the HTTP handler for the WebSocket path and the plain HTTP endpoints
+func (ws *AudioWebSocketServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ws.path, ws.handleConnection)
+	mux.HandleFunc("/healthz", ws.handleHealth)
+	mux.HandleFunc("/metrics", ws.handleMetrics)
+	mux.HandleFunc("/export", ws.handleExport)
+	mux.HandleFunc("/import", ws.handleImport)
+	mux.HandleFunc("GET /streams/{id}", ws.handleStreamDownload)
+	return mux
 }
 
 // Start starts WebSocket server
 func (ws *AudioWebSocketServer) Start() {
-	http.HandleFunc(ws.path, ws.handleConnection)
+	addr := net.JoinHostPort(ws.bindAddress, strconv.Itoa(ws.port))
+	ws.httpServer = &http.Server{Addr: addr, Handler: ws.routes()}
 
-	addr := fmt.Sprintf(":%d", ws.port)
-	logger.Info(fmt.Sprintf("WebSocket server started on ws://0.0.0.0:%d%s", ws.port, ws.path))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to start server: %v", err))
+		return
+	}
+	logger.Info(fmt.Sprintf("WebSocket server started on ws://%s%s", listener.Addr(), ws.path))
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := ws.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Error(fmt.Sprintf("Failed to start server: %v", err))
 	}
 }
 
+// handleHealth reports liveness, drain state and memory pool sizes
+func (ws *AudioWebSocketServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	code := http.StatusOK
+	if ws.IsDraining() {
+		status = "draining"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":        status,
+		"draining":      ws.IsDraining(),
+		"activeUploads": ws.messageHandler.ActiveUploads(),
+		"memoryPool":    ws.memoryPool.Stats(),
+	})
+}
+
+// handleMetrics exposes server counters in the Prometheus text format
+func (ws *AudioWebSocketServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := ws.messageHandler.ServerStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP audio_server_connections Open WebSocket connections.\n")
+	fmt.Fprintf(w, "# TYPE audio_server_connections gauge\n")
+	fmt.Fprintf(w, "audio_server_connections %d\n", stats.Connections)
+	fmt.Fprintf(w, "# HELP audio_server_active_streams Streams still uploading.\n")
+	fmt.Fprintf(w, "# TYPE audio_server_active_streams gauge\n")
+	fmt.Fprintf(w, "audio_server_active_streams %d\n", stats.ActiveStreams)
+	fmt.Fprintf(w, "# HELP audio_server_reaped_connections_total Connections closed by the idle reaper.\n")
+	fmt.Fprintf(w, "# TYPE audio_server_reaped_connections_total counter\n")
+	fmt.Fprintf(w, "audio_server_reaped_connections_total %d\n", stats.ReapedConnections)
+
+	session := ws.messageHandler.SessionStats()
+	fmt.Fprintf(w, "# HELP audio_server_bytes_written_total Upload bytes written to streams.\n")
+	fmt.Fprintf(w, "# TYPE audio_server_bytes_written_total counter\n")
+	fmt.Fprintf(w, "audio_server_bytes_written_total %d\n", session.BytesWritten)
+	fmt.Fprintf(w, "# HELP audio_server_bytes_read_total Bytes sent in GET responses.\n")
+	fmt.Fprintf(w, "# TYPE audio_server_bytes_read_total counter\n")
+	fmt.Fprintf(w, "audio_server_bytes_read_total %d\n", session.BytesRead)
+	fmt.Fprintf(w, "# HELP audio_server_peak_connections Most connections open at once since start.\n")
+	fmt.Fprintf(w, "# TYPE audio_server_peak_connections gauge\n")
+	fmt.Fprintf(w, "audio_server_peak_connections %d\n", session.PeakConnections)
+}
+
+// authorizeHandshake checks the HMAC handshake in the request URL when
+// authentication is on, answering 401 itself on failure
+func (ws *AudioWebSocketServer) authorizeHandshake(w http.ResponseWriter, r *http.Request) bool {
+	if ws.authVerifier == nil {
+		return true
+	}
+	if err := ws.authVerifier.Verify(r.URL.Query()); err != nil {
+		logger.Warn(fmt.Sprintf("Rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err))
+		http.Error(w, fmt.Sprintf("unauthorized: %v", err), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// authorizeAdmin checks the admin bearer token, answering the request itself
+// when it is missing or wrong
+func (ws *AudioWebSocketServer) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if ws.adminToken == "" {
+		http.Error(w, "admin endpoints are disabled (start the server with --admin-token)", http.StatusNotFound)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ws.adminToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleExport streams all finalized streams as a tar archive
+func (ws *AudioWebSocketServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ws.authorizeAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audio-cache-%s.tar"`, time.Now().Format("20060102-150405")))
+	// Headers are already sent, so a failure can only cut the archive short
+	if _, err := ws.streamManager.ExportArchive(w); err != nil {
+		logger.Error(fmt.Sprintf("Export to %s failed: %v", r.RemoteAddr, err))
+	}
+}
+
+// handleImport registers the streams of an uploaded export archive
+func (ws *AudioWebSocketServer) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ws.authorizeAdmin(w, r) {
+		return
+	}
+
+	result, err := ws.streamManager.ImportArchive(r.Body)
+	code := http.StatusOK
+	if err != nil {
+		logger.Error(fmt.Sprintf("Import from %s failed: %v", r.RemoteAddr, err))
+		code = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	response := map[string]any{"result": result}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleStreamDownload serves a finalized stream over plain HTTP, with range
+// support and a Content-Type matching its detected audio format, so browsers
+// and players can open it directly
+func (ws *AudioWebSocketServer) handleStreamDownload(w http.ResponseWriter, r *http.Request) {
+	if !ws.authorizeHandshake(w, r) {
+		return
+	}
+	streamID := r.PathValue("id")
+	info, ok := ws.streamManager.GetStreamInfo(streamID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("stream not found: %s", streamID), http.StatusNotFound)
+		return
+	}
+	if info.Status != memory.StatusReady {
+		http.Error(w, fmt.Sprintf("stream %s is %s, not READY", streamID, info.Status), http.StatusConflict)
+		return
+	}
+
+	// ?variant=<format> serves a transcoded variant instead
+	if format := r.URL.Query().Get("variant"); format != "" {
+		variant, ok := info.Variant(format)
+		if !ok {
+			http.Error(w, fmt.Sprintf("stream %s has no %s variant", streamID, format), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", memory.AudioContentType(variant.Format))
+		http.ServeFile(w, r, variant.Path)
+		return
+	}
+
+	// The content last changed when the stream was finalized; reads must not
+	// move Last-Modified, or conditional requests would never match
+	modTime := info.FinalizedAt
+	if modTime.IsZero() {
+		modTime = info.CreatedAt
+	}
+	w.Header().Set("Content-Type", memory.AudioContentType(ws.streamManager.DetectStreamFormat(streamID)))
+	http.ServeContent(w, r, "", modTime, &streamReader{
+		streamManager: ws.streamManager,
+		streamID:      streamID,
+		size:          info.Size,
+	})
+}
+
+// streamReader reads a finalized stream through the stream manager
+type streamReader struct {
+	streamManager *memory.StreamManager
+	streamID      string
+	size          int64
+	offset        int64
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	data := s.streamManager.ReadChunk(s.streamID, s.offset, int(min(int64(len(p)), s.size-s.offset)))
+	if len(data) == 0 {
+		return 0, fmt.Errorf("failed to read stream %s at offset %d", s.streamID, s.offset)
+	}
+	s.offset += int64(copy(p, data))
+	return len(data), nil
+}
+
+func (s *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
 // handleConnection handles new WebSocket connections
 func (ws *AudioWebSocketServer) handleConnection(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if ws.IsDraining() {
+		http.Error(w, "DRAINING: server is not accepting new connections", http.StatusServiceUnavailable)
+		return
+	}
+	if !ws.authorizeHandshake(w, r) {
+		return
+	}
+
+	connUpgrader := upgrader
+	connUpgrader.EnableCompression = ws.compression
+	conn, err := connUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to upgrade connection: %v", err))
+		// Handshake failures were answered and logged by upgradeError
+		logger.Debug(fmt.Sprintf("Failed to upgrade connection from %s: %v", r.RemoteAddr, err))
 		return
 	}
 	defer conn.Close()
 
+	if tcpConn, ok := conn.UnderlyingConn().(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(ws.noDelay); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to set TCP_NODELAY=%v: %v", ws.noDelay, err))
+		}
+	}
+
 	clientAddr := r.RemoteAddr
 	logger.Info(fmt.Sprintf("Client connected: %s", clientAddr))
 
 	// Register client
-	ws.clientsMutex.Lock()
-	ws.clients[conn] = ""
-	ws.clientsMutex.Unlock()
+	ws.messageHandler.HandleConnect(conn)
 
 	// Handle messages
 	for {
@@ -78,8 +438,12 @@ func (ws *AudioWebSocketServer) handleConnection(w http.ResponseWriter, r *http.
 			logger.Debug(fmt.Sprintf("Client disconnected: %s, error: %v", clientAddr, err))
 			break
 		}
+		ws.messageHandler.RecordRead(conn)
 
 		if messageType == websocket.BinaryMessage {
+			// Throttling the read loop pushes back on the uploader through TCP
+			ws.bandwidth.Wait(len(message))
+
 			ws.clientsMutex.RLock()
 			streamID := ws.clients[conn]
 			ws.clientsMutex.RUnlock()
@@ -89,8 +453,6 @@ func (ws *AudioWebSocketServer) handleConnection(w http.ResponseWriter, r *http.
 		}
 	}
 
-	// Unregister client
-	ws.clientsMutex.Lock()
-	delete(ws.clients, conn)
-	ws.clientsMutex.Unlock()
+	// Unregister client and flag any unfinished upload
+	ws.messageHandler.HandleDisconnect(conn)
 }
@@ -0,0 +1,221 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/auth"
+	"github.com/feuyeux/hello-mmap/hello-go/src/server/memory"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves a WebSocket server with its own stream manager over httptest
+func newTestServer(t *testing.T) (*AudioWebSocketServer, *httptest.Server) {
+	t.Helper()
+	streamMgr := memory.NewStreamManager(t.TempDir())
+	ws := NewAudioWebSocketServer(0, "/audio", streamMgr, memory.GetMemoryPoolManager(65536, 10))
+	server := httptest.NewServer(ws.routes())
+	t.Cleanup(server.Close)
+	return ws, server
+}
+
+// finalizedStream creates a READY stream holding data
+func finalizedStream(t *testing.T, streamMgr *memory.StreamManager, streamID string, data []byte) {
+	t.Helper()
+	if !streamMgr.CreateStream(streamID) {
+		t.Fatalf("create %s failed", streamID)
+	}
+	if err := streamMgr.WriteChunk(streamID, data); err != nil {
+		t.Fatalf("write %s: %v", streamID, err)
+	}
+	if err := streamMgr.FinalizeStream(streamID); err != nil {
+		t.Fatalf("finalize %s: %v", streamID, err)
+	}
+}
+
+func TestStreamDownloadRequiresHandshake(t *testing.T) {
+	ws, server := newTestServer(t)
+	ws.SetAuthVerifier(auth.NewVerifier("http-secret", time.Minute))
+	finalizedStream(t, ws.streamManager, "http-auth", []byte("payload"))
+
+	resp, err := http.Get(server.URL + "/streams/http-auth")
+	if err != nil {
+		t.Fatalf("unsigned GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned GET status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	signed, err := auth.SignURL(server.URL+"/streams/http-auth", "http-secret")
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	resp, err = http.Get(signed)
+	if err != nil {
+		t.Fatalf("signed GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "payload" {
+		t.Fatalf("signed GET = %d %q, want 200 %q", resp.StatusCode, body, "payload")
+	}
+
+	resp, err = http.Get(signed)
+	if err != nil {
+		t.Fatalf("replayed GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed GET status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestStreamDownloadLastModified(t *testing.T) {
+	ws, server := newTestServer(t)
+	finalizedStream(t, ws.streamManager, "http-modified", []byte("payload"))
+	info, _ := ws.streamManager.GetStreamInfo("http-modified")
+	want := info.FinalizedAt.UTC().Format(http.TimeFormat)
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(1100 * time.Millisecond) // Last-Modified has one-second resolution
+		resp, err := http.Get(server.URL + "/streams/http-modified")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Last-Modified"); got != want {
+			t.Fatalf("read %d: Last-Modified = %q, want the finalize time %q", i+1, got, want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/streams/http-modified", nil)
+	req.Header.Set("If-Modified-Since", want)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("conditional GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("conditional GET status = %d, want %d", resp.StatusCode, http.StatusNotModified)
+	}
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	ws, server := newTestServer(t)
+	finalizedStream(t, ws.streamManager, "admin-stream", []byte("payload"))
+
+	request := func(method, path, token string, body io.Reader) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, body)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// Disabled until a token is configured
+	if resp := request(http.MethodGet, "/export", "anything", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("export without --admin-token = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	ws.SetAdminToken("admin-secret")
+	for _, token := range []string{"", "wrong-secret"} {
+		if resp := request(http.MethodGet, "/export", token, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("export with token %q = %d, want %d", token, resp.StatusCode, http.StatusUnauthorized)
+		}
+		if resp := request(http.MethodPost, "/import", token, strings.NewReader("")); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("import with token %q = %d, want %d", token, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	if resp := request(http.MethodPost, "/export", "admin-secret", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /export = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	resp := request(http.MethodGet, "/export", "admin-secret", nil)
+	archive, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("export = %d %s, want 200 application/x-tar", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The archive imports into another server
+	target, targetServer := newTestServer(t)
+	target.SetAdminToken("admin-secret")
+	server = targetServer
+	resp = request(http.MethodPost, "/import", "admin-secret", bytes.NewReader(archive))
+	var response struct {
+		Result memory.ImportResult `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("import = %d, %v", resp.StatusCode, err)
+	}
+	if len(response.Result.Imported) != 1 || response.Result.Imported[0] != "admin-stream" {
+		t.Fatalf("imported %v, want [admin-stream]", response.Result.Imported)
+	}
+	if info, _ := target.streamManager.GetStreamInfo("admin-stream"); info.Status != memory.StatusReady {
+		t.Errorf("imported stream is %s, want READY", info.Status)
+	}
+
+	if resp := request(http.MethodPost, "/import", "admin-secret", strings.NewReader("not a tar archive")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("import of a corrupt archive = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	_, server := newTestServer(t)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"plain GET", http.MethodGet, nil, http.StatusUpgradeRequired},
+		{"plain POST", http.MethodPost, nil, http.StatusUpgradeRequired},
+		{"only Connection", http.MethodGet, map[string]string{"Connection": "Upgrade"}, http.StatusUpgradeRequired},
+		{"unsupported version", http.MethodGet, map[string]string{
+			"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==",
+		}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+"/audio", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d (%s), want %d", resp.StatusCode, body, tt.want)
+			}
+			if !strings.Contains(string(body), "WebSocket upgrade failed") {
+				t.Errorf("body %q does not explain the failure", body)
+			}
+			if tt.want == http.StatusUpgradeRequired && resp.Header.Get("Upgrade") != "websocket" {
+				t.Errorf("426 without Upgrade: websocket (got %q)", resp.Header.Get("Upgrade"))
+			}
+		})
+	}
+
+	// A real WebSocket client still connects
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/audio", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,121 @@
+// Package telemetry is a thin wrapper around OpenTelemetry tracing. Until
+// Init is called with an endpoint, Start returns nil spans and every Span
+// method is a no-op, so instrumented code costs nothing when tracing is off.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/feuyeux/hello-mmap/hello-go/src/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attribute keys
+const (
+	AttrStreamID       = "audio.stream_id"
+	AttrBytes          = "audio.bytes"
+	AttrThroughputMbps = "audio.throughput_mbps"
+)
+
+// shutdownTimeout bounds how long Shutdown waits to export pending spans
+const shutdownTimeout = 5 * time.Second
+
+var (
+	tracer   trace.Tracer // nil while tracing is disabled
+	provider *sdktrace.TracerProvider
+)
+
+// Init exports spans over OTLP/HTTP to endpoint, e.g. http://localhost:4318,
+// naming this process service. An empty endpoint leaves tracing disabled.
+func Init(endpoint, service string) error {
+	if endpoint == "" {
+		return nil
+	}
+
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(url))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	tracer = provider.Tracer("github.com/feuyeux/hello-mmap/hello-go")
+	logger.Info(fmt.Sprintf("Exporting OpenTelemetry spans to %s", endpoint))
+	return nil
+}
+
+// Shutdown exports spans still buffered; call it before the process exits
+func Shutdown() {
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to export OpenTelemetry spans: %v", err))
+	}
+}
+
+// Span is a started span that records the bytes it transferred
+type Span struct {
+	span  trace.Span
+	start time.Time
+	bytes int64
+}
+
+// Start begins a span named name, a child of any span in ctx (which may be
+// nil). It returns ctx unchanged and a nil span while tracing is disabled.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, &Span{span: span, start: time.Now()}
+}
+
+// SetStreamID records the stream the span works on; an empty ID (e.g. an
+// upload that failed before one was assigned) is not recorded
+func (s *Span) SetStreamID(streamID string) {
+	if s == nil || streamID == "" {
+		return
+	}
+	s.span.SetAttributes(attribute.String(AttrStreamID, streamID))
+}
+
+// SetBytes records how many bytes the span transferred; End derives the
+// throughput from it
+func (s *Span) SetBytes(n int64) {
+	if s == nil {
+		return
+	}
+	s.bytes = n
+	s.span.SetAttributes(attribute.Int64(AttrBytes, n))
+}
+
+// End finishes the span, marking it failed when err is non-nil
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if elapsed := time.Since(s.start); s.bytes > 0 && elapsed > 0 {
+		s.span.SetAttributes(attribute.Float64(AttrThroughputMbps, float64(s.bytes*8)/elapsed.Seconds()/1_000_000))
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}